@@ -0,0 +1,488 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rivo/tview"
+	"golang.org/x/exp/slices"
+	"om-kits-installer/engine"
+	"strconv"
+	"strings"
+)
+
+var alertingSections = []string{"Receivers", "Routes", "Inhibition rules", "Mute time intervals", "Templates"}
+var alertingSection = alertingSections[0]
+var receiverIndex int
+var newReceiverName string
+var newReceiverType = engine.AlertReceiverTypes.DingTalk
+var routePath []int
+var inhibitRuleIndex int
+var muteTimeIntervalIndex int
+var newMuteTimeIntervalName string
+
+// addAlerting adds the alerting settings of Prometheus to its form, one section at a time.
+func addAlerting(index int, mainText string) {
+	formPackage.AddDropDown("Alerting: ", alertingSections, slices.Index(alertingSections, alertingSection),
+		func(option string, optionIndex int) {
+			if optionIndex >= 0 && option != alertingSection {
+				alertingSection = option
+				selectPackage(index, mainText)
+			}
+		})
+	switch alertingSection {
+	case "Receivers":
+		addAlertReceivers(index, mainText)
+	case "Routes":
+		addAlertRoutes(index, mainText)
+	case "Inhibition rules":
+		addInhibitRules(index, mainText)
+	case "Mute time intervals":
+		addMuteTimeIntervals(index, mainText)
+	case "Templates":
+		addAlertTemplates(index, mainText)
+	}
+}
+
+// addAlertReceivers adds the receivers of the alerts to the Prometheus form, the fields of one
+// receiver at a time.
+func addAlertReceivers(index int, mainText string) {
+	receivers := config.Prometheus.Receivers
+	if len(receivers) == 0 {
+		formPackage.AddTextView("Receivers: ", "None, alerts are only shown in Alertmanager.", 0, 1, false, false)
+	} else {
+		if receiverIndex >= len(receivers) {
+			receiverIndex = 0
+		}
+		var names []string
+		for _, receiver := range receivers {
+			names = append(names, receiver.Name+" ("+receiver.Type+")")
+		}
+		formPackage.AddDropDown("Receiver: ", names, receiverIndex, func(option string, optionIndex int) {
+			if optionIndex >= 0 && optionIndex != receiverIndex {
+				receiverIndex = optionIndex
+				selectPackage(index, mainText)
+			}
+		})
+
+		receiver := &config.Prometheus.Receivers[receiverIndex]
+		switch receiver.Type {
+		case engine.AlertReceiverTypes.DingTalk:
+			formPackage.AddPasswordField("      Access token or robot URL: ", receiver.AccessToken, 0, '*', func(text string) {
+				receiver.AccessToken = strings.TrimSpace(text)
+			})
+			formPackage.AddPasswordField("      Signing secret (optional): ", receiver.Secret, 0, '*', func(text string) {
+				receiver.Secret = strings.TrimSpace(text)
+			})
+		case engine.AlertReceiverTypes.Email:
+			formPackage.AddInputField("      SMTP host: ", receiver.SmtpHost, 0, nil, func(text string) {
+				receiver.SmtpHost = strings.TrimSpace(text)
+			})
+			formPackage.AddInputField("      SMTP port: ", strconv.Itoa(receiver.SmtpPort), 0, nil, func(text string) {
+				receiver.SmtpPort, _ = strconv.Atoi(text)
+			})
+			formPackage.AddCheckbox("      Require STARTTLS: ", receiver.SmtpRequireTls, func(checked bool) {
+				receiver.SmtpRequireTls = checked
+			})
+			formPackage.AddInputField("      SMTP username (optional): ", receiver.SmtpUsername, 0, nil, func(text string) {
+				receiver.SmtpUsername = strings.TrimSpace(text)
+			})
+			formPackage.AddPasswordField("      SMTP password: ", receiver.SmtpPassword, 0, '*', func(text string) {
+				receiver.SmtpPassword = text
+			})
+			formPackage.AddInputField("      From: ", receiver.EmailFrom, 0, nil, func(text string) {
+				receiver.EmailFrom = strings.TrimSpace(text)
+			})
+			formPackage.AddInputField("      To (comma separated): ", receiver.EmailTo, 0, nil, func(text string) {
+				receiver.EmailTo = strings.TrimSpace(text)
+			})
+		case engine.AlertReceiverTypes.Webhook, engine.AlertReceiverTypes.FeishuFlow:
+			if receiver.Type == engine.AlertReceiverTypes.FeishuFlow {
+				formPackage.AddTextView("", "The URL of the webhook trigger of a Feishu flow, which takes the "+
+					"alerts as they are. Custom bots (open-apis/bot/v2/hook) reject them.", 0, 2, false, false)
+			}
+			formPackage.AddInputField("      URL: ", receiver.Url, 0, nil, func(text string) {
+				receiver.Url = strings.TrimSpace(text)
+			})
+		case engine.AlertReceiverTypes.WeCom:
+			formPackage.AddInputField("      Corp ID: ", receiver.CorpId, 0, nil, func(text string) {
+				receiver.CorpId = strings.TrimSpace(text)
+			})
+			formPackage.AddInputField("      Agent ID: ", receiver.AgentId, 0, nil, func(text string) {
+				receiver.AgentId = strings.TrimSpace(text)
+			})
+			formPackage.AddPasswordField("      Secret: ", receiver.ApiSecret, 0, '*', func(text string) {
+				receiver.ApiSecret = strings.TrimSpace(text)
+			})
+			formPackage.AddInputField("      To users (| separated): ", receiver.ToUser, 0, nil, func(text string) {
+				receiver.ToUser = strings.TrimSpace(text)
+			})
+		}
+		formPackage.AddInputField("      Severities (comma separated, empty means all): ", receiver.Severities,
+			0, nil, func(text string) {
+				receiver.Severities = text
+			})
+		formPackage.AddInputField("      Namespaces (comma separated, empty means all): ", receiver.Namespaces,
+			0, nil, func(text string) {
+				receiver.Namespaces = text
+			})
+	}
+
+	receiverTypes := []string{engine.AlertReceiverTypes.DingTalk, engine.AlertReceiverTypes.Email,
+		engine.AlertReceiverTypes.Webhook, engine.AlertReceiverTypes.WeCom, engine.AlertReceiverTypes.FeishuFlow}
+	formPackage.AddDropDown("Add receiver of type: ", receiverTypes, slices.Index(receiverTypes, newReceiverType),
+		func(option string, optionIndex int) {
+			newReceiverType = option
+		})
+	formPackage.AddInputField("      Name: ", newReceiverName, 0, nil, func(text string) {
+		newReceiverName = strings.TrimSpace(text)
+	})
+
+	formPackage.AddButton("Add Receiver", func() {
+		for _, receiver := range config.Prometheus.Receivers {
+			if receiver.Name == newReceiverName {
+				showErrorModal("Receiver " + newReceiverName + " is added already.")
+				return
+			}
+		}
+		err := engine.ValidateReceiverName(newReceiverName)
+		if err != nil {
+			showErrorModal(err.Error())
+			return
+		}
+		config.Prometheus.Receivers = append(config.Prometheus.Receivers,
+			engine.NewAlertReceiver(newReceiverName, newReceiverType))
+		receiverIndex = len(config.Prometheus.Receivers) - 1
+		newReceiverName = ""
+		selectPackage(index, mainText)
+	})
+	if len(receivers) > 0 {
+		formPackage.AddButton("Remove Receiver", func() {
+			config.Prometheus.Receivers = append(config.Prometheus.Receivers[:receiverIndex:receiverIndex],
+				config.Prometheus.Receivers[receiverIndex+1:]...)
+			receiverIndex = 0
+			selectPackage(index, mainText)
+		})
+	}
+}
+
+// routeEntry is a route of the routing tree as the route dropdown lists it.
+type routeEntry struct {
+	path  []int
+	route *engine.AlertRoute
+}
+
+// flattenRoutes returns the routes of the tree depth first, after parent.
+func flattenRoutes(routes []engine.AlertRoute, parent []int) []routeEntry {
+	var entries []routeEntry
+	for index := range routes {
+		path := append(append([]int{}, parent...), index)
+		entries = append(entries, routeEntry{path: path, route: &routes[index]})
+		entries = append(entries, flattenRoutes(routes[index].Routes, path)...)
+	}
+	return entries
+}
+
+// routePathText returns path counted from 1, like 1.2.
+func routePathText(path []int) string {
+	var parts []string
+	for _, index := range path {
+		parts = append(parts, strconv.Itoa(index+1))
+	}
+	return strings.Join(parts, ".")
+}
+
+// addAlertRoutes adds the root route and the routing tree to the Prometheus form, the fields of
+// one route at a time.
+func addAlertRoutes(index int, mainText string) {
+	routing := &config.Prometheus.Routing
+	formPackage.AddInputField("      Group by (comma separated): ", routing.GroupBy, 0, nil, func(text string) {
+		routing.GroupBy = text
+	})
+	formPackage.AddInputField("      Group wait: ", routing.GroupWait, 0, nil, func(text string) {
+		routing.GroupWait = strings.TrimSpace(text)
+	})
+	formPackage.AddInputField("      Group interval: ", routing.GroupInterval, 0, nil, func(text string) {
+		routing.GroupInterval = strings.TrimSpace(text)
+	})
+	formPackage.AddInputField("      Repeat interval: ", routing.RepeatInterval, 0, nil, func(text string) {
+		routing.RepeatInterval = strings.TrimSpace(text)
+	})
+
+	entries := flattenRoutes(routing.Routes, nil)
+	var selected *routeEntry
+	if len(entries) == 0 {
+		formPackage.AddTextView("Routes: ", "None, the routes of the receivers send them the alerts.", 0, 1, false, false)
+	} else {
+		var options []string
+		selectedOption := 0
+		for entryIndex, entry := range entries {
+			options = append(options, fmt.Sprintf("%s%s → %s %s", strings.Repeat("  ", len(entry.path)-1),
+				routePathText(entry.path), entry.route.Receiver, entry.route.Matchers))
+			if slices.Equal(entry.path, routePath) {
+				selectedOption = entryIndex
+			}
+		}
+		selected = &entries[selectedOption]
+		routePath = selected.path
+		formPackage.AddDropDown("Route: ", options, selectedOption, func(option string, optionIndex int) {
+			if optionIndex >= 0 && optionIndex != selectedOption {
+				routePath = entries[optionIndex].path
+				selectPackage(index, mainText)
+			}
+		})
+
+		route := selected.route
+		receivers := []string{"null"}
+		for _, receiver := range config.Prometheus.Receivers {
+			receivers = append(receivers, receiver.Name)
+		}
+		formPackage.AddDropDown("      Receiver: ", receivers, slices.Index(receivers, route.Receiver),
+			func(option string, optionIndex int) {
+				if optionIndex >= 0 && option != route.Receiver {
+					route.Receiver = option
+					selectPackage(index, mainText)
+				}
+			})
+		formPackage.AddInputField("      Matchers, like severity=\"critical\": ", route.Matchers, 0, nil, func(text string) {
+			route.Matchers = text
+		})
+		formPackage.AddInputField("      Group by (empty keeps the parent's): ", route.GroupBy, 0, nil, func(text string) {
+			route.GroupBy = text
+		})
+		formPackage.AddInputField("      Group wait (empty keeps the parent's): ", route.GroupWait, 0, nil, func(text string) {
+			route.GroupWait = strings.TrimSpace(text)
+		})
+		formPackage.AddInputField("      Group interval (empty keeps the parent's): ", route.GroupInterval, 0, nil,
+			func(text string) {
+				route.GroupInterval = strings.TrimSpace(text)
+			})
+		formPackage.AddInputField("      Repeat interval (empty keeps the parent's): ", route.RepeatInterval, 0, nil,
+			func(text string) {
+				route.RepeatInterval = strings.TrimSpace(text)
+			})
+		formPackage.AddInputField("      Mute time intervals (comma separated): ", route.MuteTimeIntervals, 0, nil,
+			func(text string) {
+				route.MuteTimeIntervals = text
+			})
+		formPackage.AddCheckbox("      Continue with the next routes: ", route.Continue, func(checked bool) {
+			route.Continue = checked
+		})
+	}
+
+	formPackage.AddButton("Add Route", func() {
+		routing.Routes = append(routing.Routes, engine.AlertRoute{Receiver: "null"})
+		routePath = []int{len(routing.Routes) - 1}
+		selectPackage(index, mainText)
+	})
+	if selected != nil {
+		formPackage.AddButton("Add Child Route", func() {
+			selected.route.Routes = append(selected.route.Routes, engine.AlertRoute{Receiver: selected.route.Receiver})
+			routePath = append(append([]int{}, selected.path...), len(selected.route.Routes)-1)
+			selectPackage(index, mainText)
+		})
+		formPackage.AddButton("Remove Route", func() {
+			// The routes holding the selected one, the tree or the child routes of its parent
+			routes := &routing.Routes
+			for _, position := range selected.path[:len(selected.path)-1] {
+				routes = &(*routes)[position].Routes
+			}
+			position := selected.path[len(selected.path)-1]
+			*routes = append((*routes)[:position:position], (*routes)[position+1:]...)
+			routePath = nil
+			selectPackage(index, mainText)
+		})
+	}
+}
+
+// addInhibitRules adds the inhibition rules to the Prometheus form, the fields of one rule at a
+// time.
+func addInhibitRules(index int, mainText string) {
+	routing := &config.Prometheus.Routing
+	if len(routing.InhibitRules) == 0 {
+		formPackage.AddTextView("Inhibition rules: ", "None, no alert mutes another.", 0, 1, false, false)
+	} else {
+		if inhibitRuleIndex >= len(routing.InhibitRules) {
+			inhibitRuleIndex = 0
+		}
+		var options []string
+		for ruleIndex, rule := range routing.InhibitRules {
+			options = append(options, fmt.Sprintf("%d: %s mutes %s", ruleIndex+1, rule.SourceMatchers,
+				rule.TargetMatchers))
+		}
+		formPackage.AddDropDown("Inhibition rule: ", options, inhibitRuleIndex, func(option string, optionIndex int) {
+			if optionIndex >= 0 && optionIndex != inhibitRuleIndex {
+				inhibitRuleIndex = optionIndex
+				selectPackage(index, mainText)
+			}
+		})
+
+		rule := &routing.InhibitRules[inhibitRuleIndex]
+		formPackage.AddInputField("      Source matchers: ", rule.SourceMatchers, 0, nil, func(text string) {
+			rule.SourceMatchers = text
+		})
+		formPackage.AddInputField("      Target matchers: ", rule.TargetMatchers, 0, nil, func(text string) {
+			rule.TargetMatchers = text
+		})
+		formPackage.AddInputField("      Equal labels (comma separated): ", rule.Equal, 0, nil, func(text string) {
+			rule.Equal = text
+		})
+	}
+
+	formPackage.AddButton("Add Rule", func() {
+		routing.InhibitRules = append(routing.InhibitRules, engine.InhibitRule{})
+		inhibitRuleIndex = len(routing.InhibitRules) - 1
+		selectPackage(index, mainText)
+	})
+	if len(routing.InhibitRules) > 0 {
+		formPackage.AddButton("Remove Rule", func() {
+			routing.InhibitRules = append(routing.InhibitRules[:inhibitRuleIndex:inhibitRuleIndex],
+				routing.InhibitRules[inhibitRuleIndex+1:]...)
+			inhibitRuleIndex = 0
+			selectPackage(index, mainText)
+		})
+	}
+}
+
+// addMuteTimeIntervals adds the mute time intervals of the maintenance windows to the Prometheus
+// form, the fields of one interval at a time.
+func addMuteTimeIntervals(index int, mainText string) {
+	routing := &config.Prometheus.Routing
+	if len(routing.MuteTimeIntervals) == 0 {
+		formPackage.AddTextView("Mute time intervals: ", "None, routes notify at any time.", 0, 1, false, false)
+	} else {
+		if muteTimeIntervalIndex >= len(routing.MuteTimeIntervals) {
+			muteTimeIntervalIndex = 0
+		}
+		var names []string
+		for _, interval := range routing.MuteTimeIntervals {
+			names = append(names, interval.Name)
+		}
+		formPackage.AddDropDown("Mute time interval: ", names, muteTimeIntervalIndex,
+			func(option string, optionIndex int) {
+				if optionIndex >= 0 && optionIndex != muteTimeIntervalIndex {
+					muteTimeIntervalIndex = optionIndex
+					selectPackage(index, mainText)
+				}
+			})
+
+		interval := &routing.MuteTimeIntervals[muteTimeIntervalIndex]
+		formPackage.AddInputField("      Times, like 22:00-24:00: ", interval.Times, 0, nil, func(text string) {
+			interval.Times = text
+		})
+		formPackage.AddInputField("      Weekdays, like monday:friday: ", interval.Weekdays, 0, nil, func(text string) {
+			interval.Weekdays = text
+		})
+		formPackage.AddInputField("      Days of month, like 1:7 or -1: ", interval.DaysOfMonth, 0, nil,
+			func(text string) {
+				interval.DaysOfMonth = text
+			})
+		formPackage.AddInputField("      Months, like january:march: ", interval.Months, 0, nil, func(text string) {
+			interval.Months = text
+		})
+		formPackage.AddInputField("      Years, like 2025:2026: ", interval.Years, 0, nil, func(text string) {
+			interval.Years = text
+		})
+		formPackage.AddInputField("      Time zone (empty means UTC): ", interval.Location, 0, nil, func(text string) {
+			interval.Location = strings.TrimSpace(text)
+		})
+	}
+
+	formPackage.AddInputField("Add mute time interval named: ", newMuteTimeIntervalName, 0, nil, func(text string) {
+		newMuteTimeIntervalName = strings.TrimSpace(text)
+	})
+	formPackage.AddButton("Add Interval", func() {
+		if newMuteTimeIntervalName == "" {
+			showErrorModal("Mute time interval name is empty.")
+			return
+		}
+		for _, interval := range routing.MuteTimeIntervals {
+			if interval.Name == newMuteTimeIntervalName {
+				showErrorModal("Mute time interval " + newMuteTimeIntervalName + " is added already.")
+				return
+			}
+		}
+		routing.MuteTimeIntervals = append(routing.MuteTimeIntervals,
+			engine.MuteTimeInterval{Name: newMuteTimeIntervalName, Location: config.BasicInfo.Timezone})
+		muteTimeIntervalIndex = len(routing.MuteTimeIntervals) - 1
+		newMuteTimeIntervalName = ""
+		selectPackage(index, mainText)
+	})
+	if len(routing.MuteTimeIntervals) > 0 {
+		formPackage.AddButton("Remove Interval", func() {
+			routing.MuteTimeIntervals = append(routing.MuteTimeIntervals[:muteTimeIntervalIndex:muteTimeIntervalIndex],
+				routing.MuteTimeIntervals[muteTimeIntervalIndex+1:]...)
+			muteTimeIntervalIndex = 0
+			selectPackage(index, mainText)
+		})
+	}
+}
+
+// addAlertTemplates adds the template set of the notifications to the Prometheus form, with a
+// preview of a sample group of alerts.
+func addAlertTemplates(index int, mainText string) {
+	templates := &config.Prometheus.Templates
+	templateSets := []string{engine.AlertTemplateSets.Default, engine.AlertTemplateSets.Concise,
+		engine.AlertTemplateSets.ConciseChinese, engine.AlertTemplateSets.Custom}
+	if !slices.Contains(templateSets, templates.Set) {
+		templates.Set = engine.AlertTemplateSets.Default
+	}
+	formPackage.AddDropDown("Template set: ", templateSets, slices.Index(templateSets, templates.Set),
+		func(option string, optionIndex int) {
+			if optionIndex >= 0 && option != templates.Set {
+				templates.Set = option
+				selectPackage(index, mainText)
+			}
+		})
+	if templates.Set == engine.AlertTemplateSets.Custom {
+		formPackage.AddInputField("      Template files (comma separated): ", templates.Files, 0, nil,
+			func(text string) {
+				templates.Files = text
+			})
+		formPackage.AddTextView("", "Define om_kits.email.subject, om_kits.email.html, om_kits.wechat.message, "+
+			"om_kits.dingtalk.title and om_kits.dingtalk.content.", 0, 2, false, false)
+	}
+
+	formPackage.AddButton("Preview", func() {
+		err := templates.Validate()
+		if err != nil {
+			showErrorModal(err.Error())
+			return
+		}
+		showTemplatePreview(*templates)
+	})
+}
+
+// showTemplatePreview shows the notifications of a sample group of alerts rendered with
+// templates, firing or resolved.
+func showTemplatePreview(templates engine.AlertTemplates) {
+	flexPreview := tview.NewFlex().SetDirection(tview.FlexRow)
+	flexPreview.SetTitle("Template Preview").SetBorder(true)
+
+	preview := tview.NewTextView()
+	preview.SetWrap(true).
+		SetWordWrap(true)
+	render := func(resolved bool) {
+		text, err := engine.PreviewAlertTemplates(appPath, templates, config.BasicInfo.Timezone, resolved)
+		if err != nil {
+			text = "Can't render the templates: " + err.Error()
+		}
+		preview.SetText(text).ScrollToBeginning()
+	}
+	render(false)
+
+	formDown := tview.NewForm()
+	formDown.AddButton("Firing", func() {
+		render(false)
+	})
+	formDown.AddButton("Resolved", func() {
+		render(true)
+	})
+	formDown.AddButton("Close", func() {
+		pages.RemovePage("Template Preview")
+		pages.SwitchToPage("Packages")
+	})
+
+	flexPreview.
+		AddItem(preview, 0, 1, false).
+		AddItem(formDown, 3, 1, true)
+	pages.AddPage("Template Preview", flexPreview, true, true)
+}
@@ -4,82 +4,202 @@ import (
 	"github.com/rivo/tview"
 	"github.com/thlib/go-timezone-local/tzlocal"
 	"golang.org/x/exp/slices"
-	"net"
-	"net/mail"
+	"om-kits-installer/engine"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
-type BasicInfo struct {
-	host         string
-	httpsEnabled bool
-	timezone     string
-	tlsCert      TlsCert
-}
-type TlsCert struct {
-	certMethod       string
-	forceSslRedirect bool
-	acmeEmail        string
-}
-type CertMethod struct {
-	defaultTlsSecret string
-	certManager      string
-}
+var ingressClasses []engine.IngressClass
+var ingressClassesLoaded bool
+var timezones []string
 
-var certMethod = CertMethod{
-	defaultTlsSecret: "Default TLS Secret (Secret name: default-tls, Namespace: default)",
-	certManager:      "Cert Manager",
-}
+func initFlexBasicInfo() {
+	basicInfo := &config.BasicInfo
 
-var basicInfo = BasicInfo{host: "", httpsEnabled: false, timezone: "", tlsCert: TlsCert{
-	certMethod:       "",
-	forceSslRedirect: false,
-	acmeEmail:        "",
-}}
+	if !ingressClassesLoaded {
+		ingressClasses, _ = engine.GetIngressClasses()
+		ingressClassesLoaded = true
+	}
+	// Without any IngressClass in the cluster, offer the class of the Ingress-nginx package
+	classes := ingressClasses
+	if len(classes) == 0 {
+		classes = []engine.IngressClass{{Name: engine.IngressNginxClass, Controller: engine.IngressControllers.Nginx}}
+	}
 
-func initFlexBasicInfo() {
 	flexBasicInfo.Clear()
 	formBasicInfo := tview.NewForm()
 	formBasicInfo.SetTitle("Basic Info").SetBorder(true)
 
-	if basicInfo.timezone == "" {
+	if basicInfo.Timezone == "" {
 		var err error
-		basicInfo.timezone, err = tzlocal.RuntimeTZ()
+		basicInfo.Timezone, err = tzlocal.RuntimeTZ()
 		check(err)
 	}
 
-	formBasicInfo.AddInputField("Timezone: ", basicInfo.timezone, 0, nil, func(text string) {
-		basicInfo.timezone = text
-	})
+	if timezones == nil {
+		timezones = engine.ListTimezones()
+	}
+	// Typing the start of a zone selects it
+	timezoneOptions := timezones
+	if !slices.Contains(timezoneOptions, basicInfo.Timezone) {
+		timezoneOptions = append([]string{basicInfo.Timezone}, timezoneOptions...)
+	}
+	formBasicInfo.AddDropDown("Timezone: ", timezoneOptions, slices.Index(timezoneOptions, basicInfo.Timezone),
+		func(option string, optionIndex int) {
+			if optionIndex >= 0 {
+				basicInfo.Timezone = option
+			}
+		})
 
-	formBasicInfo.AddInputField("Cluster DNS or IP: ", basicInfo.host, 0, nil,
+	formBasicInfo.AddInputField("CA bundle file (PEM, optional): ", basicInfo.CaBundleFile, 0, nil,
 		func(text string) {
-			basicInfo.host = strings.Trim(text, " ")
+			basicInfo.CaBundleFile = strings.TrimSpace(text)
 		})
 
-	formBasicInfo.AddCheckbox("Enable https: ", basicInfo.httpsEnabled, func(checked bool) {
-		basicInfo.httpsEnabled = checked
+	formBasicInfo.AddCheckbox("Use a proxy: ", basicInfo.Proxy.Enabled, func(checked bool) {
+		basicInfo.Proxy.Enabled = checked
 		initFlexBasicInfo()
 	})
 
-	if basicInfo.httpsEnabled {
-		formBasicInfo.AddCheckbox("  Force SSL redirect: ", basicInfo.tlsCert.forceSslRedirect, func(checked bool) {
-			basicInfo.tlsCert.forceSslRedirect = checked
+	if basicInfo.Proxy.Enabled {
+		formBasicInfo.AddInputField("  HTTP proxy: ", basicInfo.Proxy.HttpProxy, 0, nil, func(text string) {
+			basicInfo.Proxy.HttpProxy = strings.TrimSpace(text)
+		})
+		formBasicInfo.AddInputField("  HTTPS proxy: ", basicInfo.Proxy.HttpsProxy, 0, nil, func(text string) {
+			basicInfo.Proxy.HttpsProxy = strings.TrimSpace(text)
 		})
+		// The API server, the nodes, the pods and the services are always reached directly
+		formBasicInfo.AddInputField("  More no proxy addresses (comma separated): ", basicInfo.Proxy.NoProxy, 0, nil,
+			func(text string) {
+				basicInfo.Proxy.NoProxy = strings.TrimSpace(text)
+			})
+	}
+
+	formBasicInfo.AddInputField("Cluster DNS or IP: ", basicInfo.Host, 0, nil,
+		func(text string) {
+			basicInfo.Host = strings.Trim(text, " ")
+		})
+
+	arrExposures := []string{engine.Exposures.IngressHost, engine.Exposures.IngressPath, engine.Exposures.NodePort,
+		engine.Exposures.LoadBalancer}
+	formBasicInfo.AddDropDown("Expose UIs by: ", arrExposures, slices.Index(arrExposures, basicInfo.Exposure),
+		func(option string, optionIndex int) {
+			if basicInfo.Exposure != option {
+				basicInfo.Exposure = option
+				// Https is served by the ingress with host only
+				if option != engine.Exposures.IngressHost {
+					basicInfo.HttpsEnabled = false
+				}
+				if !basicInfo.IngressExposed() {
+					basicInfo.AccessControl.Method = engine.AccessControlMethods.None
+				}
+				initFlexBasicInfo()
+			}
+		})
+
+	if basicInfo.Exposure == engine.Exposures.IngressHost {
+		formBasicInfo.AddCheckbox("  Subdomain per UI (grafana.<host>, kibana.<host>): ", basicInfo.SubdomainRouting,
+			func(checked bool) {
+				basicInfo.SubdomainRouting = checked
+			})
+	}
 
-		arrCertMethods := []string{certMethod.defaultTlsSecret, certMethod.certManager}
-		initialOption := slices.Index(arrCertMethods, basicInfo.tlsCert.certMethod)
+	if basicInfo.Exposure == engine.Exposures.NodePort {
+		formBasicInfo.AddInputField("  Grafana node port: ", strconv.Itoa(basicInfo.GrafanaNodePort), 0, nil,
+			func(text string) {
+				basicInfo.GrafanaNodePort, _ = strconv.Atoi(text)
+			})
+		formBasicInfo.AddInputField("  Kibana node port: ", strconv.Itoa(basicInfo.KibanaNodePort), 0, nil,
+			func(text string) {
+				basicInfo.KibanaNodePort, _ = strconv.Atoi(text)
+			})
+	}
+
+	if basicInfo.IngressExposed() {
+		arrIngressClasses := make([]string, len(classes))
+		defaultClassIndex := 0
+		for index, class := range classes {
+			arrIngressClasses[index] = class.Name
+			if class.Default {
+				defaultClassIndex = index
+			}
+		}
+		classIndex := slices.Index(arrIngressClasses, basicInfo.IngressClass)
+		if classIndex < 0 {
+			classIndex = defaultClassIndex
+		}
+		formBasicInfo.AddDropDown("  Ingress class: ", arrIngressClasses, classIndex, func(option string, optionIndex int) {
+			basicInfo.IngressClass = option
+			basicInfo.IngressController = classes[optionIndex].Controller
+		})
+
+		formBasicInfo.AddInputField("  Max request body size (e.g. 50m): ", basicInfo.MaxBodySize, 0, nil,
+			func(text string) {
+				basicInfo.MaxBodySize = strings.TrimSpace(text)
+			})
+
+		addAccessControlFields(formBasicInfo, &basicInfo.AccessControl)
+	}
+
+	if basicInfo.Exposure == engine.Exposures.IngressHost {
+		formBasicInfo.AddCheckbox("Enable https: ", basicInfo.HttpsEnabled, func(checked bool) {
+			basicInfo.HttpsEnabled = checked
+			initFlexBasicInfo()
+		})
+	}
+
+	if basicInfo.HttpsEnabled {
+		formBasicInfo.AddCheckbox("  Force SSL redirect: ", basicInfo.TlsCert.ForceSslRedirect, func(checked bool) {
+			basicInfo.TlsCert.ForceSslRedirect = checked
+		})
+
+		arrCertMethods := []string{engine.CertMethods.DefaultTlsSecret, engine.CertMethods.CertManager,
+			engine.CertMethods.CertificateFiles, engine.CertMethods.InternalCa}
+		initialOption := slices.Index(arrCertMethods, basicInfo.TlsCert.CertMethod)
 		formBasicInfo.AddDropDown("  Select a method to generate SSL certificate: ", arrCertMethods, initialOption,
 			func(option string, optionIndex int) {
-				if basicInfo.tlsCert.certMethod != option {
-					basicInfo.tlsCert.certMethod = option
+				if basicInfo.TlsCert.CertMethod != option {
+					basicInfo.TlsCert.CertMethod = option
 					initFlexBasicInfo()
 				}
 			})
 
-		if basicInfo.tlsCert.certMethod == certMethod.certManager {
-			formBasicInfo.AddInputField("    Email: ", basicInfo.tlsCert.acmeEmail, 0, nil,
+		if basicInfo.TlsCert.CertMethod == engine.CertMethods.CertManager {
+			formBasicInfo.AddInputField("    Email: ", basicInfo.TlsCert.AcmeEmail, 0, nil,
 				func(text string) {
-					basicInfo.tlsCert.acmeEmail = strings.Trim(text, " ")
+					basicInfo.TlsCert.AcmeEmail = strings.Trim(text, " ")
+				})
+			addAcmeFields(formBasicInfo, &basicInfo.TlsCert.Acme)
+		}
+
+		if basicInfo.TlsCert.CertMethod == engine.CertMethods.CertificateFiles {
+			formBasicInfo.AddInputField("    Certificate file (with chain): ", basicInfo.TlsCert.CertFile, 0, nil,
+				func(text string) {
+					basicInfo.TlsCert.CertFile = strings.Trim(text, " ")
+				})
+			formBasicInfo.AddInputField("    Key file: ", basicInfo.TlsCert.KeyFile, 0, nil,
+				func(text string) {
+					basicInfo.TlsCert.KeyFile = strings.Trim(text, " ")
+				})
+		}
+
+		if basicInfo.TlsCert.CertMethod == engine.CertMethods.InternalCa {
+			formBasicInfo.AddInputField("    CA validity (day): ", strconv.Itoa(basicInfo.TlsCert.CaValidityDays), 0, nil,
+				func(text string) {
+					basicInfo.TlsCert.CaValidityDays, _ = strconv.Atoi(text)
+				})
+			formBasicInfo.AddInputField("    Certificate validity (day): ", strconv.Itoa(basicInfo.TlsCert.CertValidityDays), 0, nil,
+				func(text string) {
+					basicInfo.TlsCert.CertValidityDays, _ = strconv.Atoi(text)
+				})
+			formBasicInfo.AddCheckbox("    Rotate CA if it expires before the certificate: ", basicInfo.TlsCert.RotateCa,
+				func(checked bool) {
+					basicInfo.TlsCert.RotateCa = checked
+				})
+			formBasicInfo.AddInputField("    Extra SANs (comma separated): ", basicInfo.TlsCert.ExtraSans, 0, nil,
+				func(text string) {
+					basicInfo.TlsCert.ExtraSans = text
 				})
 		}
 	}
@@ -87,43 +207,47 @@ func initFlexBasicInfo() {
 	formDown := tview.NewForm()
 
 	formDown.AddButton("Next", func() {
-		if basicInfo.host == "" {
-			showErrorModal("Custer domain name or IP is empty.")
+		err := basicInfo.Validate()
+		if err != nil {
+			showErrorModal(err.Error())
 			return
 		}
 
-		if basicInfo.timezone == "" {
-			showErrorModal("Timezone is empty.")
+		// The checks from here on trust the internal CAs and go through the proxy
+		err = engine.TrustCaBundle(basicInfo.CaBundleFile)
+		if err != nil {
+			showErrorModal(err.Error())
 			return
 		}
+		if basicInfo.Proxy.Enabled && len(basicInfo.Proxy.ClusterAddresses) == 0 {
+			basicInfo.Proxy.ClusterAddresses = engine.DetectClusterAddresses()
+		}
+		engine.UseProxy(basicInfo.Proxy)
 
-		if basicInfo.httpsEnabled {
-			if net.ParseIP(basicInfo.host) != nil {
-				showErrorModal(basicInfo.host + " must be a DNS, not an IP address, when https is enabled.")
+		if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == engine.CertMethods.InternalCa {
+			err = engine.CheckInternalCa(filepath.Join(appPath, engine.InternalCaDir),
+				basicInfo.TlsCert.CertValidityDays, basicInfo.TlsCert.RotateCa)
+			if err != nil {
+				showErrorModal(err.Error())
 				return
 			}
+		}
 
-			if basicInfo.tlsCert.certMethod == "" {
-				showErrorModal("Please select a method to generate SSL certificate.")
+		if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == engine.CertMethods.DefaultTlsSecret {
+			if !engine.SecretExists("default-tls", "default") {
+				showErrorModal("Secret 'default-tls' not existing.")
 				return
 			}
+		}
 
-			if basicInfo.tlsCert.certMethod == certMethod.defaultTlsSecret {
-				_, err := execCommand("kubectl get secret default-tls", 0)
-				if err != nil {
-					showErrorModal("Secret 'default-tls' not existing.")
-					return
-				}
+		if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == engine.CertMethods.CertManager {
+			err = engine.CheckAcmeDirectory(basicInfo.TlsCert.Acme.GetDirectoryUrl())
+			if err == nil {
+				err = basicInfo.TlsCert.Acme.CheckDnsCredentials()
 			}
-
-			if basicInfo.tlsCert.certMethod == certMethod.certManager {
-				email, err := mail.ParseAddress(basicInfo.tlsCert.acmeEmail)
-				if err != nil {
-					showErrorModal("Email is empty or format is wrong.")
-					return
-				} else {
-					basicInfo.tlsCert.acmeEmail = email.Address
-				}
+			if err != nil {
+				showErrorModal(err.Error())
+				return
 			}
 		}
 
@@ -131,6 +255,11 @@ func initFlexBasicInfo() {
 		pages.SwitchToPage("Packages")
 	})
 
+	formDown.AddButton("History", func() {
+		initFlexHistory()
+		pages.SwitchToPage("History")
+	})
+
 	formDown.AddButton("Quit", func() {
 		showQuitModal()
 	})
@@ -139,3 +268,107 @@ func initFlexBasicInfo() {
 		AddItem(formBasicInfo, 0, 1, true).
 		AddItem(formDown, 3, 1, false)
 }
+
+func addAcmeFields(form *tview.Form, acme *engine.AcmeConfig) {
+	arrServers := []string{engine.AcmeServers.LetsEncrypt, engine.AcmeServers.LetsEncryptStaging, engine.AcmeServers.Custom}
+	form.AddDropDown("    ACME server: ", arrServers, slices.Index(arrServers, acme.Server),
+		func(option string, optionIndex int) {
+			if acme.Server != option {
+				acme.Server = option
+				initFlexBasicInfo()
+			}
+		})
+	if acme.Server == engine.AcmeServers.Custom {
+		form.AddInputField("      Directory URL: ", acme.DirectoryUrl, 0, nil, func(text string) {
+			acme.DirectoryUrl = strings.TrimSpace(text)
+		})
+	}
+
+	arrSolvers := []string{engine.AcmeSolvers.Http01, engine.AcmeSolvers.Dns01Cloudflare,
+		engine.AcmeSolvers.Dns01Route53, engine.AcmeSolvers.Dns01Rfc2136}
+	form.AddDropDown("    Challenge solver: ", arrSolvers, slices.Index(arrSolvers, acme.Solver),
+		func(option string, optionIndex int) {
+			if acme.Solver != option {
+				acme.Solver = option
+				if option == engine.AcmeSolvers.Http01 {
+					acme.Wildcard = false
+				}
+				initFlexBasicInfo()
+			}
+		})
+
+	switch acme.Solver {
+	case engine.AcmeSolvers.Dns01Cloudflare:
+		form.AddPasswordField("      API token: ", acme.CloudflareApiToken, 0, '*', func(text string) {
+			acme.CloudflareApiToken = strings.TrimSpace(text)
+		})
+	case engine.AcmeSolvers.Dns01Route53:
+		form.AddInputField("      Region: ", acme.Route53Region, 0, nil, func(text string) {
+			acme.Route53Region = strings.TrimSpace(text)
+		})
+		form.AddInputField("      Hosted zone ID (optional): ", acme.Route53HostedZoneId, 0, nil, func(text string) {
+			acme.Route53HostedZoneId = strings.TrimSpace(text)
+		})
+		form.AddInputField("      Access key ID: ", acme.Route53AccessKeyId, 0, nil, func(text string) {
+			acme.Route53AccessKeyId = strings.TrimSpace(text)
+		})
+		form.AddPasswordField("      Secret access key: ", acme.Route53SecretAccessKey, 0, '*', func(text string) {
+			acme.Route53SecretAccessKey = strings.TrimSpace(text)
+		})
+	case engine.AcmeSolvers.Dns01Rfc2136:
+		form.AddInputField("      Nameserver (host:port): ", acme.Rfc2136Nameserver, 0, nil, func(text string) {
+			acme.Rfc2136Nameserver = strings.TrimSpace(text)
+		})
+		form.AddInputField("      TSIG key name: ", acme.Rfc2136TsigKeyName, 0, nil, func(text string) {
+			acme.Rfc2136TsigKeyName = strings.TrimSpace(text)
+		})
+		form.AddInputField("      TSIG algorithm (default HMACSHA512): ", acme.Rfc2136TsigAlgorithm, 0, nil, func(text string) {
+			acme.Rfc2136TsigAlgorithm = strings.TrimSpace(text)
+		})
+		form.AddPasswordField("      TSIG secret: ", acme.Rfc2136TsigSecret, 0, '*', func(text string) {
+			acme.Rfc2136TsigSecret = strings.TrimSpace(text)
+		})
+	}
+
+	if acme.Solver != engine.AcmeSolvers.Http01 {
+		form.AddCheckbox("    Wildcard certificate: ", acme.Wildcard, func(checked bool) {
+			acme.Wildcard = checked
+		})
+	}
+}
+
+func addAccessControlFields(form *tview.Form, accessControl *engine.AccessControlConfig) {
+	arrMethods := []string{engine.AccessControlMethods.None, engine.AccessControlMethods.BasicAuth,
+		engine.AccessControlMethods.OAuth2Proxy}
+	form.AddDropDown("  Access control: ", arrMethods, slices.Index(arrMethods, accessControl.Method),
+		func(option string, optionIndex int) {
+			if accessControl.Method != option {
+				accessControl.Method = option
+				initFlexBasicInfo()
+			}
+		})
+
+	switch accessControl.Method {
+	case engine.AccessControlMethods.BasicAuth:
+		form.AddInputField("    Username: ", accessControl.Username, 0, nil, func(text string) {
+			accessControl.Username = strings.TrimSpace(text)
+		})
+		form.AddPasswordField("    Password: ", accessControl.Password, 0, '*', func(text string) {
+			accessControl.Password = text
+		})
+	case engine.AccessControlMethods.OAuth2Proxy:
+		form.AddInputField("    OIDC issuer URL: ", accessControl.OidcIssuerUrl, 0, nil, func(text string) {
+			accessControl.OidcIssuerUrl = strings.TrimSpace(text)
+		})
+		form.AddInputField("    Client ID: ", accessControl.OidcClientId, 0, nil, func(text string) {
+			accessControl.OidcClientId = strings.TrimSpace(text)
+		})
+		form.AddPasswordField("    Client secret: ", accessControl.OidcClientSecret, 0, '*', func(text string) {
+			accessControl.OidcClientSecret = strings.TrimSpace(text)
+		})
+		form.AddInputField("    Email domains (comma separated, empty means all): ", accessControl.OidcEmailDomains, 0, nil,
+			func(text string) {
+				accessControl.OidcEmailDomains = text
+			})
+	}
+}
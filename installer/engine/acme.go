@@ -0,0 +1,322 @@
+package engine
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type AcmeServer struct {
+	LetsEncrypt        string
+	LetsEncryptStaging string
+	Custom             string
+}
+
+var AcmeServers = AcmeServer{
+	LetsEncrypt:        "Let's Encrypt",
+	LetsEncryptStaging: "Let's Encrypt staging",
+	Custom:             "Custom ACME server",
+}
+
+type AcmeSolver struct {
+	Http01          string
+	Dns01Cloudflare string
+	Dns01Route53    string
+	Dns01Rfc2136    string
+}
+
+var AcmeSolvers = AcmeSolver{
+	Http01:          "HTTP-01",
+	Dns01Cloudflare: "DNS-01 (Cloudflare)",
+	Dns01Route53:    "DNS-01 (Route53)",
+	Dns01Rfc2136:    "DNS-01 (RFC2136)",
+}
+
+// AcmeConfig is the configuration of the ClusterIssuer used by Cert Manager.
+type AcmeConfig struct {
+	Server       string
+	DirectoryUrl string
+	Solver       string
+	Wildcard     bool
+
+	CloudflareApiToken string
+
+	Route53Region          string
+	Route53HostedZoneId    string
+	Route53AccessKeyId     string
+	Route53SecretAccessKey string
+
+	Rfc2136Nameserver    string
+	Rfc2136TsigKeyName   string
+	Rfc2136TsigAlgorithm string
+	Rfc2136TsigSecret    string
+}
+
+// AcmeIssuerName is the name of the ClusterIssuer created by the installer.
+const AcmeIssuerName = "om-kits-acme"
+
+func (acme *AcmeConfig) GetDirectoryUrl() string {
+	switch acme.Server {
+	case AcmeServers.LetsEncrypt:
+		return "https://acme-v02.api.letsencrypt.org/directory"
+	case AcmeServers.LetsEncryptStaging:
+		return "https://acme-staging-v02.api.letsencrypt.org/directory"
+	}
+	return acme.DirectoryUrl
+}
+
+func (acme *AcmeConfig) Validate() error {
+	if acme.Server == "" {
+		return errors.New("Please select an ACME server.")
+	}
+	if acme.Server == AcmeServers.Custom {
+		directoryUrl, err := url.ParseRequestURI(acme.DirectoryUrl)
+		if err != nil || directoryUrl.Scheme != "https" {
+			return errors.New("ACME directory URL is empty or isn't a https URL.")
+		}
+	}
+
+	switch acme.Solver {
+	case AcmeSolvers.Http01:
+		if acme.Wildcard {
+			return errors.New("Wildcard certificates need a DNS-01 solver.")
+		}
+	case AcmeSolvers.Dns01Cloudflare:
+		if acme.CloudflareApiToken == "" {
+			return errors.New("Cloudflare API token is empty.")
+		}
+	case AcmeSolvers.Dns01Route53:
+		if acme.Route53Region == "" {
+			return errors.New("Route53 region is empty.")
+		}
+		if acme.Route53AccessKeyId == "" || acme.Route53SecretAccessKey == "" {
+			return errors.New("Route53 access key is empty.")
+		}
+	case AcmeSolvers.Dns01Rfc2136:
+		if acme.Rfc2136Nameserver == "" {
+			return errors.New("RFC2136 nameserver is empty.")
+		}
+		if acme.Rfc2136TsigKeyName == "" || acme.Rfc2136TsigSecret == "" {
+			return errors.New("RFC2136 TSIG key is empty.")
+		}
+	default:
+		return errors.New("Please select an ACME challenge solver.")
+	}
+	return nil
+}
+
+var acmeClient = &http.Client{Timeout: 10 * time.Second}
+
+// CheckAcmeDirectory checks that directoryUrl serves an ACME directory.
+func CheckAcmeDirectory(directoryUrl string) error {
+	response, err := acmeClient.Get(directoryUrl)
+	if err != nil {
+		return fmt.Errorf("Can't reach ACME server: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("ACME server " + directoryUrl + " returned " + response.Status + ".")
+	}
+	var directory map[string]interface{}
+	err = json.NewDecoder(response.Body).Decode(&directory)
+	if err != nil || directory["newAccount"] == nil {
+		return errors.New(directoryUrl + " isn't an ACME directory.")
+	}
+	return nil
+}
+
+// Endpoints of the DNS providers, which the credentials of the DNS-01 solvers are checked with.
+var (
+	cloudflareApiUrl = "https://api.cloudflare.com/client/v4"
+	route53ApiUrl    = "https://route53.amazonaws.com/2013-04-01"
+)
+
+// CheckDnsCredentials checks that the DNS provider of a DNS-01 solver accepts the credentials,
+// which cert-manager would otherwise only reject while solving a challenge after the install.
+// The TSIG key of RFC2136 is left to cert-manager, only its nameserver is checked.
+func (acme *AcmeConfig) CheckDnsCredentials() error {
+	switch acme.Solver {
+	case AcmeSolvers.Dns01Cloudflare:
+		return checkCloudflareToken(acme.CloudflareApiToken)
+	case AcmeSolvers.Dns01Route53:
+		return checkRoute53Key(acme.Route53AccessKeyId, acme.Route53SecretAccessKey, acme.Route53HostedZoneId)
+	case AcmeSolvers.Dns01Rfc2136:
+		nameserver := acme.Rfc2136Nameserver
+		if _, _, err := net.SplitHostPort(nameserver); err != nil {
+			nameserver = net.JoinHostPort(nameserver, "53")
+		}
+		conn, err := net.DialTimeout("tcp", nameserver, acmeClient.Timeout)
+		if err != nil {
+			return fmt.Errorf("Can't reach RFC2136 nameserver: %w", err)
+		}
+		return conn.Close()
+	}
+	return nil
+}
+
+// checkCloudflareToken verifies an API token of Cloudflare.
+func checkCloudflareToken(token string) error {
+	request, err := http.NewRequest(http.MethodGet, cloudflareApiUrl+"/user/tokens/verify", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := acmeClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Can't reach Cloudflare: %w", err)
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Success bool `json:"success"`
+		Result  struct {
+			Status string `json:"status"`
+		} `json:"result"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil || !result.Success || result.Result.Status != "active" {
+		return errors.New("Cloudflare rejected the API token (" + response.Status + ").")
+	}
+	return nil
+}
+
+// checkRoute53Key calls Route53 with an access key, reading the hosted zone if any.
+func checkRoute53Key(accessKeyId string, secretAccessKey string, hostedZoneId string) error {
+	path := "/hostedzonecount"
+	if hostedZoneId != "" {
+		path = "/hostedzone/" + url.PathEscape(hostedZoneId)
+	}
+	request, err := http.NewRequest(http.MethodGet, route53ApiUrl+path, nil)
+	if err != nil {
+		return err
+	}
+	signAws(request, accessKeyId, secretAccessKey, "us-east-1", "route53", time.Now())
+	response, err := acmeClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("Can't reach Route53: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return errors.New("Route53 rejected the access key (" + response.Status + "): " +
+			strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signAws signs a request without body with AWS Signature Version 4.
+func signAws(request *http.Request, accessKeyId string, secretAccessKey string, region string, service string,
+	now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.Query().Encode(),
+		"host:" + request.URL.Host + "\n" + "x-amz-date:" + amzDate + "\n",
+		"host;x-amz-date",
+		hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyId+"/"+scope+
+		", SignedHeaders=host;x-amz-date, Signature="+hex.EncodeToString(key))
+}
+
+// solverEnvs returns the solver block of the ClusterIssuer and the key and value of the
+// secret holding the DNS provider credentials.
+func (acme *AcmeConfig) solverEnvs(ingressClass string) (envs []string) {
+	var solver []string
+	secretKey := ""
+	secretValue := ""
+
+	switch acme.Solver {
+	case AcmeSolvers.Http01:
+		solver = []string{
+			"- http01:",
+			"    ingress:",
+			"      ingressClassName: " + ingressClass,
+		}
+	case AcmeSolvers.Dns01Cloudflare:
+		secretKey, secretValue = "api-token", acme.CloudflareApiToken
+		solver = []string{
+			"- dns01:",
+			"    cloudflare:",
+			"      apiTokenSecretRef:",
+			"        name: " + AcmeIssuerName + "-dns",
+			"        key: " + secretKey,
+		}
+	case AcmeSolvers.Dns01Route53:
+		secretKey, secretValue = "secret-access-key", acme.Route53SecretAccessKey
+		solver = []string{
+			"- dns01:",
+			"    route53:",
+			"      region: " + acme.Route53Region,
+			"      accessKeyID: " + acme.Route53AccessKeyId,
+			"      secretAccessKeySecretRef:",
+			"        name: " + AcmeIssuerName + "-dns",
+			"        key: " + secretKey,
+		}
+		if acme.Route53HostedZoneId != "" {
+			solver = append(solver, "      hostedZoneID: "+acme.Route53HostedZoneId)
+		}
+	case AcmeSolvers.Dns01Rfc2136:
+		secretKey, secretValue = "tsig-secret", acme.Rfc2136TsigSecret
+		algorithm := acme.Rfc2136TsigAlgorithm
+		if algorithm == "" {
+			algorithm = "HMACSHA512"
+		}
+		solver = []string{
+			"- dns01:",
+			"    rfc2136:",
+			"      nameserver: " + acme.Rfc2136Nameserver,
+			"      tsigKeyName: " + acme.Rfc2136TsigKeyName,
+			"      tsigAlgorithm: " + algorithm,
+			"      tsigSecretSecretRef:",
+			"        name: " + AcmeIssuerName + "-dns",
+			"        key: " + secretKey,
+		}
+	}
+
+	solverString := ""
+	for _, line := range solver {
+		solverString = solverString + "      " + line + "\n"
+	}
+
+	envs = append(envs, "IDO_ACME_SERVER="+acme.GetDirectoryUrl())
+	envs = append(envs, "IDO_ACME_SOLVER="+solverString)
+	envs = append(envs, "IDO_ACME_ISSUER="+AcmeIssuerName)
+	envs = append(envs, "IDO_ACME_DNS_SECRET_KEY="+secretKey)
+	envs = append(envs, "IDO_ACME_DNS_SECRET_VALUE="+secretValue)
+	envs = append(envs, "IDO_ACME_WILDCARD="+fmt.Sprint(acme.Wildcard))
+	return
+}
+
+func (acme *AcmeConfig) redact() {
+	acme.DirectoryUrl = redactUrl(acme.DirectoryUrl)
+	acme.CloudflareApiToken = redact(acme.CloudflareApiToken)
+	acme.Route53SecretAccessKey = redact(acme.Route53SecretAccessKey)
+	acme.Rfc2136TsigSecret = redact(acme.Rfc2136TsigSecret)
+}
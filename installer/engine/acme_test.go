@@ -0,0 +1,81 @@
+package engine
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAws(t *testing.T) {
+	// get-vanilla of the test suite of AWS Signature Version 4
+	request, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	signAws(request, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service",
+		time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := request.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestCheckDnsCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch {
+		case request.URL.Path == "/client/v4/user/tokens/verify":
+			if request.Header.Get("Authorization") == "Bearer good" {
+				writer.Write([]byte(`{"success":true,"result":{"status":"active"}}`))
+			} else {
+				writer.WriteHeader(http.StatusUnauthorized)
+				writer.Write([]byte(`{"success":false,"errors":[{"code":1000,"message":"Invalid API Token"}]}`))
+			}
+		case strings.HasPrefix(request.URL.Path, "/2013-04-01/hostedzone"):
+			if strings.Contains(request.Header.Get("Authorization"), "Credential=AKIDGOOD/") {
+				writer.Write([]byte(`<GetHostedZoneCountResponse/>`))
+			} else {
+				writer.WriteHeader(http.StatusForbidden)
+				writer.Write([]byte(`<ErrorResponse><Error><Code>InvalidClientTokenId</Code></Error></ErrorResponse>`))
+			}
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	defer func(cloudflare string, route53 string) {
+		cloudflareApiUrl, route53ApiUrl = cloudflare, route53
+	}(cloudflareApiUrl, route53ApiUrl)
+	cloudflareApiUrl = server.URL + "/client/v4"
+	route53ApiUrl = server.URL + "/2013-04-01"
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	tests := []struct {
+		name  string
+		acme  AcmeConfig
+		valid bool
+	}{
+		{"cloudflare", AcmeConfig{Solver: AcmeSolvers.Dns01Cloudflare, CloudflareApiToken: "good"}, true},
+		{"bad cloudflare token", AcmeConfig{Solver: AcmeSolvers.Dns01Cloudflare, CloudflareApiToken: "bad"}, false},
+		{"route53", AcmeConfig{Solver: AcmeSolvers.Dns01Route53, Route53AccessKeyId: "AKIDGOOD",
+			Route53SecretAccessKey: "secret", Route53HostedZoneId: "Z123"}, true},
+		{"bad route53 key", AcmeConfig{Solver: AcmeSolvers.Dns01Route53, Route53AccessKeyId: "AKIDBAD",
+			Route53SecretAccessKey: "secret"}, false},
+		{"rfc2136 nameserver down", AcmeConfig{Solver: AcmeSolvers.Dns01Rfc2136,
+			Rfc2136Nameserver: listener.Addr().String()}, false},
+		{"http-01", AcmeConfig{Solver: AcmeSolvers.Http01}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.acme.CheckDnsCredentials()
+			if (err == nil) != test.valid {
+				t.Errorf("CheckDnsCredentials() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
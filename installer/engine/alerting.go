@@ -0,0 +1,333 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AlertReceiver is where Alertmanager sends the alerts of the severities and namespaces it
+// routes, every one when they are empty.
+type AlertReceiver struct {
+	Name string
+	Type string
+
+	// DingTalk robot, sent through the prometheus-webhook-dingtalk release
+	AccessToken string
+	Secret      string
+
+	// Email
+	SmtpHost       string
+	SmtpPort       int
+	SmtpUsername   string
+	SmtpPassword   string
+	SmtpRequireTls bool
+	EmailFrom      string
+	EmailTo        string
+
+	// Webhook, and the webhook trigger of a Feishu flow
+	Url string
+
+	// WeCom application
+	CorpId    string
+	AgentId   string
+	ApiSecret string
+	ToUser    string
+
+	Severities string
+	Namespaces string
+}
+
+// AlertReceiverType are the types of receivers. A Feishu flow is started by the webhook trigger
+// of the flow, which takes the alerts of Alertmanager as they are. The custom bots of Feishu
+// only take their own messages, Alertmanager can't notify them.
+type AlertReceiverType struct {
+	DingTalk   string
+	Email      string
+	Webhook    string
+	WeCom      string
+	FeishuFlow string
+}
+
+var AlertReceiverTypes = AlertReceiverType{
+	DingTalk:   "DingTalk robot",
+	Email:      "Email",
+	Webhook:    "Webhook",
+	WeCom:      "WeCom application",
+	FeishuFlow: "Feishu flow webhook trigger",
+}
+
+const (
+	// dingTalkWebhookUrl is the service of the prometheus-webhook-dingtalk release, followed by
+	// the target of a robot and /send.
+	dingTalkWebhookUrl = "http://prometheus-webhook-dingtalk.monitoring/dingtalk/"
+	dingTalkRobotUrl   = "https://oapi.dingtalk.com/robot/send?access_token="
+	// wechatApiUrl is the default api_url of the wechat_configs of Alertmanager
+	wechatApiUrl = "https://qyapi.weixin.qq.com/cgi-bin/"
+)
+
+var (
+	receiverNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	labelValuePattern   = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	// feishuBotPattern matches the URLs of the custom bots of Feishu and Lark
+	feishuBotPattern = regexp.MustCompile(`^https?://open\.(feishu\.cn|larksuite\.com)/open-apis/bot/`)
+)
+
+// NewAlertReceiver returns a receiver of receiverType with the defaults of the wizard.
+func NewAlertReceiver(name string, receiverType string) AlertReceiver {
+	receiver := AlertReceiver{Name: name, Type: receiverType}
+	switch receiverType {
+	case AlertReceiverTypes.Email:
+		receiver.SmtpPort = 25
+		receiver.SmtpRequireTls = true
+	case AlertReceiverTypes.WeCom:
+		receiver.ToUser = "@all"
+	}
+	return receiver
+}
+
+// ValidateReceivers checks the receivers of the alerts.
+func (config *PrometheusConfig) ValidateReceivers() error {
+	names := map[string]bool{}
+	for _, receiver := range config.Receivers {
+		err := ValidateReceiverName(receiver.Name)
+		if err != nil {
+			return err
+		}
+		if names[receiver.Name] {
+			return errors.New("Receiver " + receiver.Name + " is added twice.")
+		}
+		names[receiver.Name] = true
+
+		err = receiver.validate()
+		if err != nil {
+			return errors.New("Receiver " + receiver.Name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// ValidateReceiverName checks that name can name a receiver of Alertmanager and a target of the
+// DingTalk webhook.
+func ValidateReceiverName(name string) error {
+	if name == "" {
+		return errors.New("Receiver name is empty.")
+	}
+	if !receiverNamePattern.MatchString(name) || name == "null" {
+		return errors.New("Receiver name " + name + " may only have letters, digits, '_' and '-'.")
+	}
+	return nil
+}
+
+func (receiver *AlertReceiver) validate() error {
+	switch receiver.Type {
+	case AlertReceiverTypes.DingTalk:
+		if receiver.AccessToken == "" {
+			return errors.New("Access token is empty.")
+		}
+		if _, err := url.ParseRequestURI(receiver.robotUrl()); err != nil {
+			return errors.New("Robot URL format is wrong.")
+		}
+	case AlertReceiverTypes.Email:
+		if receiver.SmtpHost == "" {
+			return errors.New("SMTP host is empty.")
+		}
+		if receiver.SmtpPort <= 0 || receiver.SmtpPort > 65535 {
+			return errors.New("SMTP port is out of range.")
+		}
+		if receiver.SmtpUsername != "" && receiver.SmtpPassword == "" {
+			return errors.New("SMTP password is empty.")
+		}
+		if _, err := mail.ParseAddress(receiver.EmailFrom); err != nil {
+			return errors.New("Email sender is empty or format is wrong.")
+		}
+		if _, err := mail.ParseAddressList(receiver.EmailTo); err != nil {
+			return errors.New("Email recipients are empty or format is wrong.")
+		}
+	case AlertReceiverTypes.Webhook, AlertReceiverTypes.FeishuFlow:
+		parsed, err := url.ParseRequestURI(receiver.Url)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return errors.New("URL is empty or format is wrong.")
+		}
+		if feishuBotPattern.MatchString(receiver.Url) {
+			return errors.New("URL is the one of a Feishu custom bot, which rejects the alerts of Alertmanager. " +
+				"Please use the webhook trigger of a Feishu flow.")
+		}
+	case AlertReceiverTypes.WeCom:
+		if receiver.CorpId == "" || receiver.AgentId == "" || receiver.ApiSecret == "" {
+			return errors.New("Corp ID, agent ID and secret are needed.")
+		}
+		if receiver.ToUser == "" {
+			return errors.New("Recipients are empty, @all sends to everyone.")
+		}
+	default:
+		return errors.New("Please select a receiver type.")
+	}
+
+	for _, value := range append(splitList(receiver.Severities), splitList(receiver.Namespaces)...) {
+		if !labelValuePattern.MatchString(value) {
+			return errors.New(value + " isn't a severity or namespace.")
+		}
+	}
+	return nil
+}
+
+func (receiver *AlertReceiver) redact() {
+	receiver.Url = redactUrl(receiver.Url)
+	receiver.AccessToken = redact(receiver.AccessToken)
+	receiver.Secret = redact(receiver.Secret)
+	receiver.SmtpPassword = redact(receiver.SmtpPassword)
+	receiver.ApiSecret = redact(receiver.ApiSecret)
+}
+
+// robotUrl returns the URL of the DingTalk robot, AccessToken being the token or the URL.
+func (receiver *AlertReceiver) robotUrl() string {
+	if strings.HasPrefix(receiver.AccessToken, "https://") || strings.HasPrefix(receiver.AccessToken, "http://") {
+		return receiver.AccessToken
+	}
+	return dingTalkRobotUrl + receiver.AccessToken
+}
+
+// alertmanagerReceiver returns the receiver of the Alertmanager config, notifying with the
+// templates of the template set when templates is set. Alertmanager has no NO_PROXY, the
+// receivers outside the NO_PROXY of proxy get the proxy in their own http_config.
+func (receiver *AlertReceiver) alertmanagerReceiver(templates bool, proxy *ProxyConfig) map[string]interface{} {
+	result := map[string]interface{}{"name": receiver.Name}
+	switch receiver.Type {
+	case AlertReceiverTypes.DingTalk:
+		// The webhook is in the cluster, it calls the robot through the proxy itself
+		result["webhook_configs"] = []interface{}{map[string]interface{}{
+			"url":           dingTalkWebhookUrl + receiver.Name + "/send",
+			"send_resolved": true,
+		}}
+	case AlertReceiverTypes.Email:
+		emailConfig := map[string]interface{}{
+			"to":            receiver.EmailTo,
+			"from":          receiver.EmailFrom,
+			"smarthost":     net.JoinHostPort(receiver.SmtpHost, strconv.Itoa(receiver.SmtpPort)),
+			"require_tls":   receiver.SmtpRequireTls,
+			"send_resolved": true,
+		}
+		if receiver.SmtpUsername != "" {
+			emailConfig["auth_username"] = receiver.SmtpUsername
+			emailConfig["auth_password"] = receiver.SmtpPassword
+		}
+		if templates {
+			emailConfig["headers"] = map[string]interface{}{"Subject": templateReference(emailSubjectTemplate)}
+			emailConfig["html"] = templateReference(emailHtmlTemplate)
+		}
+		result["email_configs"] = []interface{}{emailConfig}
+	case AlertReceiverTypes.Webhook, AlertReceiverTypes.FeishuFlow:
+		webhookConfig := map[string]interface{}{
+			"url":           receiver.Url,
+			"send_resolved": true,
+		}
+		addProxyUrl(webhookConfig, proxy, receiver.Url)
+		result["webhook_configs"] = []interface{}{webhookConfig}
+	case AlertReceiverTypes.WeCom:
+		wechatConfig := map[string]interface{}{
+			"corp_id":       receiver.CorpId,
+			"agent_id":      receiver.AgentId,
+			"api_secret":    receiver.ApiSecret,
+			"to_user":       receiver.ToUser,
+			"send_resolved": true,
+		}
+		if templates {
+			wechatConfig["message"] = templateReference(wechatMessageTemplate)
+		}
+		addProxyUrl(wechatConfig, proxy, wechatApiUrl)
+		result["wechat_configs"] = []interface{}{wechatConfig}
+	}
+	return result
+}
+
+// addProxyUrl sets the http_config of the receiver config notifying target to the proxy of
+// target, if it has one.
+func addProxyUrl(receiverConfig map[string]interface{}, proxy *ProxyConfig, target string) {
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+	if proxyUrl := proxy.proxyUrl(targetUrl); proxyUrl != "" {
+		receiverConfig["http_config"] = map[string]interface{}{"proxy_url": proxyUrl}
+	}
+}
+
+// alertmanagerRoute returns the route of the Alertmanager config sending the alerts of the
+// severities and namespaces of receiver to it. The next routes are matched too.
+func (receiver *AlertReceiver) alertmanagerRoute() map[string]interface{} {
+	matchers := []string{}
+	if severities := splitList(receiver.Severities); len(severities) > 0 {
+		matchers = append(matchers, `severity =~ "`+strings.Join(severities, "|")+`"`)
+	}
+	if namespaces := splitList(receiver.Namespaces); len(namespaces) > 0 {
+		matchers = append(matchers, `namespace =~ "`+strings.Join(namespaces, "|")+`"`)
+	}
+	return map[string]interface{}{"receiver": receiver.Name, "matchers": matchers, "continue": true}
+}
+
+// alertingEnvs returns the routing, receivers and routes of the Alertmanager config, the routes
+// and receivers one YAML flow mapping per line indented as values-override.yaml lists them, and
+// the targets of the DingTalk webhook. External receivers are notified through proxy.
+func (config *PrometheusConfig) alertingEnvs(proxy *ProxyConfig) []string {
+	var receivers []string
+	var routes []string
+	targets := map[string]interface{}{}
+	templates := config.Templates.enabled()
+	// The routes of the routing tree come before the ones of the receivers
+	for index := range config.Routing.Routes {
+		routes = append(routes, "      - "+flowYaml(config.Routing.Routes[index].alertmanagerRoute()))
+	}
+	for index := range config.Receivers {
+		receiver := &config.Receivers[index]
+		receivers = append(receivers, "    - "+flowYaml(receiver.alertmanagerReceiver(templates, proxy)))
+		routes = append(routes, "      - "+flowYaml(receiver.alertmanagerRoute()))
+		if receiver.Type == AlertReceiverTypes.DingTalk {
+			target := map[string]interface{}{"url": receiver.robotUrl()}
+			if receiver.Secret != "" {
+				target["secret"] = receiver.Secret
+			}
+			if templates {
+				target["message"] = map[string]interface{}{
+					"title": templateReference(dingTalkTitleTemplate),
+					"text":  templateReference(dingTalkContentTemplate),
+				}
+			}
+			targets[receiver.Name] = target
+		}
+	}
+	return append(config.Routing.routingEnvs(),
+		"IDO_ALERTMANAGER_RECEIVERS="+strings.Join(receivers, "\n"),
+		"IDO_ALERTMANAGER_ROUTES="+strings.Join(routes, "\n"),
+		"IDO_DINGTALK_ENABLED="+strconv.FormatBool(len(targets) > 0),
+		"IDO_DINGTALK_TARGETS="+flowYaml(targets),
+	)
+}
+
+// flowYaml returns value as a YAML flow collection, the JSON of value.
+func flowYaml(value interface{}) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	// Values are maps, slices and strings, which always encode
+	_ = encoder.Encode(value)
+	return strings.TrimSpace(buffer.String())
+}
+
+// splitList returns the items of a comma separated list.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,144 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAlertmanagerReceiver(t *testing.T) {
+	email := AlertReceiver{Name: "mail", Type: AlertReceiverTypes.Email, SmtpHost: "smtp.example.com", SmtpPort: 25,
+		SmtpRequireTls: true, EmailFrom: "alerts@example.com", EmailTo: "ops@example.com"}
+	emailAuth := email
+	emailAuth.SmtpUsername, emailAuth.SmtpPassword = "alerts", "secret"
+	tests := []struct {
+		name      string
+		receiver  AlertReceiver
+		templates bool
+		want      string
+	}{
+		{"dingtalk through the webhook", AlertReceiver{Name: "robot", Type: AlertReceiverTypes.DingTalk,
+			AccessToken: "token", Secret: "secret"}, false,
+			`{"name":"robot","webhook_configs":[{"send_resolved":true,` +
+				`"url":"http://prometheus-webhook-dingtalk.monitoring/dingtalk/robot/send"}]}`},
+		{"email", email, false,
+			`{"email_configs":[{"from":"alerts@example.com","require_tls":true,"send_resolved":true,` +
+				`"smarthost":"smtp.example.com:25","to":"ops@example.com"}],"name":"mail"}`},
+		{"email with auth and templates", emailAuth, true,
+			`{"email_configs":[{"auth_password":"secret","auth_username":"alerts","from":"alerts@example.com",` +
+				`"headers":{"Subject":"{{ template \"om_kits.email.subject\" . }}"},` +
+				`"html":"{{ template \"om_kits.email.html\" . }}","require_tls":true,"send_resolved":true,` +
+				`"smarthost":"smtp.example.com:25","to":"ops@example.com"}],"name":"mail"}`},
+		{"webhook", AlertReceiver{Name: "ops", Type: AlertReceiverTypes.Webhook,
+			Url: "https://hooks.example.com/alerts"}, true,
+			`{"name":"ops","webhook_configs":[{"send_resolved":true,"url":"https://hooks.example.com/alerts"}]}`},
+		{"feishu flow", AlertReceiver{Name: "flow", Type: AlertReceiverTypes.FeishuFlow,
+			Url: "https://www.feishu.cn/flow/api/trigger-webhook/0123456789abcdef"}, false,
+			`{"name":"flow","webhook_configs":[{"send_resolved":true,` +
+				`"url":"https://www.feishu.cn/flow/api/trigger-webhook/0123456789abcdef"}]}`},
+		{"wecom", AlertReceiver{Name: "wecom", Type: AlertReceiverTypes.WeCom, CorpId: "corp", AgentId: "1000002",
+			ApiSecret: "secret", ToUser: "@all"}, false,
+			`{"name":"wecom","wechat_configs":[{"agent_id":"1000002","api_secret":"secret","corp_id":"corp",` +
+				`"send_resolved":true,"to_user":"@all"}]}`},
+		{"wecom with templates", AlertReceiver{Name: "wecom", Type: AlertReceiverTypes.WeCom, CorpId: "corp",
+			AgentId: "1000002", ApiSecret: "secret", ToUser: "zhangsan|lisi"}, true,
+			`{"name":"wecom","wechat_configs":[{"agent_id":"1000002","api_secret":"secret","corp_id":"corp",` +
+				`"message":"{{ template \"om_kits.wechat.message\" . }}","send_resolved":true,"to_user":"zhangsan|lisi"}]}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := flowYaml(test.receiver.alertmanagerReceiver(test.templates, &ProxyConfig{}))
+			if got != test.want {
+				t.Errorf("receiver = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAlertmanagerReceiverRoute(t *testing.T) {
+	tests := []struct {
+		name       string
+		severities string
+		namespaces string
+		want       string
+	}{
+		{"every alert", "", "", `{"continue":true,"matchers":[],"receiver":"ops"}`},
+		{"severities", "critical, warning", "",
+			`{"continue":true,"matchers":["severity =~ \"critical|warning\""],"receiver":"ops"}`},
+		{"severities and namespaces", "critical", "default,kube-system",
+			`{"continue":true,"matchers":["severity =~ \"critical\"","namespace =~ \"default|kube-system\""],` +
+				`"receiver":"ops"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiver := AlertReceiver{Name: "ops", Type: AlertReceiverTypes.FeishuFlow,
+				Severities: test.severities, Namespaces: test.namespaces}
+			if got := flowYaml(receiver.alertmanagerRoute()); got != test.want {
+				t.Errorf("route = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAlertingEnvsDingTalkTargets(t *testing.T) {
+	tests := []struct {
+		name      string
+		receivers []AlertReceiver
+		templates string
+		enabled   string
+		targets   string
+	}{
+		{"no dingtalk robot", []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.Webhook,
+			Url: "https://hooks.example.com/alerts"}}, "", "false", `{}`},
+		{"access token", []AlertReceiver{{Name: "robot", Type: AlertReceiverTypes.DingTalk, AccessToken: "token"}},
+			"", "true", `{"robot":{"url":"https://oapi.dingtalk.com/robot/send?access_token=token"}}`},
+		{"robot url and secret", []AlertReceiver{{Name: "robot", Type: AlertReceiverTypes.DingTalk,
+			AccessToken: "https://oapi.dingtalk.com/robot/send?access_token=token", Secret: "SEC0123"}},
+			"", "true", `{"robot":{"secret":"SEC0123","url":"https://oapi.dingtalk.com/robot/send?access_token=token"}}`},
+		{"templates", []AlertReceiver{{Name: "robot", Type: AlertReceiverTypes.DingTalk, AccessToken: "token"},
+			{Name: "mail", Type: AlertReceiverTypes.Email}}, AlertTemplateSets.Concise, "true",
+			`{"robot":{"message":{"text":"{{ template \"om_kits.dingtalk.content\" . }}",` +
+				`"title":"{{ template \"om_kits.dingtalk.title\" . }}"},` +
+				`"url":"https://oapi.dingtalk.com/robot/send?access_token=token"}}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig().Prometheus
+			config.Receivers = test.receivers
+			config.Templates.Set = test.templates
+			envs := map[string]string{}
+			for _, env := range config.alertingEnvs(&ProxyConfig{}) {
+				key, value, _ := strings.Cut(env, "=")
+				envs[key] = value
+			}
+			if envs["IDO_DINGTALK_ENABLED"] != test.enabled || envs["IDO_DINGTALK_TARGETS"] != test.targets {
+				t.Errorf("IDO_DINGTALK_ENABLED = %s, IDO_DINGTALK_TARGETS = %s, want %s, %s",
+					envs["IDO_DINGTALK_ENABLED"], envs["IDO_DINGTALK_TARGETS"], test.enabled, test.targets)
+			}
+			// One receiver and one route per receiver, after the ones of the routing tree
+			if got := strings.Count(envs["IDO_ALERTMANAGER_RECEIVERS"], "\n") + 1; got != len(test.receivers) {
+				t.Errorf("%d receivers, want %d", got, len(test.receivers))
+			}
+		})
+	}
+}
+
+func TestFeishuFlowReceiverValidate(t *testing.T) {
+	tests := []struct {
+		url string
+		err string
+	}{
+		{"https://www.feishu.cn/flow/api/trigger-webhook/0123456789abcdef", ""},
+		{"https://open.feishu.cn/open-apis/bot/v2/hook/0123-4567", "URL is the one of a Feishu custom bot"},
+		{"https://open.larksuite.com/open-apis/bot/v2/hook/0123-4567", "URL is the one of a Feishu custom bot"},
+		{"www.feishu.cn/flow", "URL is empty or format is wrong."},
+	}
+	for _, test := range tests {
+		for _, receiverType := range []string{AlertReceiverTypes.FeishuFlow, AlertReceiverTypes.Webhook} {
+			receiver := AlertReceiver{Name: "flow", Type: receiverType, Url: test.url}
+			err := receiver.validate()
+			if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.HasPrefix(err.Error(), test.err)) {
+				t.Errorf("%s %s: validate() = %v, want %q", receiverType, test.url, err, test.err)
+			}
+		}
+	}
+}
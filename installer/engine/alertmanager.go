@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// prometheusValues is the values file of packages/prometheus/install.sh, which envsubst renders.
+const prometheusValues = "packages/prometheus/values-override.yaml"
+
+// envsubstPattern matches the variables envsubst substitutes, like ${IDO_TLS_KEY} or $HOME.
+var envsubstPattern = regexp.MustCompile(`\$\{[A-Za-z_][A-Za-z0-9_]*\}|\$[A-Za-z_][A-Za-z0-9_]*`)
+
+// envsubst substitutes the variables of text like envsubst run with envs, the variables of a
+// plan set after the ones of the environment. Unset variables are substituted with nothing.
+func envsubst(text string, envs []string) string {
+	values := map[string]string{}
+	for _, env := range append(os.Environ(), envs...) {
+		key, value, _ := strings.Cut(env, "=")
+		values[key] = value
+	}
+	return envsubstPattern.ReplaceAllStringFunc(text, func(variable string) string {
+		return values[strings.Trim(variable, "${}")]
+	})
+}
+
+// alertmanagerConfig is the Alertmanager config the installer renders, which is decoded strictly:
+// a key Alertmanager doesn't know or a value of the wrong type fails like in Alertmanager.
+type alertmanagerConfig struct {
+	Global        map[string]interface{}           `yaml:"global"`
+	Route         *alertmanagerRouteConfig         `yaml:"route"`
+	InhibitRules  []alertmanagerInhibitRuleConfig  `yaml:"inhibit_rules"`
+	Receivers     []alertmanagerReceiverConfig     `yaml:"receivers"`
+	TimeIntervals []alertmanagerTimeIntervalConfig `yaml:"time_intervals"`
+	Templates     []string                         `yaml:"templates"`
+}
+
+type alertmanagerRouteConfig struct {
+	Receiver          string                    `yaml:"receiver"`
+	GroupBy           []string                  `yaml:"group_by"`
+	Matchers          []string                  `yaml:"matchers"`
+	Continue          bool                      `yaml:"continue"`
+	GroupWait         string                    `yaml:"group_wait"`
+	GroupInterval     string                    `yaml:"group_interval"`
+	RepeatInterval    string                    `yaml:"repeat_interval"`
+	MuteTimeIntervals []string                  `yaml:"mute_time_intervals"`
+	Routes            []alertmanagerRouteConfig `yaml:"routes"`
+}
+
+type alertmanagerInhibitRuleConfig struct {
+	SourceMatchers []string `yaml:"source_matchers"`
+	TargetMatchers []string `yaml:"target_matchers"`
+	Equal          []string `yaml:"equal"`
+}
+
+type alertmanagerReceiverConfig struct {
+	Name         string `yaml:"name"`
+	EmailConfigs []struct {
+		To           string            `yaml:"to"`
+		From         string            `yaml:"from"`
+		Smarthost    string            `yaml:"smarthost"`
+		RequireTls   bool              `yaml:"require_tls"`
+		AuthUsername string            `yaml:"auth_username"`
+		AuthPassword string            `yaml:"auth_password"`
+		Headers      map[string]string `yaml:"headers"`
+		Html         string            `yaml:"html"`
+		SendResolved bool              `yaml:"send_resolved"`
+	} `yaml:"email_configs"`
+	WebhookConfigs []struct {
+		Url          string                  `yaml:"url"`
+		HttpConfig   *alertmanagerHttpConfig `yaml:"http_config"`
+		SendResolved bool                    `yaml:"send_resolved"`
+	} `yaml:"webhook_configs"`
+	WechatConfigs []struct {
+		CorpId       string                  `yaml:"corp_id"`
+		AgentId      string                  `yaml:"agent_id"`
+		ApiSecret    string                  `yaml:"api_secret"`
+		ToUser       string                  `yaml:"to_user"`
+		Message      string                  `yaml:"message"`
+		HttpConfig   *alertmanagerHttpConfig `yaml:"http_config"`
+		SendResolved bool                    `yaml:"send_resolved"`
+	} `yaml:"wechat_configs"`
+}
+
+type alertmanagerHttpConfig struct {
+	ProxyUrl string `yaml:"proxy_url"`
+}
+
+type alertmanagerTimeIntervalConfig struct {
+	Name          string `yaml:"name"`
+	TimeIntervals []struct {
+		Times []struct {
+			StartTime string `yaml:"start_time"`
+			EndTime   string `yaml:"end_time"`
+		} `yaml:"times"`
+		Weekdays    []string `yaml:"weekdays"`
+		DaysOfMonth []string `yaml:"days_of_month"`
+		Months      []string `yaml:"months"`
+		Years       []string `yaml:"years"`
+		Location    string   `yaml:"location"`
+	} `yaml:"time_intervals"`
+}
+
+// renderAlertmanagerConfig renders the values file of Prometheus in dir like install.sh does
+// with envs, and returns the Alertmanager config Helm applies.
+func renderAlertmanagerConfig(dir string, envs []string) ([]byte, error) {
+	content, err := os.ReadFile(filepath.Join(dir, prometheusValues))
+	if err != nil {
+		return nil, err
+	}
+	var values struct {
+		Alertmanager struct {
+			Config yaml.Node `yaml:"config"`
+		} `yaml:"alertmanager"`
+	}
+	err = yaml.Unmarshal([]byte(envsubst(string(content), envs)), &values)
+	if err != nil {
+		return nil, errors.New("Rendered values of Prometheus: " + err.Error())
+	}
+	if values.Alertmanager.Config.Kind == 0 {
+		return nil, errors.New("Rendered values of Prometheus have no alertmanager.config.")
+	}
+	return yaml.Marshal(&values.Alertmanager.Config)
+}
+
+// parseAlertmanagerConfig parses the rendered Alertmanager config and checks it like
+// Alertmanager loads it: the matchers and durations parse, and the receivers and time intervals
+// the routes name exist.
+func parseAlertmanagerConfig(content []byte) (*alertmanagerConfig, error) {
+	var config alertmanagerConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil {
+		return nil, errors.New("Alertmanager config: " + err.Error())
+	}
+	if config.Route == nil || config.Route.Receiver == "" {
+		return nil, errors.New("Alertmanager config: The root route has no receiver.")
+	}
+	if len(config.Route.Matchers) > 0 {
+		return nil, errors.New("Alertmanager config: The root route has matchers.")
+	}
+
+	receivers := map[string]bool{}
+	for _, receiver := range config.Receivers {
+		if receiver.Name == "" {
+			return nil, errors.New("Alertmanager config: A receiver has no name.")
+		}
+		if receivers[receiver.Name] {
+			return nil, errors.New("Alertmanager config: Receiver " + receiver.Name + " is defined twice.")
+		}
+		receivers[receiver.Name] = true
+		if err := receiver.validate(); err != nil {
+			return nil, errors.New("Alertmanager config: Receiver " + receiver.Name + ": " + err.Error())
+		}
+	}
+
+	intervals := map[string]bool{}
+	for _, interval := range config.TimeIntervals {
+		if intervals[interval.Name] {
+			return nil, errors.New("Alertmanager config: Time interval " + interval.Name + " is defined twice.")
+		}
+		intervals[interval.Name] = true
+		for _, spec := range interval.TimeIntervals {
+			var times []string
+			for _, timeRange := range spec.Times {
+				times = append(times, timeRange.StartTime+"-"+timeRange.EndTime)
+			}
+			muteTimeInterval := MuteTimeInterval{Name: interval.Name, Times: strings.Join(times, ","),
+				Weekdays: strings.Join(spec.Weekdays, ","), DaysOfMonth: strings.Join(spec.DaysOfMonth, ","),
+				Months: strings.Join(spec.Months, ","), Years: strings.Join(spec.Years, ","), Location: spec.Location}
+			if err := muteTimeInterval.validate(); err != nil {
+				return nil, errors.New("Alertmanager config: Time interval " + interval.Name + ": " + err.Error())
+			}
+		}
+	}
+
+	for _, duration := range [][2]string{{"Group wait", config.Route.GroupWait},
+		{"Group interval", config.Route.GroupInterval}, {"Repeat interval", config.Route.RepeatInterval}} {
+		if err := validateDuration(duration[0], duration[1]); err != nil {
+			return nil, errors.New("Alertmanager config: Root route: " + err.Error())
+		}
+	}
+	if err := config.Route.validate("Root route", receivers, intervals); err != nil {
+		return nil, errors.New("Alertmanager config: " + err.Error())
+	}
+	for index, rule := range config.InhibitRules {
+		for _, matchers := range append(append([]string{}, rule.SourceMatchers...), rule.TargetMatchers...) {
+			if _, err := parseMatchers(matchers); err != nil {
+				return nil, fmt.Errorf("Alertmanager config: Inhibition rule %d: %s", index+1, err.Error())
+			}
+		}
+	}
+	return &config, nil
+}
+
+// validate checks route and its child routes, path naming it like Route 1.2.
+func (route *alertmanagerRouteConfig) validate(path string, receivers map[string]bool, intervals map[string]bool) error {
+	fail := func(err error) error {
+		return errors.New(path + ": " + err.Error())
+	}
+	if route.Receiver != "" && !receivers[route.Receiver] {
+		return fail(errors.New("Receiver " + route.Receiver + " doesn't exist."))
+	}
+	for _, matchers := range route.Matchers {
+		if _, err := parseMatchers(matchers); err != nil {
+			return fail(err)
+		}
+	}
+	if err := validateGroupBy(strings.Join(route.GroupBy, ",")); err != nil {
+		return fail(err)
+	}
+	for _, duration := range [][2]string{{"Group wait", route.GroupWait},
+		{"Group interval", route.GroupInterval}, {"Repeat interval", route.RepeatInterval}} {
+		if duration[1] != "" {
+			if err := validateDuration(duration[0], duration[1]); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	for _, name := range route.MuteTimeIntervals {
+		if !intervals[name] {
+			return fail(errors.New("Time interval " + name + " doesn't exist."))
+		}
+	}
+	prefix := path + "."
+	if path == "Root route" {
+		prefix = "Route "
+	}
+	for index := range route.Routes {
+		if err := route.Routes[index].validate(prefix+strconv.Itoa(index+1), receivers, intervals); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the integrations of the receiver the installer configures.
+func (receiver *alertmanagerReceiverConfig) validate() error {
+	for _, email := range receiver.EmailConfigs {
+		if email.To == "" || email.From == "" {
+			return errors.New("Email sender or recipients are empty.")
+		}
+		if _, _, err := net.SplitHostPort(email.Smarthost); err != nil {
+			return errors.New("Smarthost " + email.Smarthost + " isn't like smtp.example.com:25.")
+		}
+	}
+	for _, webhook := range receiver.WebhookConfigs {
+		if err := validateConfigUrl("Webhook URL", webhook.Url); err != nil {
+			return err
+		}
+		if webhook.HttpConfig != nil {
+			if err := validateConfigUrl("Proxy URL", webhook.HttpConfig.ProxyUrl); err != nil {
+				return err
+			}
+		}
+	}
+	for _, wechat := range receiver.WechatConfigs {
+		if wechat.CorpId == "" || wechat.ApiSecret == "" {
+			return errors.New("Corp ID or secret is empty.")
+		}
+		if wechat.HttpConfig != nil {
+			if err := validateConfigUrl("Proxy URL", wechat.HttpConfig.ProxyUrl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateConfigUrl(name string, rawUrl string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New(name + " " + rawUrl + " isn't an http or https URL.")
+	}
+	return nil
+}
+
+// countRouteConfigs returns the number of routes below route.
+func countRouteConfigs(route *alertmanagerRouteConfig) int {
+	count := len(route.Routes)
+	for index := range route.Routes {
+		count += countRouteConfigs(&route.Routes[index])
+	}
+	return count
+}
+
+// amtoolCheckConfig runs amtool check-config on the rendered config when amtool is installed,
+// and reports whether it ran.
+func amtoolCheckConfig(ctx context.Context, content []byte, output io.Writer) (bool, error) {
+	amtool, err := exec.LookPath("amtool")
+	if err != nil {
+		return false, nil
+	}
+	file, err := os.CreateTemp("", "alertmanager-*.yaml")
+	if err != nil {
+		return true, err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return true, err
+	}
+	result, err := exec.CommandContext(ctx, amtool, "check-config", file.Name()).CombinedOutput()
+	output.Write(result)
+	if err != nil {
+		return true, errors.New("amtool check-config rejects the rendered Alertmanager config.")
+	}
+	return true, nil
+}
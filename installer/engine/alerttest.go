@@ -0,0 +1,519 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	// TestAlertName is the alertname of the alerts sent to verify the receivers.
+	TestAlertName = "OmKitsTestAlert"
+	// testAlertReceiverLabel tells the test alerts of the receivers apart, no route matches it.
+	testAlertReceiverLabel = "om_kits_receiver"
+
+	alertmanagerService     = "svc/prometheus-kube-prometheus-alertmanager"
+	alertmanagerPort        = 9093
+	alertmanagerRoutePrefix = "/alertmanager"
+	dingTalkDeployment      = "deployment/prometheus-webhook-dingtalk"
+)
+
+// forwardingPattern matches the local port kubectl port-forward listens on.
+var forwardingPattern = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+) ->`)
+
+// ReceiverTestResult is whether Alertmanager delivered the test alert of a receiver.
+type ReceiverTestResult struct {
+	Receiver  string
+	Delivered bool
+	// Confirmed is false when Alertmanager may have notified other receivers through the same
+	// integration meanwhile, the notification counted may be one of theirs.
+	Confirmed bool
+	Message   string
+}
+
+// AlertTester sends a test alert to each receiver through the Alertmanager API at Url and
+// follows the notifications with the metrics of Alertmanager. The metrics of Alertmanager 0.25
+// count the notifications by integration only, so the receivers are tested one after another
+// and the test alerts are only resolved at the end. A delivery isn't confirmed when other
+// alerts, or the test alert itself, are routed to other receivers of the same integration. The
+// dingtalk webhook answers Alertmanager with the error of the robot, which counts it as failed.
+type AlertTester struct {
+	// Url is the Alertmanager URL, route prefix included.
+	Url string
+	// Timeout is how long the notification of a receiver is waited for, group_wait included.
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Client       *http.Client
+	// Receivers are the receivers of the Alertmanager config, their integrations tell the
+	// notifications of a receiver from theirs. The receivers tested are used when it's empty.
+	Receivers []AlertReceiver
+}
+
+// NewAlertTester returns a tester of the Alertmanager at alertmanagerUrl, waiting for the 30s
+// group_wait of values-override.yaml and a few retries of the notification.
+func NewAlertTester(alertmanagerUrl string) *AlertTester {
+	return &AlertTester{
+		Url:          strings.TrimSuffix(alertmanagerUrl, "/"),
+		Timeout:      2 * time.Minute,
+		PollInterval: 5 * time.Second,
+		Client:       alertmanagerClient,
+	}
+}
+
+// TestReceivers sends the test alert of every receiver and reports to output whether it was
+// delivered. The test alerts are resolved before it returns.
+func (tester *AlertTester) TestReceivers(ctx context.Context, receivers []AlertReceiver,
+	output io.Writer) ([]ReceiverTestResult, error) {
+	var results []ReceiverTestResult
+	known := tester.Receivers
+	if len(known) == 0 {
+		known = receivers
+	}
+	// A test alert resolved earlier would be notified during the test of the next receivers
+	var sent []map[string]string
+	defer func() {
+		for _, labels := range sent {
+			_ = tester.postAlert(context.Background(), labels, time.Now())
+		}
+	}()
+	for index := range receivers {
+		receiver := &receivers[index]
+		fmt.Fprintln(output, "Sending a test alert to receiver "+receiver.Name+"...")
+		sent = append(sent, receiver.testAlertLabels())
+		result, err := tester.testReceiver(ctx, receiver, known)
+		if err != nil {
+			return results, err
+		}
+		switch {
+		case result.Delivered && result.Confirmed:
+			fmt.Fprintln(output, "Receiver "+receiver.Name+": delivered. "+result.Message)
+		case result.Delivered:
+			fmt.Fprintln(output, "Receiver "+receiver.Name+": delivered, not confirmed. "+result.Message)
+		default:
+			fmt.Fprintln(output, "Receiver "+receiver.Name+": NOT delivered. "+result.Message)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// testReceiver sends the test alert of receiver and follows it. receivers are the receivers of
+// the config.
+func (tester *AlertTester) testReceiver(ctx context.Context, receiver *AlertReceiver,
+	receivers []AlertReceiver) (ReceiverTestResult, error) {
+	result := ReceiverTestResult{Receiver: receiver.Name}
+	integration := receiver.integration()
+	before, err := tester.notificationCounts(ctx, integration)
+	if err != nil {
+		return result, err
+	}
+	err = tester.postAlert(ctx, receiver.testAlertLabels(), time.Now().Add(time.Hour))
+	if err != nil {
+		return result, err
+	}
+
+	routedTo, err := tester.routedTo(ctx, receiver.Name)
+	if err != nil {
+		return result, err
+	}
+	if !slices.Contains(routedTo, receiver.Name) {
+		result.Message = "The test alert isn't routed to it, check its severities and namespaces and the routes before it."
+		return result, nil
+	}
+	// Alertmanager may notify them through the integration meanwhile
+	shared := sharingReceivers(routedTo, receiver, receivers)
+
+	deadline := time.Now().Add(tester.Timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(tester.PollInterval):
+		}
+		after, err := tester.notificationCounts(ctx, integration)
+		if err != nil {
+			return result, err
+		}
+		busy, err := tester.busyReceivers(ctx)
+		if err != nil {
+			return result, err
+		}
+		for _, name := range sharingReceivers(busy, receiver, receivers) {
+			if !slices.Contains(shared, name) {
+				shared = append(shared, name)
+			}
+		}
+
+		sent := after[0] - before[0]
+		failed := after[1] - before[1]
+		if sent > failed {
+			result.Delivered = true
+			result.Confirmed = len(shared) == 0
+			result.Message = "Alertmanager notified it through " + integration + "."
+			if !result.Confirmed {
+				result.Message = "Alertmanager notified through " + integration + ", which " +
+					strings.Join(shared, ", ") + " may have used meanwhile, check that the test alert arrived."
+			}
+			return result, nil
+		}
+		if time.Now().After(deadline) {
+			if failed > 0 {
+				result.Message = fmt.Sprintf("%.0f notifications through %s failed, see the logs of Alertmanager",
+					failed, integration)
+				if receiver.Type == AlertReceiverTypes.DingTalk {
+					result.Message += " and of the dingtalk webhook"
+				}
+				result.Message += "."
+			} else {
+				result.Message = "Alertmanager sent no notification within " + tester.Timeout.String() + "."
+			}
+			return result, nil
+		}
+	}
+}
+
+// sharingReceivers returns the receivers of names, but receiver, notified through the
+// integration of receiver. A receiver not in receivers may be, the null receiver never is.
+func sharingReceivers(names []string, receiver *AlertReceiver, receivers []AlertReceiver) []string {
+	integrations := map[string]string{"null": ""}
+	for index := range receivers {
+		integrations[receivers[index].Name] = receivers[index].integration()
+	}
+	var shared []string
+	for _, name := range names {
+		integration, known := integrations[name]
+		if name != receiver.Name && (!known || integration == receiver.integration()) &&
+			!slices.Contains(shared, name) {
+			shared = append(shared, name)
+		}
+	}
+	return shared
+}
+
+// integration returns the integration of Alertmanager notifying receiver, as its metrics name it.
+func (receiver *AlertReceiver) integration() string {
+	switch receiver.Type {
+	case AlertReceiverTypes.Email:
+		return "email"
+	case AlertReceiverTypes.WeCom:
+		return "wechat"
+	}
+	return "webhook"
+}
+
+// testAlertLabels returns the labels of the test alert of receiver, with a severity and a
+// namespace its route matches.
+func (receiver *AlertReceiver) testAlertLabels() map[string]string {
+	severity := "warning"
+	if severities := splitList(receiver.Severities); len(severities) > 0 {
+		severity = severities[0]
+	}
+	namespace := "monitoring"
+	if namespaces := splitList(receiver.Namespaces); len(namespaces) > 0 {
+		namespace = namespaces[0]
+	}
+	return map[string]string{
+		"alertname":            TestAlertName,
+		"severity":             severity,
+		"namespace":            namespace,
+		testAlertReceiverLabel: receiver.Name,
+	}
+}
+
+// postAlert fires the alert with labels until endsAt, a past endsAt resolves it.
+func (tester *AlertTester) postAlert(ctx context.Context, labels map[string]string, endsAt time.Time) error {
+	alerts := []map[string]interface{}{{
+		"labels": labels,
+		"annotations": map[string]string{
+			"summary":     "Test alert of om-kits-installer",
+			"description": "Sent to verify receiver " + labels[testAlertReceiverLabel] + ", no action is needed.",
+		},
+		"startsAt": time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+		"endsAt":   endsAt.UTC().Format(time.RFC3339),
+	}}
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	_, err = tester.call(ctx, http.MethodPost, "/api/v2/alerts", body)
+	return err
+}
+
+// routedTo returns the receivers Alertmanager routes the test alert of receiver to.
+func (tester *AlertTester) routedTo(ctx context.Context, receiver string) ([]string, error) {
+	query := url.Values{"filter": {
+		`alertname="` + TestAlertName + `"`,
+		testAlertReceiverLabel + `="` + receiver + `"`,
+	}}
+	alerts, err := tester.alerts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var receivers []string
+	for _, alert := range alerts {
+		receivers = append(receivers, alert.receivers()...)
+	}
+	return receivers, nil
+}
+
+// busyReceivers returns the receivers of the firing alerts, but the test alerts.
+func (tester *AlertTester) busyReceivers(ctx context.Context) ([]string, error) {
+	query := url.Values{"active": {"true"}, "silenced": {"false"}, "inhibited": {"false"}}
+	alerts, err := tester.alerts(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var receivers []string
+	for _, alert := range alerts {
+		if alert.Labels["alertname"] != TestAlertName {
+			receivers = append(receivers, alert.receivers()...)
+		}
+	}
+	return receivers, nil
+}
+
+// apiAlert is an alert of the Alertmanager API.
+type apiAlert struct {
+	Labels    map[string]string `json:"labels"`
+	Receivers []struct {
+		Name string `json:"name"`
+	} `json:"receivers"`
+}
+
+func (alert *apiAlert) receivers() []string {
+	var names []string
+	for _, receiver := range alert.Receivers {
+		names = append(names, receiver.Name)
+	}
+	return names
+}
+
+// alerts returns the alerts of Alertmanager matching query.
+func (tester *AlertTester) alerts(ctx context.Context, query url.Values) ([]apiAlert, error) {
+	content, err := tester.call(ctx, http.MethodGet, "/api/v2/alerts?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	var alerts []apiAlert
+	err = json.Unmarshal(content, &alerts)
+	if err != nil {
+		return nil, errors.New("Unexpected answer of Alertmanager: " + err.Error())
+	}
+	return alerts, nil
+}
+
+// notificationCounts returns the notification requests Alertmanager made through integration and
+// the ones which failed. A request is counted once it is answered, unlike a notification.
+func (tester *AlertTester) notificationCounts(ctx context.Context, integration string) ([2]float64, error) {
+	var counts [2]float64
+	content, err := tester.call(ctx, http.MethodGet, "/metrics", nil)
+	if err != nil {
+		return counts, err
+	}
+	label := `integration="` + integration + `"`
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		name, rest, found := strings.Cut(line, "{")
+		if !found || !strings.Contains(rest, label) {
+			continue
+		}
+		index := -1
+		switch name {
+		case "alertmanager_notification_requests_total":
+			index = 0
+		case "alertmanager_notification_requests_failed_total":
+			index = 1
+		}
+		if index < 0 {
+			continue
+		}
+		fields := strings.Fields(rest[strings.LastIndex(rest, "}")+1:])
+		if len(fields) == 0 {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err == nil {
+			counts[index] += value
+		}
+	}
+	return counts, scanner.Err()
+}
+
+func (tester *AlertTester) call(ctx context.Context, method string, path string, body []byte) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, tester.Url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := tester.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("Alertmanager answered %s to %s %s: %s", response.Status, method, path,
+			strings.TrimSpace(string(content)))
+	}
+	return content, nil
+}
+
+// alertmanagerClient calls the local or forwarded Alertmanager, never behind the proxy.
+var alertmanagerClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: &http.Transport{Proxy: nil},
+}
+
+// ForwardAlertmanager forwards a local port to the Alertmanager of the Prometheus package once
+// it is ready, and returns its URL. The forward stops with ctx.
+func ForwardAlertmanager(ctx context.Context, output io.Writer) (string, error) {
+	fmt.Fprintln(output, "Waiting for Alertmanager to be ready...")
+	deadline := time.Now().Add(10 * time.Minute)
+	for {
+		alertmanagerUrl, err := forwardAlertmanager(ctx)
+		if err == nil {
+			return alertmanagerUrl, nil
+		}
+		if time.Now().After(deadline) {
+			return "", errors.New("Alertmanager isn't ready: " + err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func forwardAlertmanager(ctx context.Context) (string, error) {
+	forwardCtx, cancel := context.WithCancel(ctx)
+	cmd := exec.CommandContext(forwardCtx, "kubectl", "port-forward", "--namespace", "monitoring",
+		alertmanagerService, ":"+strconv.Itoa(alertmanagerPort))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, stdoutWriter := io.Pipe()
+	cmd.Stdout = stdoutWriter
+	err := cmd.Start()
+	if err != nil {
+		cancel()
+		return "", err
+	}
+	// kubectl exits when the forward fails or ctx is done
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		cancel()
+		stdoutWriter.Close()
+		close(exited)
+	}()
+
+	lines := bufio.NewScanner(stdout)
+	for lines.Scan() {
+		match := forwardingPattern.FindStringSubmatch(lines.Text())
+		if match == nil {
+			continue
+		}
+		// The rest of the output is not needed but must not block kubectl
+		go func() {
+			_, _ = io.Copy(io.Discard, stdout)
+		}()
+		alertmanagerUrl := "http://127.0.0.1:" + match[1] + alertmanagerRoutePrefix
+		_, err = NewAlertTester(alertmanagerUrl).call(forwardCtx, http.MethodGet, "/-/ready", nil)
+		if err != nil {
+			cancel()
+			return "", err
+		}
+		return alertmanagerUrl, nil
+	}
+	<-exited
+	return "", errors.New("kubectl port-forward exited: " + strings.TrimSpace(stderr.String()))
+}
+
+// alertTestTask returns the task verifying that the receivers get the test alerts. The packages
+// are installed by then, a receiver not getting its test alert is a warning, not a failure.
+func (config *PrometheusConfig) alertTestTask() Task {
+	receivers := append([]AlertReceiver(nil), config.Receivers...)
+	return Task{Name: "Verify Alert Receivers",
+		Func: func(ctx context.Context, dir string, output io.Writer) error {
+			_, err := VerifyReceivers(ctx, NewAlertTester(""), receivers, output)
+			if err != nil && ctx.Err() == nil {
+				fmt.Fprintln(output, "WARNING: The receivers can't be verified: "+err.Error())
+				fmt.Fprintln(output, "Run the test-alert command of the installer to verify them later.")
+				return nil
+			}
+			return err
+		}}
+}
+
+// VerifyReceivers sends the test alerts of receivers with tester, to the Alertmanager of the
+// Prometheus package through a port-forward when the Url of tester is empty. It reports the
+// receivers which didn't get their test alert to output, and fails only when they can't be
+// tested.
+func VerifyReceivers(ctx context.Context, tester *AlertTester, receivers []AlertReceiver,
+	output io.Writer) ([]ReceiverTestResult, error) {
+	if tester.Url == "" {
+		forwardCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		alertmanagerUrl, err := ForwardAlertmanager(forwardCtx, output)
+		if err != nil {
+			return nil, err
+		}
+		tester.Url = alertmanagerUrl
+
+		for _, receiver := range receivers {
+			if receiver.Type == AlertReceiverTypes.DingTalk {
+				result, err := ExecCommand("kubectl rollout status "+dingTalkDeployment+" --namespace monitoring "+
+					"--timeout 5m", 330)
+				fmt.Fprint(output, string(result))
+				if err != nil {
+					return nil, errors.New("The dingtalk webhook isn't ready.")
+				}
+				break
+			}
+		}
+		ctx = forwardCtx
+	}
+
+	results, err := tester.TestReceivers(ctx, receivers, output)
+	if err != nil {
+		return results, err
+	}
+	var failed []string
+	var unconfirmed []string
+	for _, result := range results {
+		if !result.Delivered {
+			failed = append(failed, result.Receiver)
+		} else if !result.Confirmed {
+			unconfirmed = append(unconfirmed, result.Receiver)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintln(output, "WARNING: Test alert not delivered to "+strings.Join(failed, ", ")+".")
+	}
+	if len(unconfirmed) > 0 {
+		fmt.Fprintln(output, "WARNING: Delivery to "+strings.Join(unconfirmed, ", ")+" isn't confirmed.")
+	}
+	if len(failed) == 0 && len(unconfirmed) == 0 {
+		fmt.Fprintln(output, "All receivers got the test alert, check that it arrived on their side.")
+	}
+	return results, nil
+}
@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAlertmanager is an Alertmanager stand-in notifying a receiver through the webhook
+// integration as soon as its test alert fires. The test alert of a receiver is routed to it, and
+// to the receivers of routes.
+type testAlertmanager struct {
+	receiverUrl string
+	routes      map[string][]string
+	// firing are the other alerts, by the receivers they are routed to
+	firing [][]string
+
+	mu       sync.Mutex
+	alerts   map[string]time.Time
+	requests float64
+	failed   float64
+}
+
+func (alertmanager *testAlertmanager) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	alertmanager.mu.Lock()
+	defer alertmanager.mu.Unlock()
+	switch {
+	case request.Method == http.MethodPost && request.URL.Path == "/api/v2/alerts":
+		var alerts []struct {
+			Labels map[string]string `json:"labels"`
+			EndsAt time.Time         `json:"endsAt"`
+		}
+		_ = json.NewDecoder(request.Body).Decode(&alerts)
+		for _, alert := range alerts {
+			receiver := alert.Labels[testAlertReceiverLabel]
+			alertmanager.alerts[receiver] = alert.EndsAt
+			if !alert.EndsAt.After(time.Now()) {
+				continue
+			}
+			for _, routed := range alertmanager.routedTo(receiver) {
+				alertmanager.requests++
+				response, err := http.Post(alertmanager.receiverUrl+"/"+routed, "application/json", nil)
+				if err != nil || response.StatusCode != http.StatusOK {
+					alertmanager.failed++
+				}
+				if err == nil {
+					response.Body.Close()
+				}
+			}
+		}
+	case request.URL.Path == "/api/v2/alerts":
+		var alerts []map[string]interface{}
+		for _, filter := range request.URL.Query()["filter"] {
+			if key, receiver, _ := strings.Cut(filter, "="); key == testAlertReceiverLabel {
+				receiver = strings.Trim(receiver, `"`)
+				alerts = append(alerts, apiAlertOf(TestAlertName, alertmanager.routedTo(receiver)))
+			}
+		}
+		if request.URL.Query().Get("active") == "true" {
+			for _, receivers := range alertmanager.firing {
+				alerts = append(alerts, apiAlertOf("KubePodCrashLooping", receivers))
+			}
+		}
+		_ = json.NewEncoder(writer).Encode(alerts)
+	case request.URL.Path == "/metrics":
+		fmt.Fprintf(writer, "alertmanager_notification_requests_total{integration=\"email\"} 7\n"+
+			"alertmanager_notification_requests_total{integration=\"webhook\"} %.0f\n"+
+			"alertmanager_notification_requests_failed_total{integration=\"webhook\"} %.0f\n",
+			alertmanager.requests, alertmanager.failed)
+	default:
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (alertmanager *testAlertmanager) routedTo(receiver string) []string {
+	if receiver == "unrouted" {
+		return []string{"null"}
+	}
+	return append([]string{receiver}, alertmanager.routes[receiver]...)
+}
+
+func apiAlertOf(alertname string, receivers []string) map[string]interface{} {
+	var routed []map[string]string
+	for _, receiver := range receivers {
+		routed = append(routed, map[string]string{"name": receiver})
+	}
+	return map[string]interface{}{"labels": map[string]string{"alertname": alertname}, "receivers": routed}
+}
+
+func TestAlertTester(t *testing.T) {
+	receivers := []AlertReceiver{
+		{Name: "ops", Type: AlertReceiverTypes.Webhook},
+		{Name: "team", Type: AlertReceiverTypes.FeishuFlow},
+		{Name: "mail", Type: AlertReceiverTypes.Email},
+		{Name: "broken", Type: AlertReceiverTypes.Webhook},
+		{Name: "unrouted", Type: AlertReceiverTypes.Webhook},
+	}
+	tests := []struct {
+		name      string
+		receiver  string
+		routes    map[string][]string
+		firing    [][]string
+		delivered bool
+		confirmed bool
+		message   string
+	}{
+		{name: "delivered", receiver: "ops", delivered: true, confirmed: true,
+			message: "Alertmanager notified it through webhook."},
+		{name: "alert of another integration firing", receiver: "ops", firing: [][]string{{"mail"}, {"null"}},
+			delivered: true, confirmed: true},
+		{name: "alert of the same integration firing", receiver: "ops", firing: [][]string{{"team"}},
+			delivered: true, message: "Alertmanager notified through webhook, which team may have used meanwhile, " +
+				"check that the test alert arrived."},
+		{name: "alert of an unknown receiver firing", receiver: "ops", firing: [][]string{{"other"}},
+			delivered: true},
+		{name: "test alert routed to another receiver", receiver: "broken", routes: map[string][]string{"broken": {"ops"}},
+			delivered: true},
+		{name: "failed", receiver: "broken",
+			message: "1 notifications through webhook failed, see the logs of Alertmanager."},
+		{name: "not routed", receiver: "unrouted",
+			message: "The test alert isn't routed to it, check its severities and namespaces and the routes before it."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receiverServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				if request.URL.Path == "/broken" {
+					writer.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer receiverServer.Close()
+			alertmanager := &testAlertmanager{receiverUrl: receiverServer.URL, routes: test.routes,
+				firing: test.firing, alerts: map[string]time.Time{}}
+			server := httptest.NewServer(alertmanager)
+			defer server.Close()
+
+			tester := &AlertTester{Url: server.URL, Timeout: 200 * time.Millisecond,
+				PollInterval: 10 * time.Millisecond, Client: server.Client(), Receivers: receivers}
+			var selected []AlertReceiver
+			for _, receiver := range receivers {
+				if receiver.Name == test.receiver {
+					selected = append(selected, receiver)
+				}
+			}
+			var output bytes.Buffer
+			results, err := tester.TestReceivers(context.Background(), selected, &output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := results[0]
+			if result.Delivered != test.delivered || result.Confirmed != test.confirmed {
+				t.Errorf("delivered %v, confirmed %v, want %v, %v: %s", result.Delivered, result.Confirmed,
+					test.delivered, test.confirmed, result.Message)
+			}
+			if test.message != "" && result.Message != test.message {
+				t.Errorf("message = %q, want %q", result.Message, test.message)
+			}
+			if endsAt := alertmanager.alerts[test.receiver]; endsAt.After(time.Now()) {
+				t.Errorf("test alert not resolved")
+			}
+		})
+	}
+}
+
+func TestVerifyReceiversWarns(t *testing.T) {
+	receiverServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/broken" {
+			writer.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer receiverServer.Close()
+	alertmanager := &testAlertmanager{receiverUrl: receiverServer.URL, alerts: map[string]time.Time{}}
+	server := httptest.NewServer(alertmanager)
+	defer server.Close()
+
+	tester := &AlertTester{Url: server.URL, Timeout: 200 * time.Millisecond, PollInterval: 10 * time.Millisecond,
+		Client: server.Client()}
+	receivers := []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.Webhook},
+		{Name: "broken", Type: AlertReceiverTypes.Webhook}}
+	var output bytes.Buffer
+	results, err := VerifyReceivers(context.Background(), tester, receivers, &output)
+	if err != nil {
+		t.Fatalf("VerifyReceivers() = %v, want a warning only", err)
+	}
+	if len(results) != 2 || !results[0].Delivered || results[1].Delivered {
+		t.Errorf("results = %+v", results)
+	}
+	if !strings.Contains(output.String(), "WARNING: Test alert not delivered to broken.") {
+		t.Errorf("output = %q", output.String())
+	}
+	// Every test alert is resolved at the end
+	for receiver, endsAt := range alertmanager.alerts {
+		if endsAt.After(time.Now()) {
+			t.Errorf("test alert of %s not resolved", receiver)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package engine
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type AccessControlMethod struct {
+	None        string
+	BasicAuth   string
+	OAuth2Proxy string
+}
+
+var AccessControlMethods = AccessControlMethod{
+	None:        "None",
+	BasicAuth:   "Ingress basic auth",
+	OAuth2Proxy: "OAuth2 Proxy (OIDC)",
+}
+
+// AccessControlConfig is the authentication put in front of every UI ingress.
+type AccessControlConfig struct {
+	Method string
+
+	Username string
+	Password string
+
+	OidcIssuerUrl    string
+	OidcClientId     string
+	OidcClientSecret string
+	OidcEmailDomains string
+}
+
+const (
+	// BasicAuthSecret is the secret holding the htpasswd of basic auth in every namespace with ingresses.
+	BasicAuthSecret = "om-kits-basic-auth"
+	// BasicAuthFile is the htpasswd file generated in the working directory of the executor.
+	BasicAuthFile        = "auth/htpasswd"
+	OAuth2ProxyNamespace = "oauth2-proxy"
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func (info *BasicInfo) validateAccessControl() error {
+	accessControl := &info.AccessControl
+	if accessControl.Method == "" || accessControl.Method == AccessControlMethods.None {
+		return nil
+	}
+	if !info.IngressExposed() {
+		return errors.New("Access control needs the UIs exposed by ingress.")
+	}
+
+	switch accessControl.Method {
+	case AccessControlMethods.BasicAuth:
+		if info.IngressController == IngressControllers.Other {
+			return errors.New("Basic auth isn't supported with the ingress controller of class " + info.IngressClass + ".")
+		}
+		if !usernamePattern.MatchString(accessControl.Username) {
+			return errors.New("Username is empty or contains characters other than letters, digits, '.', '_' and '-'.")
+		}
+		if len(accessControl.Password) < 8 {
+			return errors.New("Password is shorter than 8 characters.")
+		}
+	case AccessControlMethods.OAuth2Proxy:
+		if info.IngressController != IngressControllers.Nginx && info.IngressController != IngressControllers.HaproxyIngress {
+			return errors.New("OAuth2 Proxy needs an ingress-nginx or HAProxy Ingress controller.")
+		}
+		issuerUrl, err := url.ParseRequestURI(accessControl.OidcIssuerUrl)
+		if err != nil || issuerUrl.Scheme != "https" {
+			return errors.New("OIDC issuer URL is empty or isn't a https URL.")
+		}
+		if accessControl.OidcClientId == "" || accessControl.OidcClientSecret == "" {
+			return errors.New("OIDC client ID or client secret is empty.")
+		}
+	default:
+		return errors.New("Please select an access control method.")
+	}
+	return nil
+}
+
+// emailDomains returns the email domains allowed to sign in, every domain when none is set.
+func (accessControl *AccessControlConfig) emailDomains() []string {
+	var domains []string
+	for _, domain := range strings.Split(accessControl.OidcEmailDomains, ",") {
+		domain = strings.TrimSpace(domain)
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	if len(domains) == 0 {
+		domains = []string{"*"}
+	}
+	return domains
+}
+
+func (accessControl *AccessControlConfig) redact() {
+	accessControl.OidcIssuerUrl = redactUrl(accessControl.OidcIssuerUrl)
+	accessControl.Password = redact(accessControl.Password)
+	accessControl.OidcClientSecret = redact(accessControl.OidcClientSecret)
+}
+
+// authAnnotations returns the annotations protecting an ingress in namespace with the access control.
+func (info *BasicInfo) authAnnotations(namespace string) [][2]string {
+	switch info.AccessControl.Method {
+	case AccessControlMethods.BasicAuth:
+		switch info.IngressController {
+		case IngressControllers.Nginx:
+			return [][2]string{
+				{"nginx.ingress.kubernetes.io/auth-type", "basic"},
+				{"nginx.ingress.kubernetes.io/auth-secret", BasicAuthSecret},
+				{"nginx.ingress.kubernetes.io/auth-realm", "Authentication Required"},
+			}
+		case IngressControllers.HaproxyIngress:
+			return [][2]string{
+				{"haproxy-ingress.github.io/auth-secret", BasicAuthSecret},
+				{"haproxy-ingress.github.io/auth-realm", "Authentication Required"},
+			}
+		case IngressControllers.HaproxyTech:
+			return [][2]string{
+				{"haproxy.org/auth-type", "basic-auth"},
+				{"haproxy.org/auth-secret", namespace + "/" + BasicAuthSecret},
+				{"haproxy.org/auth-realm", "Authentication Required"},
+			}
+		}
+	case AccessControlMethods.OAuth2Proxy:
+		switch info.IngressController {
+		case IngressControllers.Nginx:
+			return [][2]string{
+				{"nginx.ingress.kubernetes.io/auth-url",
+					"http://oauth2-proxy." + OAuth2ProxyNamespace + ".svc.cluster.local/oauth2/auth"},
+				{"nginx.ingress.kubernetes.io/auth-signin", "$scheme://$host/oauth2/start?rd=$escaped_request_uri"},
+			}
+		case IngressControllers.HaproxyIngress:
+			// HAProxy Ingress finds OAuth2 Proxy at /oauth2 of the same host
+			return [][2]string{
+				{"haproxy-ingress.github.io/oauth", "oauth2_proxy"},
+			}
+		}
+	}
+	return nil
+}
+
+// accessControlEnvs returns the environment variables of packages/auth. tlsSecret is the TLS
+// secret of the ingresses, the ingress of OAuth2 Proxy gets its own one from cert-manager.
+func (info *BasicInfo) accessControlEnvs(tlsAcme bool, tlsSecret string) []string {
+	accessControl := info.AccessControl
+	var envs []string
+
+	switch accessControl.Method {
+	case AccessControlMethods.BasicAuth:
+		envs = append(envs, "IDO_ACCESS_CONTROL=basic-auth")
+	case AccessControlMethods.OAuth2Proxy:
+		envs = append(envs, "IDO_ACCESS_CONTROL=oauth2-proxy")
+	default:
+		envs = append(envs, "IDO_ACCESS_CONTROL=none")
+	}
+	envs = append(envs, "IDO_BASIC_AUTH_SECRET="+BasicAuthSecret)
+	envs = append(envs, "IDO_BASIC_AUTH_FILE="+BasicAuthFile)
+	envs = append(envs, "IDO_BASIC_AUTH_USERNAME="+accessControl.Username)
+
+	var domains []string
+	for _, domain := range accessControl.emailDomains() {
+		domains = append(domains, strconv.Quote(domain))
+	}
+	hostsString := ""
+	var cookieDomains []string
+	if info.Exposure == Exposures.IngressHost {
+		for _, hostname := range info.Hostnames() {
+			hostsString = hostsString + "    - " + hostname + "\n"
+		}
+		// Sign in once for every UI, with subdomain routing as well
+		cookieDomains = []string{strconv.Quote(info.Host)}
+	} else {
+		hostsString = "    - \"\"\n"
+	}
+	tlsString := "[]"
+	if info.HttpsEnabled {
+		secretName := tlsSecret
+		if tlsAcme {
+			secretName = "oauth2-proxy-tls"
+		}
+		tlsString = "\n    - secretName: " + secretName + "\n      hosts:\n" + strings.ReplaceAll(hostsString, "    - ", "        - ")
+	}
+	envs = append(envs, "IDO_OIDC_ISSUER_URL="+accessControl.OidcIssuerUrl)
+	envs = append(envs, "IDO_OIDC_CLIENT_ID="+accessControl.OidcClientId)
+	envs = append(envs, "IDO_OIDC_CLIENT_SECRET="+accessControl.OidcClientSecret)
+	envs = append(envs, "IDO_OIDC_EMAIL_DOMAINS="+strings.Join(domains, ", "))
+	envs = append(envs, "IDO_OAUTH2_PROXY_NAMESPACE="+OAuth2ProxyNamespace)
+	envs = append(envs, "IDO_OAUTH2_PROXY_COOKIE_SECURE="+strconv.FormatBool(info.HttpsEnabled))
+	envs = append(envs, "IDO_OAUTH2_PROXY_COOKIE_DOMAINS="+strings.Join(cookieDomains, ", "))
+	envs = append(envs, "IDO_OAUTH2_PROXY_HOSTS="+hostsString)
+	envs = append(envs, "IDO_OAUTH2_PROXY_TLS="+tlsString)
+	envs = append(envs, "IDO_OAUTH2_PROXY_INGRESS_ANNOTATIONS="+info.ingressAnnotations(OAuth2ProxyNamespace, tlsAcme, false))
+	return envs
+}
+
+// accessControlTasks returns the tasks creating the secrets and services of the access control.
+func (info *BasicInfo) accessControlTasks() []Task {
+	accessControl := info.AccessControl
+	var tasks []Task
+	if accessControl.Method == AccessControlMethods.BasicAuth {
+		tasks = append(tasks, Task{Name: "Generate Basic Auth Password",
+			Func: func(ctx context.Context, dir string, output io.Writer) error {
+				return WriteHtpasswd(filepath.Join(dir, BasicAuthFile), accessControl.Username, accessControl.Password, output)
+			}})
+	}
+	tasks = append(tasks, Task{Name: "Configure Access Control",
+		Command: "chmod +x packages/auth/install.sh; packages/auth/install.sh"})
+	return tasks
+}
+
+// WriteHtpasswd writes the htpasswd file of username with the MD5-crypt hash of password, the
+// scheme understood by crypt(3) of ingress-nginx and HAProxy as well as by Traefik.
+func WriteHtpasswd(file string, username string, password string, output io.Writer) error {
+	salt := make([]byte, 8)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	for i := range salt {
+		salt[i] = cryptAlphabet[int(salt[i])%len(cryptAlphabet)]
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(file, []byte(username+":"+md5Crypt([]byte(password), salt)+"\n"), 0600)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(output, "Basic auth password of "+username+" written to "+file)
+	return nil
+}
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// md5Crypt returns the "$1$" hash of password as computed by crypt(3).
+func md5Crypt(password []byte, salt []byte) string {
+	magic := []byte("$1$")
+
+	alternate := md5.New()
+	alternate.Write(password)
+	alternate.Write(salt)
+	alternate.Write(password)
+	alternateSum := alternate.Sum(nil)
+
+	digest := md5.New()
+	digest.Write(password)
+	digest.Write(magic)
+	digest.Write(salt)
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			digest.Write(alternateSum)
+		} else {
+			digest.Write(alternateSum[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write(password[:1])
+		}
+	}
+	sum := digest.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 == 1 {
+			round.Write(password)
+		} else {
+			round.Write(sum)
+		}
+		if i%3 != 0 {
+			round.Write(salt)
+		}
+		if i%7 != 0 {
+			round.Write(password)
+		}
+		if i&1 == 1 {
+			round.Write(sum)
+		} else {
+			round.Write(password)
+		}
+		sum = round.Sum(nil)
+	}
+
+	hash := append([]byte{}, magic...)
+	hash = append(hash, salt...)
+	hash = append(hash, '$')
+	encode := func(a byte, b byte, c byte, n int) {
+		value := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			hash = append(hash, cryptAlphabet[value&0x3f])
+			value >>= 6
+		}
+	}
+	encode(sum[0], sum[6], sum[12], 4)
+	encode(sum[1], sum[7], sum[13], 4)
+	encode(sum[2], sum[8], sum[14], 4)
+	encode(sum[3], sum[9], sum[15], 4)
+	encode(sum[4], sum[10], sum[5], 4)
+	encode(0, 0, sum[11], 2)
+	return string(hash)
+}
@@ -0,0 +1,266 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const oauth2ProxyValues = "packages/auth/values-oauth2-proxy-override.yaml"
+
+func TestValidateAccessControl(t *testing.T) {
+	basicAuth := AccessControlConfig{Method: AccessControlMethods.BasicAuth, Username: "admin", Password: "p@ssw0rd!"}
+	oauth2Proxy := AccessControlConfig{Method: AccessControlMethods.OAuth2Proxy,
+		OidcIssuerUrl: "https://sso.example.com/realms/ops", OidcClientId: "om-kits", OidcClientSecret: "secret"}
+	tests := []struct {
+		name          string
+		controller    string
+		exposure      string
+		accessControl AccessControlConfig
+		err           string
+	}{
+		{"none", IngressControllers.Other, Exposures.NodePort, AccessControlConfig{Method: AccessControlMethods.None}, ""},
+		{"basic auth", IngressControllers.Traefik, Exposures.IngressHost, basicAuth, ""},
+		{"basic auth without an ingress", IngressControllers.Nginx, Exposures.NodePort, basicAuth,
+			"Access control needs the UIs exposed by ingress."},
+		{"basic auth with another controller", IngressControllers.Other, Exposures.IngressHost, basicAuth,
+			"Basic auth isn't supported with the ingress controller of class nginx."},
+		{"basic auth with a short password", IngressControllers.Nginx, Exposures.IngressHost,
+			AccessControlConfig{Method: AccessControlMethods.BasicAuth, Username: "admin", Password: "short"},
+			"Password is shorter than 8 characters."},
+		{"basic auth with a user name with a colon", IngressControllers.Nginx, Exposures.IngressHost,
+			AccessControlConfig{Method: AccessControlMethods.BasicAuth, Username: "ad:min", Password: "p@ssw0rd!"},
+			"Username is empty or contains characters other than letters, digits, '.', '_' and '-'."},
+		{"oauth2 proxy", IngressControllers.HaproxyIngress, Exposures.IngressPath, oauth2Proxy, ""},
+		{"oauth2 proxy with traefik", IngressControllers.Traefik, Exposures.IngressHost, oauth2Proxy,
+			"OAuth2 Proxy needs an ingress-nginx or HAProxy Ingress controller."},
+		{"oauth2 proxy with a http issuer", IngressControllers.Nginx, Exposures.IngressHost,
+			AccessControlConfig{Method: AccessControlMethods.OAuth2Proxy, OidcIssuerUrl: "http://sso.example.com",
+				OidcClientId: "om-kits", OidcClientSecret: "secret"},
+			"OIDC issuer URL is empty or isn't a https URL."},
+		{"oauth2 proxy without a client secret", IngressControllers.Nginx, Exposures.IngressHost,
+			AccessControlConfig{Method: AccessControlMethods.OAuth2Proxy, OidcIssuerUrl: oauth2Proxy.OidcIssuerUrl,
+				OidcClientId: "om-kits"},
+			"OIDC client ID or client secret is empty."},
+	}
+	for _, test := range tests {
+		info := BasicInfo{Host: "cluster.example.com", IngressClass: "nginx", IngressController: test.controller,
+			Exposure: test.exposure, AccessControl: test.accessControl}
+		err := info.validateAccessControl()
+		if err == nil && test.err != "" || err != nil && err.Error() != test.err {
+			t.Errorf("%s: validateAccessControl() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestAccessControlAnnotations(t *testing.T) {
+	tests := []struct {
+		controller string
+		method     string
+		want       []string
+		unwanted   []string
+	}{
+		{IngressControllers.Nginx, AccessControlMethods.None, nil,
+			[]string{"auth-type", "auth-url"}},
+		{IngressControllers.Nginx, AccessControlMethods.BasicAuth,
+			[]string{`nginx.ingress.kubernetes.io/auth-type: "basic"`,
+				`nginx.ingress.kubernetes.io/auth-secret: "` + BasicAuthSecret + `"`}, nil},
+		{IngressControllers.Traefik, AccessControlMethods.BasicAuth,
+			[]string{`monitoring-om-kits-body-size@kubernetescrd,monitoring-` + BasicAuthSecret + `@kubernetescrd"`}, nil},
+		{IngressControllers.HaproxyIngress, AccessControlMethods.BasicAuth,
+			[]string{`haproxy-ingress.github.io/auth-secret: "` + BasicAuthSecret + `"`}, nil},
+		{IngressControllers.HaproxyTech, AccessControlMethods.BasicAuth,
+			[]string{`haproxy.org/auth-type: "basic-auth"`, `haproxy.org/auth-secret: "monitoring/` + BasicAuthSecret + `"`}, nil},
+		{IngressControllers.Nginx, AccessControlMethods.OAuth2Proxy,
+			[]string{`nginx.ingress.kubernetes.io/auth-url: "http://oauth2-proxy.` + OAuth2ProxyNamespace +
+				`.svc.cluster.local/oauth2/auth"`,
+				`nginx.ingress.kubernetes.io/auth-signin: "$scheme://$host/oauth2/start?rd=$escaped_request_uri"`}, nil},
+		{IngressControllers.HaproxyIngress, AccessControlMethods.OAuth2Proxy,
+			[]string{`haproxy-ingress.github.io/oauth: "oauth2_proxy"`}, nil},
+	}
+	for _, test := range tests {
+		info := NewConfig().BasicInfo
+		info.IngressController = test.controller
+		info.AccessControl.Method = test.method
+		protected := info.ingressAnnotations("monitoring", false, true)
+		for _, want := range test.want {
+			if !strings.Contains(protected, want) {
+				t.Errorf("%s, %s: annotations lack %s:\n%s", test.controller, test.method, want, protected)
+			}
+		}
+		for _, unwanted := range test.unwanted {
+			if strings.Contains(protected, unwanted) {
+				t.Errorf("%s, %s: annotations contain %s:\n%s", test.controller, test.method, unwanted, protected)
+			}
+		}
+		// The ingress of OAuth2 Proxy itself isn't protected
+		unprotected := info.ingressAnnotations(OAuth2ProxyNamespace, false, false)
+		info.AccessControl.Method = AccessControlMethods.None
+		if want := info.ingressAnnotations(OAuth2ProxyNamespace, false, false); unprotected != want {
+			t.Errorf("%s, %s: unprotected annotations =\n%s\nwant\n%s", test.controller, test.method, unprotected, want)
+		}
+	}
+}
+
+func TestAccessControlPlan(t *testing.T) {
+	oauth2Proxy := AccessControlConfig{Method: AccessControlMethods.OAuth2Proxy,
+		OidcIssuerUrl: "https://sso.example.com/realms/ops", OidcClientId: "om-kits", OidcClientSecret: "secret",
+		OidcEmailDomains: "example.com, example.org"}
+	tests := []struct {
+		name      string
+		configure func(info *BasicInfo)
+		tasks     []string
+		envs      map[string]string
+		values    map[string]interface{}
+	}{
+		{
+			name: "basic auth",
+			configure: func(info *BasicInfo) {
+				info.AccessControl = AccessControlConfig{Method: AccessControlMethods.BasicAuth, Username: "admin",
+					Password: "p@ssw0rd!"}
+			},
+			tasks: []string{"Generate Basic Auth Password", "Configure Access Control"},
+			envs: map[string]string{
+				"IDO_ACCESS_CONTROL":      "basic-auth",
+				"IDO_BASIC_AUTH_SECRET":   BasicAuthSecret,
+				"IDO_BASIC_AUTH_FILE":     BasicAuthFile,
+				"IDO_BASIC_AUTH_USERNAME": "admin",
+			},
+		},
+		{
+			name: "oauth2 proxy over https with subdomain routing",
+			configure: func(info *BasicInfo) {
+				info.HttpsEnabled = true
+				info.TlsCert.CertMethod = CertMethods.CertManager
+				info.SubdomainRouting = true
+				info.AccessControl = oauth2Proxy
+			},
+			tasks: []string{"Configure Access Control"},
+			envs: map[string]string{
+				"IDO_ACCESS_CONTROL":              "oauth2-proxy",
+				"IDO_OIDC_EMAIL_DOMAINS":          `"example.com", "example.org"`,
+				"IDO_OAUTH2_PROXY_COOKIE_SECURE":  "true",
+				"IDO_OAUTH2_PROXY_COOKIE_DOMAINS": `"cluster.example.com"`,
+			},
+			values: map[string]interface{}{
+				"ingress.hosts": []interface{}{"cluster.example.com", "grafana.cluster.example.com",
+					"kibana.cluster.example.com"},
+				"ingress.tls": []interface{}{map[string]interface{}{
+					"secretName": "oauth2-proxy-tls",
+					"hosts": []interface{}{"cluster.example.com", "grafana.cluster.example.com",
+						"kibana.cluster.example.com"},
+				}},
+				"ingress.annotations": map[string]interface{}{
+					"kubernetes.io/tls-acme":                         "true",
+					"nginx.ingress.kubernetes.io/force-ssl-redirect": "false",
+					"nginx.ingress.kubernetes.io/proxy-body-size":    "50m",
+				},
+			},
+		},
+		{
+			name: "oauth2 proxy by path",
+			configure: func(info *BasicInfo) {
+				info.Host = "192.168.1.10"
+				info.Exposure = Exposures.IngressPath
+				info.AccessControl = oauth2Proxy
+				info.AccessControl.OidcEmailDomains = ""
+			},
+			tasks: []string{"Configure Access Control"},
+			envs: map[string]string{
+				"IDO_OIDC_EMAIL_DOMAINS":          `"*"`,
+				"IDO_OAUTH2_PROXY_COOKIE_SECURE":  "false",
+				"IDO_OAUTH2_PROXY_COOKIE_DOMAINS": "",
+			},
+			values: map[string]interface{}{
+				"ingress.hosts": []interface{}{""},
+				"ingress.tls":   []interface{}{},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			config.BasicInfo.Host = "cluster.example.com"
+			test.configure(&config.BasicInfo)
+			config.InstallPrometheus = true
+			plan := NewPlan(config)
+
+			var tasks []string
+			for _, name := range taskNames(plan) {
+				if strings.Contains(name, "Auth") || strings.Contains(name, "Access") {
+					tasks = append(tasks, name)
+				}
+			}
+			if !reflect.DeepEqual(tasks, test.tasks) {
+				t.Errorf("tasks = %q, want %q", tasks, test.tasks)
+			}
+			for key, want := range test.envs {
+				if value, _ := envValue(plan, key); value != want {
+					t.Errorf("%s = %q, want %q", key, value, want)
+				}
+			}
+
+			// Grafana is protected with the method
+			annotations := valueAt(renderValues(t, plan, prometheusValues), "grafana.ingress.annotations")
+			_, hasAuth := annotations.(map[string]interface{})["nginx.ingress.kubernetes.io/auth-url"]
+			if hasAuth != (config.BasicInfo.AccessControl.Method == AccessControlMethods.OAuth2Proxy) {
+				t.Errorf("grafana.ingress.annotations = %v", annotations)
+			}
+
+			if test.values == nil {
+				return
+			}
+			values := renderValues(t, plan, oauth2ProxyValues)
+			for path, want := range test.values {
+				if value := valueAt(values, path); !reflect.DeepEqual(value, want) {
+					t.Errorf("%s = %#v, want %#v", path, value, want)
+				}
+			}
+			configFile, _ := valueAt(values, "config.configFile").(string)
+			for _, want := range []string{`oidc_issuer_url = "https://sso.example.com/realms/ops"`,
+				"email_domains = [ " + test.envs["IDO_OIDC_EMAIL_DOMAINS"] + " ]",
+				"cookie_domains = [ " + test.envs["IDO_OAUTH2_PROXY_COOKIE_DOMAINS"] + " ]"} {
+				if !strings.Contains(configFile, want) {
+					t.Errorf("config.configFile lacks %s:\n%s", want, configFile)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteHtpasswd(t *testing.T) {
+	// The hashes of openssl passwd -1 -salt
+	tests := []struct {
+		password string
+		salt     string
+		want     string
+	}{
+		{"p@ssw0rd!", "saltsalt", "$1$saltsalt$WMHPTb3Xn9Q3A.RF9uemO1"},
+		{"a-much-longer-password-than-sixteen-bytes", "abcdefgh", "$1$abcdefgh$dnO4DuclyUIZDJ11xsA7O."},
+	}
+	for _, test := range tests {
+		if hash := md5Crypt([]byte(test.password), []byte(test.salt)); hash != test.want {
+			t.Errorf("md5Crypt(%q, %q) = %s, want %s", test.password, test.salt, hash, test.want)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), BasicAuthFile)
+	var output strings.Builder
+	if err := WriteHtpasswd(file, "admin", "p@ssw0rd!", &output); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	username, hash, _ := strings.Cut(strings.TrimSuffix(string(content), "\n"), ":")
+	salt := strings.Split(hash, "$")
+	if username != "admin" || len(salt) != 4 || md5Crypt([]byte("p@ssw0rd!"), []byte(salt[2])) != hash {
+		t.Errorf("htpasswd = %q", content)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("htpasswd mode = %v, %v", info.Mode(), err)
+	}
+}
@@ -0,0 +1,543 @@
+package engine
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ImagesDir is the directory next to the installer with the images of the offline bundle,
+	// one OCI archive per image.
+	ImagesDir = "images"
+	// ImageChecksumsFile lists the archives of ImagesDir with their SHA-256, in the format of
+	// sha256sum.
+	ImageChecksumsFile = "SHA256SUMS"
+)
+
+// The annotations of index.json naming the image of an archive, as containerd and skopeo do.
+const (
+	imageNameAnnotation = "io.containerd.image.name"
+	refNameAnnotation   = "org.opencontainers.image.ref.name"
+)
+
+// descriptor is an OCI content descriptor.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// imageManifest holds the fields of image manifests and image indexes the bundle needs.
+type imageManifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    descriptor   `json:"config"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+// ArchiveName returns the file name of the archive of image, like
+// docker.io_library_busybox_1.31.1.tar.
+func ArchiveName(image string) string {
+	return strings.NewReplacer("/", "_", ":", "_").Replace(image) + ".tar"
+}
+
+// DefaultPlatform is the platform of the images saved when none is given, the one the installer
+// runs on.
+func DefaultPlatform() string {
+	return "linux/" + runtime.GOARCH
+}
+
+// SaveImage downloads image for platform, like linux/amd64, and writes it to dir as an OCI
+// archive. The manifest and every blob are checked against their digest. It returns the name
+// of the archive and its SHA-256.
+func SaveImage(ctx context.Context, image string, platform string, dir string) (string, string, error) {
+	registry, repository, tag := splitImage(image)
+	client := newRegistryClient(registry)
+	err := client.ping(ctx)
+	if err != nil {
+		return "", "", errors.New("Registry " + registry + " isn't available: " + err.Error())
+	}
+
+	content, mediaType, digest, err := client.fetchManifest(ctx, repository, tag)
+	if err != nil {
+		return "", "", err
+	}
+	var manifest imageManifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return "", "", errors.New("Can't parse the manifest of " + image + ": " + err.Error())
+	}
+	if len(manifest.Manifests) > 0 {
+		selected, err := selectPlatform(manifest.Manifests, platform)
+		if err != nil {
+			return "", "", errors.New(image + ": " + err.Error())
+		}
+		content, mediaType, digest, err = client.fetchManifest(ctx, repository, selected.Digest)
+		if err != nil {
+			return "", "", err
+		}
+		manifest = imageManifest{}
+		err = json.Unmarshal(content, &manifest)
+		if err != nil {
+			return "", "", errors.New("Can't parse the manifest of " + image + ": " + err.Error())
+		}
+	}
+	if manifest.Config.Digest == "" {
+		return "", "", errors.New("Unsupported manifest " + mediaType + " of " + image + ".")
+	}
+
+	name := ArchiveName(image)
+	file, err := os.CreateTemp(dir, name+".*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	checksum := sha256.New()
+	buffer := bufio.NewWriter(io.MultiWriter(file, checksum))
+	archive := tar.NewWriter(buffer)
+	index := map[string]interface{}{
+		"schemaVersion": 2,
+		"manifests": []descriptor{{MediaType: mediaType, Digest: digest, Size: int64(len(content)),
+			Annotations: map[string]string{imageNameAnnotation: image, refNameAnnotation: tag}}},
+	}
+	indexContent, err := json.Marshal(index)
+	if err != nil {
+		return "", "", err
+	}
+	err = writeTarFile(archive, "oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`))
+	if err == nil {
+		err = writeTarFile(archive, "index.json", indexContent)
+	}
+	if err == nil {
+		err = writeTarFile(archive, blobPath(digest), content)
+	}
+	if err != nil {
+		return "", "", err
+	}
+	for _, blob := range append([]descriptor{manifest.Config}, manifest.Layers...) {
+		err = client.saveBlob(ctx, repository, blob, archive)
+		if err != nil {
+			return "", "", errors.New("Can't download " + blob.Digest + " of " + image + ": " + err.Error())
+		}
+	}
+	err = archive.Close()
+	if err == nil {
+		err = buffer.Flush()
+	}
+	if err == nil {
+		err = file.Close()
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.Join(dir, name))
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return name, hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+// selectPlatform returns the manifest of platform, like linux/arm64/v8, from the manifests of
+// an image index.
+func selectPlatform(manifests []descriptor, platform string) (descriptor, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return descriptor{}, errors.New("Platform " + platform + " isn't like linux/amd64.")
+	}
+	var available []string
+	for _, manifest := range manifests {
+		if manifest.Platform == nil {
+			continue
+		}
+		p := manifest.Platform
+		if p.OS == parts[0] && p.Architecture == parts[1] && (len(parts) == 2 || p.Variant == parts[2]) {
+			return manifest, nil
+		}
+		available = append(available, strings.TrimSuffix(p.OS+"/"+p.Architecture+"/"+p.Variant, "/"))
+	}
+	return descriptor{}, errors.New("No image for " + platform + ", the image is for " +
+		strings.Join(available, ", ") + ".")
+}
+
+// fetchManifest downloads the manifest of reference, a tag or a digest, and checks it against
+// the digest the registry sends, or the one of reference.
+func (client *registryClient) fetchManifest(ctx context.Context, repository string,
+	reference string) (content []byte, mediaType string, digest string, err error) {
+	headers := map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")}
+	response, err := client.request(ctx, http.MethodGet, "/v2/"+repository+"/manifests/"+reference, repository,
+		"pull", headers, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, "", "", errors.New("Manifest " + repository + ":" + reference + " returned " + response.Status + ".")
+	}
+	content, err = io.ReadAll(response.Body)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	digest = "sha256:" + sha256Hex(content)
+	expected := response.Header.Get("Docker-Content-Digest")
+	if strings.HasPrefix(reference, "sha256:") {
+		expected = reference
+	}
+	if expected != "" && expected != digest {
+		return nil, "", "", errors.New("Manifest " + repository + ":" + reference + " doesn't match its digest " +
+			expected + ".")
+	}
+
+	mediaType = response.Header.Get("Content-Type")
+	var typed struct {
+		MediaType string `json:"mediaType"`
+	}
+	if json.Unmarshal(content, &typed) == nil && typed.MediaType != "" {
+		mediaType = typed.MediaType
+	}
+	return content, mediaType, digest, nil
+}
+
+// saveBlob downloads blob into archive and checks its size and digest.
+func (client *registryClient) saveBlob(ctx context.Context, repository string, blob descriptor,
+	archive *tar.Writer) error {
+	response, err := client.request(ctx, http.MethodGet, "/v2/"+repository+"/blobs/"+blob.Digest, repository,
+		"pull", nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return errors.New("Blob returned " + response.Status + ".")
+	}
+
+	err = archive.WriteHeader(&tar.Header{Name: blobPath(blob.Digest), Mode: 0644, Size: blob.Size})
+	if err != nil {
+		return err
+	}
+	verifier := newDigestVerifier(blob.Digest)
+	written, err := io.Copy(archive, io.TeeReader(io.LimitReader(response.Body, blob.Size+1), verifier))
+	if err != nil {
+		return err
+	}
+	if written != blob.Size {
+		return fmt.Errorf("Blob has %d bytes instead of %d.", written, blob.Size)
+	}
+	return verifier.verify()
+}
+
+// WriteImageChecksums writes the SHA-256 of the archives, by their name, to the checksums file
+// of dir.
+func WriteImageChecksums(dir string, checksums map[string]string) error {
+	var names []string
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var content strings.Builder
+	for _, name := range names {
+		content.WriteString(checksums[name] + "  " + name + "\n")
+	}
+	return os.WriteFile(filepath.Join(dir, ImageChecksumsFile), []byte(content.String()), 0644)
+}
+
+// ReadImageChecksums returns the SHA-256 of the archives listed in the checksums file of dir.
+func ReadImageChecksums(dir string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(dir, ImageChecksumsFile))
+	if err != nil {
+		return nil, err
+	}
+	checksums := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.New("Can't parse " + ImageChecksumsFile + ": " + line)
+		}
+		checksums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+	return checksums, nil
+}
+
+// PushImage pushes the archive saved by SaveImage to target, a registry host with an optional
+// path like harbor.example.com/offline, and returns the image it was pushed to. The image keeps
+// the path of its source registry, e.g. docker.io/library/busybox:1.31.1 is pushed to
+// harbor.example.com/offline/docker.io/library/busybox:1.31.1, so that target/<source registry>
+// mirrors each source registry. The archive is checked against checksum first, every blob
+// against its digest, and the pushed manifest against the digest the registry returns.
+func PushImage(ctx context.Context, archive string, checksum string, target string, username string,
+	password string) (string, error) {
+	actual, err := fileSha256(archive)
+	if err != nil {
+		return "", err
+	}
+	if actual != checksum {
+		return "", errors.New(filepath.Base(archive) + " doesn't match its checksum, save the image again.")
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	reader := tar.NewReader(file)
+
+	host, prefix, _ := strings.Cut(target, "/")
+	client := newRegistryClient(host)
+	if username != "" {
+		client.username, client.password = username, password
+	}
+	err = client.ping(ctx)
+	if err != nil {
+		return "", errors.New("Registry " + host + " isn't available: " + err.Error())
+	}
+
+	var manifest *descriptor
+	var manifestContent []byte
+	var image, repository, tag string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", errors.New("Can't read " + archive + ": " + err.Error())
+		}
+
+		switch {
+		case header.Name == "index.json":
+			var index imageManifest
+			err = json.NewDecoder(reader).Decode(&index)
+			if err != nil || len(index.Manifests) != 1 || index.Manifests[0].Annotations[imageNameAnnotation] == "" {
+				return "", errors.New(archive + " isn't an image saved by the installer.")
+			}
+			manifest = &index.Manifests[0]
+			image = manifest.Annotations[imageNameAnnotation]
+			var registry string
+			registry, repository, tag = splitImage(image)
+			repository = registry + "/" + repository
+			if prefix != "" {
+				repository = prefix + "/" + repository
+			}
+		case manifest != nil && header.Name == blobPath(manifest.Digest):
+			manifestContent, err = io.ReadAll(reader)
+			if err != nil {
+				return "", err
+			}
+			if "sha256:"+sha256Hex(manifestContent) != manifest.Digest {
+				return "", errors.New("The manifest of " + image + " doesn't match its digest.")
+			}
+		case manifest != nil && strings.HasPrefix(header.Name, "blobs/sha256/"):
+			digest := "sha256:" + strings.TrimPrefix(header.Name, "blobs/sha256/")
+			err = client.pushBlob(ctx, repository, digest, header.Size, reader)
+			if err != nil {
+				return "", errors.New("Can't push " + digest + " of " + image + ": " + err.Error())
+			}
+		}
+	}
+	if manifestContent == nil {
+		return "", errors.New(archive + " has no manifest.")
+	}
+
+	headers := map[string]string{"Content-Type": manifest.MediaType}
+	response, err := client.request(ctx, http.MethodPut, "/v2/"+repository+"/manifests/"+tag, repository,
+		"pull,push", headers, bytes.NewReader(manifestContent))
+	if err != nil {
+		return "", err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		return "", errors.New("Pushing the manifest of " + image + " returned " + response.Status + ".")
+	}
+	pushed := host + "/" + repository + ":" + tag
+	if digest := response.Header.Get("Docker-Content-Digest"); digest != "" && digest != manifest.Digest {
+		return "", errors.New(pushed + " got the digest " + digest + " instead of " + manifest.Digest + ".")
+	}
+	return pushed, nil
+}
+
+// pushBlob uploads the blob read from content unless repository has it already. The blob is
+// checked against digest while it's uploaded, and by the registry when the upload completes.
+func (client *registryClient) pushBlob(ctx context.Context, repository string, digest string, size int64,
+	content io.Reader) error {
+	verifier := newDigestVerifier(digest)
+	response, err := client.request(ctx, http.MethodHead, "/v2/"+repository+"/blobs/"+digest, repository,
+		"pull,push", nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode == http.StatusOK {
+		_, err = io.Copy(verifier, content)
+		if err != nil {
+			return err
+		}
+		return verifier.verify()
+	}
+
+	response, err = client.request(ctx, http.MethodPost, "/v2/"+repository+"/blobs/uploads/", repository,
+		"pull,push", nil, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	location := response.Header.Get("Location")
+	if response.StatusCode != http.StatusAccepted || location == "" {
+		return errors.New("Starting the upload returned " + response.Status + ".")
+	}
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	headers := map[string]string{
+		"Content-Type":   "application/octet-stream",
+		"Content-Length": strconv.FormatInt(size, 10),
+	}
+	// The verifier sees the whole blob before the request ends
+	body := io.TeeReader(io.LimitReader(content, size), verifier)
+	response, err = client.request(ctx, http.MethodPut, location+separator+"digest="+digest, repository,
+		"pull,push", headers, body)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if err := verifier.verify(); err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusCreated {
+		return errors.New("Upload returned " + response.Status + ".")
+	}
+	return nil
+}
+
+// OfflinePresetName is the name of the preset mirroring the source registries to target.
+func OfflinePresetName(target string) string {
+	return "Offline " + target
+}
+
+// SaveOfflinePreset adds the preset mirroring each of sources to target/<source> to the presets
+// file, replacing the preset of the same name, so that the installation can pick it on the
+// Mirror page.
+func SaveOfflinePreset(file string, target string, sources []string) error {
+	type entry struct {
+		Name    string            `json:"name"`
+		Mirrors map[string]string `json:"mirrors"`
+	}
+	var entries []entry
+	content, err := os.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(content, &entries)
+		if err != nil {
+			return errors.New("Can't parse " + file + ": " + err.Error())
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	preset := entry{Name: OfflinePresetName(target), Mirrors: map[string]string{}}
+	for _, source := range sources {
+		preset.Mirrors[source] = target + "/" + source
+	}
+	replaced := false
+	for i := range entries {
+		if entries[i].Name == preset.Name {
+			entries[i] = preset
+			replaced = true
+		}
+	}
+	if !replaced {
+		entries = append(entries, preset)
+	}
+	content, err = json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(content, '\n'), 0644)
+}
+
+// ImageRegistry returns the registry of image.
+func ImageRegistry(image string) string {
+	registry, _, _ := splitImage(image)
+	return registry
+}
+
+func blobPath(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+func writeTarFile(archive *tar.Writer, name string, content []byte) error {
+	err := archive.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+	if err != nil {
+		return err
+	}
+	_, err = archive.Write(content)
+	return err
+}
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func fileSha256(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	checksum := sha256.New()
+	_, err = io.Copy(checksum, file)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+// digestVerifier checks the content written to it against a sha256 digest.
+type digestVerifier struct {
+	digest string
+	hash   hash.Hash
+}
+
+func newDigestVerifier(digest string) *digestVerifier {
+	return &digestVerifier{digest: digest, hash: sha256.New()}
+}
+
+func (verifier *digestVerifier) Write(p []byte) (int, error) {
+	return verifier.hash.Write(p)
+}
+
+func (verifier *digestVerifier) verify() error {
+	if !strings.HasPrefix(verifier.digest, "sha256:") {
+		return errors.New("Unsupported digest " + verifier.digest + ".")
+	}
+	if "sha256:"+hex.EncodeToString(verifier.hash.Sum(nil)) != verifier.digest {
+		return errors.New("Content doesn't match the digest " + verifier.digest + ".")
+	}
+	return nil
+}
@@ -0,0 +1,409 @@
+package engine
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testBundleRegistry is a registry v2 stand-in keeping the manifests and blobs pushed to it in
+// memory. It asks for the basic credentials robot/secret, like a registry:2 with htpasswd.
+type testBundleRegistry struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	manifests map[string][]byte
+	types     map[string]string
+	blobs     map[string][]byte
+	uploads   int
+	// corrupt is the digest of a blob served with other content
+	corrupt string
+}
+
+func newTestBundleRegistry(t *testing.T) *testBundleRegistry {
+	registry := &testBundleRegistry{manifests: map[string][]byte{}, types: map[string]string{},
+		blobs: map[string][]byte{}}
+	registry.Server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		registry.mutex.Lock()
+		defer registry.mutex.Unlock()
+		if username, password, _ := request.BasicAuth(); username != "robot" || password != "secret" {
+			writer.Header().Set("WWW-Authenticate", `Basic realm="test-registry"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if request.URL.Path == "/v2/" {
+			return
+		}
+
+		path := strings.TrimPrefix(request.URL.Path, "/v2/")
+		if repository, reference, ok := strings.Cut(path, "/manifests/"); ok {
+			key := repository + "@" + reference
+			if request.Method == http.MethodPut {
+				content, _ := io.ReadAll(request.Body)
+				digest := "sha256:" + sha256Hex(content)
+				registry.putManifest(repository, reference, request.Header.Get("Content-Type"), content)
+				writer.Header().Set("Docker-Content-Digest", digest)
+				writer.WriteHeader(http.StatusCreated)
+				return
+			}
+			content, found := registry.manifests[key]
+			if !found {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writer.Header().Set("Content-Type", registry.types[key])
+			writer.Header().Set("Docker-Content-Digest", "sha256:"+sha256Hex(content))
+			writer.Write(content)
+			return
+		}
+		if repository, digest, ok := strings.Cut(path, "/blobs/"); ok && !strings.HasPrefix(digest, "uploads/") {
+			content, found := registry.blobs[repository+"@"+digest]
+			if !found {
+				writer.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if digest == registry.corrupt {
+				content = []byte(strings.Repeat("x", len(content)))
+			}
+			writer.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			if request.Method == http.MethodGet {
+				writer.Write(content)
+			}
+			return
+		}
+		if repository, upload, ok := strings.Cut(path, "/blobs/uploads/"); ok {
+			if request.Method == http.MethodPost {
+				writer.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/1?state=started")
+				writer.WriteHeader(http.StatusAccepted)
+				return
+			}
+			content, _ := io.ReadAll(request.Body)
+			digest := request.URL.Query().Get("digest")
+			if upload != "1" || request.URL.Query().Get("state") != "started" || "sha256:"+sha256Hex(content) != digest {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			registry.blobs[repository+"@"+digest] = content
+			registry.uploads++
+			writer.WriteHeader(http.StatusCreated)
+			return
+		}
+		t.Errorf("unexpected %s %s", request.Method, request.URL)
+		writer.WriteHeader(http.StatusNotFound)
+	}))
+	return registry
+}
+
+// host returns the host of the registry, like 127.0.0.1:41234.
+func (registry *testBundleRegistry) host() string {
+	return strings.TrimPrefix(registry.URL, "http://")
+}
+
+func (registry *testBundleRegistry) putManifest(repository string, reference string, mediaType string,
+	content []byte) string {
+	digest := "sha256:" + sha256Hex(content)
+	for _, key := range []string{repository + "@" + reference, repository + "@" + digest} {
+		registry.manifests[key] = content
+		registry.types[key] = mediaType
+	}
+	return digest
+}
+
+func (registry *testBundleRegistry) putBlob(repository string, content string) descriptor {
+	digest := "sha256:" + sha256Hex([]byte(content))
+	registry.blobs[repository+"@"+digest] = []byte(content)
+	return descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digest,
+		Size: int64(len(content))}
+}
+
+// putImage adds library/busybox:1.31.1 for linux/amd64 and linux/arm64, and returns the
+// manifest of linux/arm64.
+func (registry *testBundleRegistry) putImage(t *testing.T) []byte {
+	var arm64Manifest []byte
+	index := imageManifest{MediaType: "application/vnd.oci.image.index.v1+json"}
+	for _, arch := range []string{"amd64", "arm64"} {
+		config := registry.putBlob("library/busybox", `{"architecture":"`+arch+`","os":"linux"}`)
+		config.MediaType = "application/vnd.oci.image.config.v1+json"
+		manifest := imageManifest{MediaType: "application/vnd.oci.image.manifest.v1+json", Config: config,
+			Layers: []descriptor{registry.putBlob("library/busybox", "layer of "+arch)}}
+		content, err := json.Marshal(manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digest := registry.putManifest("library/busybox", "sha256:"+sha256Hex(content), manifest.MediaType, content)
+		entry := descriptor{MediaType: manifest.MediaType, Digest: digest, Size: int64(len(content))}
+		entry.Platform = &struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant,omitempty"`
+		}{OS: "linux", Architecture: arch}
+		index.Manifests = append(index.Manifests, entry)
+		if arch == "arm64" {
+			arm64Manifest = content
+		}
+	}
+	content, err := json.Marshal(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.putManifest("library/busybox", "1.31.1", index.MediaType, content)
+	return arm64Manifest
+}
+
+// setDockerLogin makes docker login hold the credentials of host.
+func setDockerLogin(t *testing.T, host string) {
+	dir := t.TempDir()
+	content := `{"auths": {"` + host + `": {"auth": "` + basicCredentials("robot", "secret") + `"}}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DOCKER_CONFIG", dir)
+}
+
+// readArchive returns the files of the archive by their name.
+func readArchive(t *testing.T, archive string) map[string][]byte {
+	file, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	files := map[string][]byte{}
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[header.Name], err = io.ReadAll(reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSaveImage(t *testing.T) {
+	registry := newTestBundleRegistry(t)
+	defer registry.Close()
+	manifest := registry.putImage(t)
+	setDockerLogin(t, registry.host())
+	image := registry.host() + "/library/busybox:1.31.1"
+
+	dir := t.TempDir()
+	name, checksum, err := SaveImage(context.Background(), image, "linux/arm64", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != ArchiveName(image) {
+		t.Errorf("name = %s, want %s", name, ArchiveName(image))
+	}
+	if actual, err := fileSha256(filepath.Join(dir, name)); err != nil || actual != checksum {
+		t.Errorf("checksum = %s, the archive has %s, %v", checksum, actual, err)
+	}
+
+	files := readArchive(t, filepath.Join(dir, name))
+	var index imageManifest
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+	digest := "sha256:" + sha256Hex(manifest)
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != digest ||
+		index.Manifests[0].Annotations[imageNameAnnotation] != image ||
+		index.Manifests[0].Annotations[refNameAnnotation] != "1.31.1" {
+		t.Errorf("index.json = %s", files["index.json"])
+	}
+	want := map[string]string{
+		"oci-layout":     `{"imageLayoutVersion":"1.0.0"}`,
+		blobPath(digest): string(manifest),
+		blobPath("sha256:" + sha256Hex([]byte(`{"architecture":"arm64","os":"linux"}`))): `{"architecture":"arm64","os":"linux"}`,
+		blobPath("sha256:" + sha256Hex([]byte("layer of arm64"))):                        "layer of arm64",
+	}
+	for file, content := range want {
+		if string(files[file]) != content {
+			t.Errorf("%s = %q, want %q", file, files[file], content)
+		}
+	}
+	if len(files) != len(want)+1 {
+		t.Errorf("archive has %d files, want %d", len(files), len(want)+1)
+	}
+}
+
+func TestSaveImageErrors(t *testing.T) {
+	registry := newTestBundleRegistry(t)
+	defer registry.Close()
+	registry.putImage(t)
+	setDockerLogin(t, registry.host())
+	image := registry.host() + "/library/busybox:1.31.1"
+	layer := "sha256:" + sha256Hex([]byte("layer of amd64"))
+
+	tests := []struct {
+		name     string
+		image    string
+		platform string
+		corrupt  string
+		err      string
+	}{
+		{"missing platform", image, "linux/s390x", "",
+			image + ": No image for linux/s390x, the image is for linux/amd64, linux/arm64."},
+		{"missing tag", registry.host() + "/library/busybox:latest", "linux/amd64", "",
+			"Manifest library/busybox:latest returned 404 Not Found."},
+		{"corrupt layer", image, "linux/amd64", layer,
+			"Can't download " + layer + " of " + image + ": Content doesn't match the digest " + layer + "."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry.mutex.Lock()
+			registry.corrupt = test.corrupt
+			registry.mutex.Unlock()
+			dir := t.TempDir()
+			_, _, err := SaveImage(context.Background(), test.image, test.platform, dir)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("SaveImage() = %v, want %q", err, test.err)
+			}
+			// No partial archive is left behind
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s has %d files", dir, len(entries))
+			}
+		})
+	}
+}
+
+func TestPushImage(t *testing.T) {
+	source := newTestBundleRegistry(t)
+	defer source.Close()
+	manifest := source.putImage(t)
+	setDockerLogin(t, source.host())
+	image := source.host() + "/library/busybox:1.31.1"
+	dir := t.TempDir()
+	name, checksum, err := SaveImage(context.Background(), image, "linux/arm64", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, name)
+
+	target := newTestBundleRegistry(t)
+	defer target.Close()
+	pushed, err := PushImage(context.Background(), archive, checksum, target.host()+"/offline", "robot", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := "offline/" + image[:strings.LastIndex(image, ":")]
+	if want := target.host() + "/" + repository + ":1.31.1"; pushed != want {
+		t.Errorf("pushed = %s, want %s", pushed, want)
+	}
+	if content := target.manifests[repository+"@1.31.1"]; string(content) != string(manifest) {
+		t.Errorf("pushed manifest = %s, want %s", content, manifest)
+	}
+	if target.uploads != 2 {
+		t.Errorf("%d blobs uploaded, want 2", target.uploads)
+	}
+
+	// The blobs the registry has aren't uploaded again
+	_, err = PushImage(context.Background(), archive, checksum, target.host()+"/offline", "robot", "secret")
+	if err != nil || target.uploads != 2 {
+		t.Errorf("pushing again = %v, %d blobs uploaded", err, target.uploads)
+	}
+
+	// An archive changed after it was saved isn't pushed
+	_, err = PushImage(context.Background(), archive, strings.Repeat("0", 64), target.host()+"/offline",
+		"robot", "secret")
+	if want := name + " doesn't match its checksum, save the image again."; err == nil || err.Error() != want {
+		t.Errorf("PushImage() with another checksum = %v, want %q", err, want)
+	}
+}
+
+func TestImageChecksums(t *testing.T) {
+	dir := t.TempDir()
+	checksums := map[string]string{
+		"docker.io_library_busybox_1.31.1.tar":      strings.Repeat("a", 64),
+		"quay.io_prometheus_prometheus_v2.45.0.tar": strings.Repeat("b", 64),
+	}
+	if err := WriteImageChecksums(dir, checksums); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, ImageChecksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Repeat("a", 64) + "  docker.io_library_busybox_1.31.1.tar\n" +
+		strings.Repeat("b", 64) + "  quay.io_prometheus_prometheus_v2.45.0.tar\n"
+	if string(content) != want {
+		t.Errorf("%s =\n%s\nwant\n%s", ImageChecksumsFile, content, want)
+	}
+	if read, err := ReadImageChecksums(dir); err != nil || !reflect.DeepEqual(read, checksums) {
+		t.Errorf("ReadImageChecksums() = %v, %v, want %v", read, err, checksums)
+	}
+
+	// sha256sum -b marks the names with *
+	content = []byte(strings.Repeat("c", 64) + " *docker.io_library_busybox_1.31.1.tar\n\n")
+	if err := os.WriteFile(filepath.Join(dir, ImageChecksumsFile), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadImageChecksums(dir)
+	if err != nil || read["docker.io_library_busybox_1.31.1.tar"] != strings.Repeat("c", 64) || len(read) != 1 {
+		t.Errorf("ReadImageChecksums() = %v, %v", read, err)
+	}
+
+	content = []byte("busybox.tar\n")
+	if err := os.WriteFile(filepath.Join(dir, ImageChecksumsFile), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadImageChecksums(dir); err == nil {
+		t.Error("ReadImageChecksums() of a line without a checksum succeeded")
+	}
+}
+
+func TestSaveOfflinePreset(t *testing.T) {
+	file := filepath.Join(t.TempDir(), MirrorPresetsFile)
+	content := `[{"name": "Our Harbor", "mirrors": {"docker.io": "harbor.example.com/dockerhub"}}]`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := SaveOfflinePreset(file, "registry.example.com/offline", []string{"quay.io", "docker.io"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pushing again replaces the preset of the registry instead of adding another one
+	err = SaveOfflinePreset(file, "registry.example.com/offline", []string{"quay.io", "docker.io", "registry.k8s.io"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	presets, err := LoadMirrorPresets(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MirrorPreset{
+		{Name: "Our Harbor", Mirrors: []RegistryMirror{{Source: "docker.io", Mirror: "harbor.example.com/dockerhub"}}},
+		{Name: "Offline registry.example.com/offline", Mirrors: []RegistryMirror{
+			{Source: "docker.io", Mirror: "registry.example.com/offline/docker.io"},
+			{Source: "quay.io", Mirror: "registry.example.com/offline/quay.io"},
+			{Source: "registry.k8s.io", Mirror: "registry.example.com/offline/registry.k8s.io"},
+		}},
+	}
+	if !reflect.DeepEqual(presets, want) {
+		t.Errorf("presets = %+v, want %+v", presets, want)
+	}
+
+	// A file that can't be parsed isn't overwritten
+	if err := os.WriteFile(file, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = SaveOfflinePreset(file, "registry.example.com/offline", []string{"docker.io"})
+	if err == nil || !strings.HasPrefix(err.Error(), "Can't parse "+file) {
+		t.Errorf("SaveOfflinePreset() = %v, want a parse error", err)
+	}
+	if content, _ := os.ReadFile(file); string(content) != "not json" {
+		t.Errorf("%s was overwritten with %s", file, content)
+	}
+}
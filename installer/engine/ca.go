@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Files of the internal CA, relative to the working directory of the executor.
+const (
+	InternalCaDir       = "certs"
+	InternalCaCertFile  = "certs/ca.crt"
+	InternalCaKeyFile   = "certs/ca.key"
+	InternalTlsCertFile = "certs/tls.crt"
+	InternalTlsKeyFile  = "certs/tls.key"
+	InternalCaSecret    = "om-kits-ca"
+)
+
+// Certificates are valid from a bit in the past, so clients with a skewed clock accept them.
+const certBackdate = time.Hour
+
+// CheckInternalCa checks that the internal CA in dir, if any, outlives a certificate valid for
+// validityDays, unless rotateCa allows replacing it. The clients trusting a replaced CA don't
+// trust the new certificates anymore.
+func CheckInternalCa(dir string, validityDays int, rotateCa bool) error {
+	caCert, _, err := loadInternalCa(dir)
+	if err != nil || rotateCa || !caCert.NotAfter.Before(time.Now().AddDate(0, 0, validityDays)) {
+		return nil
+	}
+	return errors.New("The internal CA expires on " + caCert.NotAfter.Format("2006-01-02") +
+		", before a certificate valid for " + strconv.Itoa(validityDays) + " days. Shorten the " +
+		"certificate validity, or rotate the CA and make the clients trust the new one.")
+}
+
+// IssueInternalCertificates issues a server certificate for host and extraSans, signed by the
+// internal CA in dir. The CA is created when there is none, and replaced by a new one when it
+// expires before the certificate only if rotateCa is set.
+func IssueInternalCertificates(dir string, host string, extraSans []string, caValidityDays int, validityDays int,
+	rotateCa bool, output io.Writer) error {
+	err := CheckInternalCa(dir, validityDays, rotateCa)
+	if err != nil {
+		return err
+	}
+	caCert, caKey, err := loadInternalCa(dir)
+	if err == nil && caCert.NotAfter.Before(time.Now().AddDate(0, 0, validityDays)) {
+		fmt.Fprintln(output, "The internal CA expires on "+caCert.NotAfter.Format(time.RFC3339)+
+			", rotating it. The clients must trust the new CA.")
+		err = errors.New("CA expires too early")
+	}
+	if err != nil {
+		caCert, caKey, err = createInternalCa(dir, caValidityDays)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(output, "Created internal CA, valid until "+caCert.NotAfter.Format(time.RFC3339)+".")
+	}
+
+	sans := append([]string{host}, extraSans...)
+	err = issueServerCertificate(dir, caCert, caKey, sans, time.Duration(validityDays)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(output, "Issued server certificate for", sans, "valid for", validityDays, "days.")
+	fmt.Fprintln(output, "Trust the CA certificate exported to "+filepath.Join(dir, "ca.crt")+" on the clients.")
+	return nil
+}
+
+// RenewInternalCertificates reissues the server certificate in dir with the same SANs and validity
+// period when it expires within renewBeforeDays. It reports whether the certificate was reissued.
+func RenewInternalCertificates(dir string, renewBeforeDays int, output io.Writer) (bool, error) {
+	certs, err := readCertificates(filepath.Join(dir, "tls.crt"))
+	if err != nil {
+		return false, err
+	}
+	leaf := certs[0]
+
+	if time.Now().AddDate(0, 0, renewBeforeDays).Before(leaf.NotAfter) {
+		fmt.Fprintln(output, "The server certificate is valid until "+leaf.NotAfter.Format(time.RFC3339)+", no renewal needed.")
+		return false, nil
+	}
+
+	caCert, caKey, err := loadInternalCa(dir)
+	if err != nil {
+		return false, err
+	}
+	validity := leaf.NotAfter.Sub(leaf.NotBefore) - certBackdate
+	if caCert.NotAfter.Before(time.Now().Add(validity)) {
+		return false, errors.New("The internal CA expires on " + caCert.NotAfter.Format(time.RFC3339) +
+			", reinstall with the internal CA method to create a new one.")
+	}
+
+	var sans []string
+	sans = append(sans, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	err = issueServerCertificate(dir, caCert, caKey, sans, validity)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintln(output, "Reissued server certificate for", sans, "valid until",
+		time.Now().Add(validity).Format(time.RFC3339)+".")
+	return true, nil
+}
+
+func loadInternalCa(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certs, err := readCertificates(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "ca.key"))
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, nil, errors.New("No private key found in " + filepath.Join(dir, "ca.key") + ".")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs[0], key, nil
+}
+
+func createInternalCa(dir string, validityDays int) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "OM-Kits Internal CA", Organization: []string{"OM-Kits"}},
+		NotBefore:             now.Add(-certBackdate),
+		NotAfter:              now.AddDate(0, 0, validityDays),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = writeKeyPair(dir, "ca", [][]byte{der}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func issueServerCertificate(dir string, caCert *x509.Certificate, caKey *ecdsa.PrivateKey, sans []string,
+	validity time.Duration) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: sans[0], Organization: []string{"OM-Kits"}},
+		NotBefore:    now.Add(-certBackdate),
+		NotAfter:     now.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, san)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	return writeKeyPair(dir, "tls", [][]byte{der, caCert.Raw}, key)
+}
+
+// writeKeyPair writes <name>.crt with the certificate chain and <name>.key into dir.
+func writeKeyPair(dir string, name string, chain [][]byte, key *ecdsa.PrivateKey) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	var certPem []byte
+	for _, der := range chain {
+		certPem = append(certPem, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	err = os.WriteFile(filepath.Join(dir, name+".key"), keyPem, 0600)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name+".crt"), certPem, 0644)
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,46 @@
+package engine
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestIssueInternalCertificatesKeepsAnExpiringCa(t *testing.T) {
+	dir := t.TempDir()
+	if err := IssueInternalCertificates(dir, "cluster.example.com", nil, 10, 5, false, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	firstCa, _, err := loadInternalCa(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The CA outlives a certificate of 5 days, not one of 30 days
+	if err = IssueInternalCertificates(dir, "cluster.example.com", nil, 10, 5, false, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	err = IssueInternalCertificates(dir, "cluster.example.com", nil, 3650, 30, false, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "rotate the CA") {
+		t.Fatalf("IssueInternalCertificates() = %v, want the CA expiring too early", err)
+	}
+	ca, _, _ := loadInternalCa(dir)
+	if ca.SerialNumber.Cmp(firstCa.SerialNumber) != 0 {
+		t.Fatal("the CA was replaced without rotating it")
+	}
+
+	if err = IssueInternalCertificates(dir, "cluster.example.com", nil, 3650, 30, true, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	ca, _, _ = loadInternalCa(dir)
+	if ca.SerialNumber.Cmp(firstCa.SerialNumber) == 0 {
+		t.Fatal("the CA wasn't rotated")
+	}
+	certs, err := readCertificates(dir + "/tls.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = certs[0].CheckSignatureFrom(ca); err != nil {
+		t.Errorf("the certificate isn't signed by the rotated CA: %v", err)
+	}
+}
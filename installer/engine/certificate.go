@@ -0,0 +1,114 @@
+package engine
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TlsSecretName is the name of the TLS secret created by the installer in every namespace of the kits.
+const TlsSecretName = "default-tls"
+
+// ValidateCertificateFiles checks that the PEM files form a key pair, that the certificate chain
+// is complete and currently valid, and that the certificate covers every host of hosts. The
+// chain may end at a CA of caBundleFile, when it isn't empty.
+func ValidateCertificateFiles(certFile string, keyFile string, hosts []string, caBundleFile string) error {
+	_, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Certificate and key don't form a pair: %w", err)
+	}
+
+	certs, err := readCertificates(certFile)
+	if err != nil {
+		return err
+	}
+	leaf := certs[0]
+
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		return errors.New("Certificate expired on " + leaf.NotAfter.Format(time.RFC3339) + ".")
+	}
+	if now.Before(leaf.NotBefore) {
+		return errors.New("Certificate isn't valid before " + leaf.NotBefore.Format(time.RFC3339) + ".")
+	}
+
+	for _, host := range hosts {
+		err = leaf.VerifyHostname(host)
+		if err != nil {
+			return fmt.Errorf("Certificate doesn't cover %s: %w", host, err)
+		}
+	}
+
+	var bundle []*x509.Certificate
+	if caBundleFile != "" {
+		bundle, err = readCertificates(caBundleFile)
+		if err != nil {
+			return err
+		}
+	}
+	err = verifyChain(certs, bundle)
+	if err != nil {
+		return fmt.Errorf("Certificate chain is incomplete: %w", err)
+	}
+	return nil
+}
+
+func readCertificates(certFile string) ([]*x509.Certificate, error) {
+	content, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("No certificate found in " + certFile + ".")
+	}
+	return certs, nil
+}
+
+// verifyChain verifies the leaf against the system roots and the CAs of bundle. A self-signed CA
+// at the end of the file is trusted as well, so a chain issued by a private CA is complete when it
+// includes it.
+func verifyChain(certs []*x509.Certificate, bundle []*x509.Certificate) error {
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	for _, cert := range bundle {
+		roots.AddCert(cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		if cert.IsCA && cert.CheckSignatureFrom(cert) == nil {
+			roots.AddCert(cert)
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	_, err = certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
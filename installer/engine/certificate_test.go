@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeLeafOnly writes the first certificate of certFile, without its chain, to a file of dir.
+func writeLeafOnly(t *testing.T, dir string, certFile string) string {
+	certs, err := readCertificates(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafFile := filepath.Join(dir, "leaf.crt")
+	err = os.WriteFile(leafFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certs[0].Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leafFile
+}
+
+func TestValidateCertificateFiles(t *testing.T) {
+	// A corporate CA, which the CA bundle holds
+	dir := t.TempDir()
+	caCert, caKey, err := createInternalCa(dir, 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = issueServerCertificate(dir, caCert, caKey, []string{"cluster.example.com"}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	leafFile := writeLeafOnly(t, dir, chainFile)
+	bundleFile := filepath.Join(dir, "ca.crt")
+
+	tests := []struct {
+		name   string
+		cert   string
+		hosts  []string
+		bundle string
+		valid  bool
+	}{
+		{"chain ending at its CA", chainFile, []string{"cluster.example.com"}, "", true},
+		{"leaf of an unknown CA", leafFile, []string{"cluster.example.com"}, "", false},
+		{"leaf of a CA of the bundle", leafFile, []string{"cluster.example.com"}, bundleFile, true},
+		{"other host", leafFile, []string{"other.example.com"}, bundleFile, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := ValidateCertificateFiles(test.cert, keyFile, test.hosts, test.bundle)
+			if (err == nil) != test.valid {
+				t.Errorf("ValidateCertificateFiles() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/exp/slices"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExecCommand runs cmdString with /bin/sh and returns its combined output.
+// A timeout of 0 means no timeout.
+func ExecCommand(cmdString string, timeout int, envs ...string) ([]byte, error) {
+	var cmd *exec.Cmd
+
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		defer cancel()
+
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", cmdString)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", cmdString)
+	}
+
+	cmd.Env = os.Environ()
+	for _, env := range envs {
+		cmd.Env = append(cmd.Env, env)
+	}
+
+	output, err := cmd.CombinedOutput()
+
+	return output, err
+}
+
+// Preflight checks that kubectl and helm are installed and the cluster is reachable.
+func Preflight() error {
+	_, err := ExecCommand("which kubectl", 0)
+	if err != nil {
+		return errors.New("kubectl is not found!")
+	}
+	_, err = ExecCommand("which helm", 0)
+	if err != nil {
+		return errors.New("helm is not found!")
+	}
+	_, err = ExecCommand("kubectl cluster-info", 0)
+	if err != nil {
+		return errors.New("Can't connect to k8s cluster!")
+	}
+	return nil
+}
+
+// SecretExists reports whether the secret exists in the namespace.
+func SecretExists(name string, namespace string) bool {
+	_, err := ExecCommand("kubectl get secret "+name+" -n "+namespace, 0)
+	return err == nil
+}
+
+// GetSecretNamespaces returns the namespaces which contain a secret with the name.
+func GetSecretNamespaces(name string) ([]string, error) {
+	result, err := ExecCommand("kubectl get secret -A --field-selector metadata.name="+name+
+		" --no-headers -o custom-columns=\":metadata.namespace\"", 0)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(result)), nil
+}
+
+// GetStorageClasses returns the storage classes of the cluster, plus the ones the kits can install.
+func GetStorageClasses() ([]string, error) {
+	var storageClasses []string
+
+	result, err := ExecCommand("kubectl get sc --no-headers -o custom-columns=\":metadata.name\"", 0)
+	if err != nil {
+		return nil, err
+	}
+	storageClasses = strings.Split(strings.TrimSpace(string(result)), "\n")
+
+	if !slices.Contains(storageClasses, "local-path") {
+		storageClasses = append(storageClasses, "local-path")
+	}
+	if !slices.Contains(storageClasses, "nfs-client") {
+		storageClasses = append(storageClasses, "nfs-client")
+	}
+
+	return storageClasses, nil
+}
+
+// NewLogFilePath returns the path of a new log file in dir, named after prefix and the current time.
+func NewLogFilePath(dir string, prefix string) string {
+	return newTimestampedPath(dir, prefix, time.Now(), ".log")
+}
+
+// newTimestampedPath returns the path of a file in dir named after prefix, moment and extension,
+// which doesn't exist yet. Runs within the same millisecond get a counter suffix.
+func newTimestampedPath(dir string, prefix string, moment time.Time, extension string) string {
+	name := prefix + moment.Format("20060102T150405.000")
+	path := filepath.Join(dir, name+extension)
+	for count := 2; ; count++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, name+"-"+strconv.Itoa(count)+extension)
+	}
+}
@@ -0,0 +1,410 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"net/mail"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config holds every setting collected by the wizard. A Plan is built from it.
+type Config struct {
+	BasicInfo BasicInfo
+
+	InstallIngressNginx         bool
+	InstallLocalPathProvisioner bool
+	InstallNfsProvisioner       bool
+	InstallPrometheus           bool
+	InstallLogging              bool
+
+	IngressNginx   IngressNginxConfig
+	NfsProvisioner NfsProvisionerConfig
+	Prometheus     PrometheusConfig
+	Logging        LoggingConfig
+
+	EnableMirror    bool
+	RegistryMirrors []RegistryMirror
+
+	Notification NotificationConfig
+}
+
+type BasicInfo struct {
+	Host              string
+	HttpsEnabled      bool
+	Timezone          string
+	CaBundleFile      string
+	Proxy             ProxyConfig
+	TlsCert           TlsCert
+	IngressClass      string
+	IngressController string
+	MaxBodySize       string
+	Exposure          string
+	SubdomainRouting  bool
+	GrafanaNodePort   int
+	KibanaNodePort    int
+	AccessControl     AccessControlConfig
+}
+
+type Exposure struct {
+	IngressHost  string
+	IngressPath  string
+	NodePort     string
+	LoadBalancer string
+}
+
+var Exposures = Exposure{
+	IngressHost:  "Ingress with host",
+	IngressPath:  "Ingress by path only",
+	NodePort:     "NodePort",
+	LoadBalancer: "LoadBalancer",
+}
+
+type TlsCert struct {
+	CertMethod       string
+	ForceSslRedirect bool
+	AcmeEmail        string
+	Acme             AcmeConfig
+	CertFile         string
+	KeyFile          string
+	CaValidityDays   int
+	CertValidityDays int
+	ExtraSans        string
+	RotateCa         bool
+}
+
+type CertMethod struct {
+	DefaultTlsSecret string
+	CertManager      string
+	CertificateFiles string
+	InternalCa       string
+}
+
+var CertMethods = CertMethod{
+	DefaultTlsSecret: "Default TLS Secret (Secret name: default-tls, Namespace: default)",
+	CertManager:      "Cert Manager",
+	CertificateFiles: "Certificate and key files (PEM)",
+	InternalCa:       "Self-signed internal CA",
+}
+
+type IngressNginxConfig struct {
+	Exposure      string
+	HttpNodePort  int
+	HttpsNodePort int
+	DefaultClass  bool
+}
+
+type IngressNginxExposure struct {
+	HostNetwork  string
+	NodePort     string
+	LoadBalancer string
+}
+
+var IngressNginxExposures = IngressNginxExposure{
+	HostNetwork:  "Host network (ports 80 and 443 of every node)",
+	NodePort:     "NodePort",
+	LoadBalancer: "LoadBalancer",
+}
+
+type NfsProvisionerConfig struct {
+	Server       string
+	Path         string
+	MountOptions string
+}
+
+type PrometheusConfig struct {
+	AlertmanagerStorageSizeGi int
+	GrafanaStorageSizeGi      int
+	PrometheusStorageSizeGi   int
+	StorageClass              string
+	Receivers                 []AlertReceiver
+	Routing                   AlertRouting
+	Templates                 AlertTemplates
+}
+
+type LoggingConfig struct {
+	CollectNamespaces string
+	StorageClass      string
+	EsStorageSizeGi   int
+	EsIndexAgeDay     int
+	NodeAffinity      bool
+	ErrorLogAlert     bool
+}
+
+// NewConfig returns a Config filled with the default values of the wizard.
+func NewConfig() *Config {
+	return &Config{
+		BasicInfo: BasicInfo{
+			IngressClass:      "nginx",
+			IngressController: IngressControllers.Nginx,
+			MaxBodySize:       "50m",
+			Exposure:          Exposures.IngressHost,
+			GrafanaNodePort:   30300,
+			KibanaNodePort:    30561,
+			AccessControl: AccessControlConfig{
+				Method: AccessControlMethods.None,
+			},
+			TlsCert: TlsCert{
+				Acme: AcmeConfig{
+					Server: AcmeServers.LetsEncrypt,
+					Solver: AcmeSolvers.Http01,
+				},
+				CaValidityDays:   3650,
+				CertValidityDays: 365,
+			},
+		},
+		IngressNginx: IngressNginxConfig{
+			Exposure:      IngressNginxExposures.HostNetwork,
+			HttpNodePort:  30080,
+			HttpsNodePort: 30443,
+			DefaultClass:  true,
+		},
+		NfsProvisioner: NfsProvisionerConfig{
+			Server:       "",
+			Path:         "/",
+			MountOptions: "vers=3,nolock,proto=tcp,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport",
+		},
+		Prometheus: PrometheusConfig{
+			AlertmanagerStorageSizeGi: 10,
+			GrafanaStorageSizeGi:      5,
+			PrometheusStorageSizeGi:   10,
+			StorageClass:              "",
+			Routing:                   DefaultAlertRouting(),
+			Templates:                 AlertTemplates{Set: AlertTemplateSets.Default},
+		},
+		Logging: LoggingConfig{
+			CollectNamespaces: "",
+			StorageClass:      "",
+			EsStorageSizeGi:   20,
+			EsIndexAgeDay:     7,
+			NodeAffinity:      true,
+			ErrorLogAlert:     false,
+		},
+		Notification: NotificationConfig{
+			DingTalkRobotUrl: DefaultDingTalkRobotUrl,
+			SmtpPort:         25,
+		},
+	}
+}
+
+// Validate checks the basic info. It doesn't talk to the cluster, see SecretExists for that.
+func (info *BasicInfo) Validate() error {
+	if info.Host == "" {
+		return errors.New("Custer domain name or IP is empty.")
+	}
+
+	err := ValidateTimezone(info.Timezone)
+	if err != nil {
+		return err
+	}
+
+	if info.CaBundleFile != "" {
+		// Tasks don't run in the current directory
+		info.CaBundleFile, err = filepath.Abs(info.CaBundleFile)
+		if err != nil {
+			return err
+		}
+		err = ValidateCaBundle(info.CaBundleFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = info.Proxy.Validate()
+	if err != nil {
+		return err
+	}
+
+	if info.IngressClass == "" {
+		return errors.New("Please select an ingress class.")
+	}
+
+	_, err = ParseBodySize(info.MaxBodySize)
+	if err != nil {
+		return err
+	}
+
+	switch info.Exposure {
+	case "":
+		return errors.New("Please select how to expose the UIs.")
+	case Exposures.IngressHost:
+		if net.ParseIP(info.Host) != nil {
+			return errors.New(info.Host + " is an IP address, please expose the UIs by path, NodePort or LoadBalancer.")
+		}
+	case Exposures.NodePort:
+		for _, port := range []int{info.GrafanaNodePort, info.KibanaNodePort} {
+			if port < 30000 || port > 32767 {
+				return errors.New("Node port " + strconv.Itoa(port) + " is out of range 30000-32767.")
+			}
+		}
+		if info.GrafanaNodePort == info.KibanaNodePort {
+			return errors.New("Grafana and Kibana node ports are the same.")
+		}
+	}
+
+	err = info.validateAccessControl()
+	if err != nil {
+		return err
+	}
+
+	if info.HttpsEnabled {
+		if net.ParseIP(info.Host) != nil {
+			return errors.New(info.Host + " must be a DNS, not an IP address, when https is enabled.")
+		}
+
+		if info.Exposure != Exposures.IngressHost {
+			return errors.New("Https is only available when the UIs are exposed by ingress with host.")
+		}
+
+		if info.TlsCert.CertMethod == "" {
+			return errors.New("Please select a method to generate SSL certificate.")
+		}
+
+		if info.TlsCert.CertMethod == CertMethods.CertManager {
+			email, err := mail.ParseAddress(info.TlsCert.AcmeEmail)
+			if err != nil {
+				return errors.New("Email is empty or format is wrong.")
+			}
+			info.TlsCert.AcmeEmail = email.Address
+
+			err = info.TlsCert.Acme.Validate()
+			if err != nil {
+				return err
+			}
+		}
+
+		if info.TlsCert.CertMethod == CertMethods.CertificateFiles {
+			if info.TlsCert.CertFile == "" || info.TlsCert.KeyFile == "" {
+				return errors.New("Certificate file or key file is empty.")
+			}
+			// Tasks don't run in the current directory
+			info.TlsCert.CertFile, err = filepath.Abs(info.TlsCert.CertFile)
+			if err != nil {
+				return err
+			}
+			info.TlsCert.KeyFile, err = filepath.Abs(info.TlsCert.KeyFile)
+			if err != nil {
+				return err
+			}
+
+			err = ValidateCertificateFiles(info.TlsCert.CertFile, info.TlsCert.KeyFile, info.Hostnames(),
+				info.CaBundleFile)
+			if err != nil {
+				return err
+			}
+		}
+
+		if info.TlsCert.CertMethod == CertMethods.InternalCa {
+			if info.TlsCert.CaValidityDays <= 0 {
+				return errors.New("CA validity is 0.")
+			}
+			if info.TlsCert.CertValidityDays <= 0 {
+				return errors.New("Certificate validity is 0.")
+			}
+			if info.TlsCert.CertValidityDays > info.TlsCert.CaValidityDays {
+				return errors.New("Certificate validity is longer than CA validity.")
+			}
+		}
+	}
+
+	return nil
+}
+
+// IngressExposed reports whether the UIs are exposed by ingresses rather than by their services.
+func (info *BasicInfo) IngressExposed() bool {
+	return info.Exposure == Exposures.IngressHost || info.Exposure == Exposures.IngressPath
+}
+
+// ExtraSanList returns the extra SANs of the internal CA certificate.
+func (cert *TlsCert) ExtraSanList() []string {
+	var sans []string
+	for _, san := range strings.Split(cert.ExtraSans, ",") {
+		san = strings.TrimSpace(san)
+		if san != "" {
+			sans = append(sans, san)
+		}
+	}
+	return sans
+}
+
+// IngressNamespaces returns the namespaces where the kits create ingresses.
+func (config *Config) IngressNamespaces() []string {
+	var namespaces []string
+	if !config.BasicInfo.IngressExposed() {
+		return namespaces
+	}
+	if config.InstallPrometheus {
+		namespaces = append(namespaces, "monitoring")
+	}
+	if config.InstallLogging {
+		namespaces = append(namespaces, "logging")
+	}
+	if len(namespaces) > 0 && config.BasicInfo.AccessControl.Method == AccessControlMethods.OAuth2Proxy {
+		namespaces = append(namespaces, OAuth2ProxyNamespace)
+	}
+	return namespaces
+}
+
+// TlsNamespaces returns the namespaces which need the TLS secret.
+func (config *Config) TlsNamespaces() []string {
+	return append([]string{"default"}, config.IngressNamespaces()...)
+}
+
+func (config *IngressNginxConfig) Validate() error {
+	if config.Exposure == "" {
+		return errors.New("Please select how to expose the ingress controller.")
+	}
+	if config.Exposure == IngressNginxExposures.NodePort {
+		for _, port := range []int{config.HttpNodePort, config.HttpsNodePort} {
+			if port < 30000 || port > 32767 {
+				return errors.New("Node port " + strconv.Itoa(port) + " is out of range 30000-32767.")
+			}
+		}
+		if config.HttpNodePort == config.HttpsNodePort {
+			return errors.New("HTTP and HTTPS node ports are the same.")
+		}
+	}
+	return nil
+}
+
+func (config *NfsProvisionerConfig) Validate() error {
+	if config.Server == "" {
+		return errors.New("NFS server is empty.")
+	}
+	if config.Path == "" {
+		return errors.New("NFS path is empty.")
+	}
+	return nil
+}
+
+func (config *PrometheusConfig) Validate() error {
+	if config.AlertmanagerStorageSizeGi == 0 {
+		return errors.New("Alert manager storage size is 0.")
+	}
+	if config.GrafanaStorageSizeGi == 0 {
+		return errors.New("Grafana storage size is 0.")
+	}
+	if config.PrometheusStorageSizeGi == 0 {
+		return errors.New(" Prometheus storage size is 0.")
+	}
+	err := config.ValidateReceivers()
+	if err != nil {
+		return err
+	}
+	err = config.ValidateRouting()
+	if err != nil {
+		return err
+	}
+	return config.Templates.Validate()
+}
+
+func (config *LoggingConfig) Validate() error {
+	if config.EsStorageSizeGi == 0 {
+		return errors.New("Elasticsearch storage size is 0.")
+	}
+	if config.EsIndexAgeDay == 0 {
+		return errors.New("Index age is 0.")
+	}
+	return nil
+}
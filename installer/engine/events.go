@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventPreflightResult = "preflight-result"
+	EventTaskStarted     = "task-started"
+	EventOutputLine      = "output-line"
+	EventTaskFinished    = "task-finished"
+	EventRunFinished     = "run-finished"
+)
+
+// Event is one line of the JSON-lines event stream.
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Index      *int      `json:"index,omitempty"`
+	Task       string    `json:"task,omitempty"`
+	Line       string    `json:"line,omitempty"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	DurationMs *int64    `json:"durationMs,omitempty"`
+	Success    *bool     `json:"success,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func PreflightResultEvent(err error) Event {
+	event := Event{Type: EventPreflightResult, Time: time.Now()}
+	event.setResult(err)
+	return event
+}
+
+func TaskStartedEvent(index int, task Task) Event {
+	return Event{Type: EventTaskStarted, Time: time.Now(), Index: &index, Task: task.Name}
+}
+
+func OutputLineEvent(index int, task Task, line string) Event {
+	return Event{Type: EventOutputLine, Time: time.Now(), Index: &index, Task: task.Name, Line: line}
+}
+
+func TaskFinishedEvent(index int, result TaskResult) Event {
+	event := Event{Type: EventTaskFinished, Time: time.Now(), Index: &index, Task: result.Task.Name,
+		ExitCode: &result.ExitCode}
+	event.setDuration(result.Duration)
+	event.setResult(result.Err)
+	return event
+}
+
+func RunFinishedEvent(err error, duration time.Duration) Event {
+	event := Event{Type: EventRunFinished, Time: time.Now()}
+	event.setDuration(duration)
+	event.setResult(err)
+	return event
+}
+
+func (event *Event) setDuration(duration time.Duration) {
+	durationMs := duration.Milliseconds()
+	event.DurationMs = &durationMs
+}
+
+func (event *Event) setResult(err error) {
+	success := err == nil
+	event.Success = &success
+	if err != nil {
+		event.Error = err.Error()
+	}
+}
+
+// eventWriteTimeout bounds the write of an event to a socket, whose reader may stall.
+var eventWriteTimeout = 2 * time.Second
+
+// EventStream writes events as JSON lines. A nil *EventStream discards every event, and so
+// does a stream which failed or timed out to write once, so a gone or stalled reader never
+// blocks an install.
+type EventStream struct {
+	mu      sync.Mutex
+	writer  io.WriteCloser
+	encoder *json.Encoder
+	failed  bool
+}
+
+// OpenEventStream opens target for writing events. A target of the form "unix:<path>"
+// connects to a Unix socket, any other target is a file which events are appended to.
+func OpenEventStream(target string) (*EventStream, error) {
+	var writer io.WriteCloser
+	var err error
+
+	if strings.HasPrefix(target, "unix:") {
+		writer, err = net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+	} else {
+		writer, err = os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventStream{writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+func (stream *EventStream) Emit(event Event) {
+	if stream == nil {
+		return
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.failed {
+		return
+	}
+	if conn, ok := stream.writer.(net.Conn); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(eventWriteTimeout))
+	}
+	if err := stream.encoder.Encode(event); err != nil {
+		stream.failed = true
+	}
+}
+
+func (stream *EventStream) Close() error {
+	if stream == nil {
+		return nil
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return stream.writer.Close()
+}
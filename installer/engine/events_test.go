@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	stream, err := OpenEventStream(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Emit(TaskStartedEvent(0, Task{Name: "Install Prometheus"}))
+	stream.Emit(RunFinishedEvent(nil, time.Second))
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var nilStream *EventStream
+	nilStream.Emit(RunFinishedEvent(nil, time.Second))
+
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(bytes)
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), content)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(lines[0]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventTaskStarted || event.Task != "Install Prometheus" || *event.Index != 0 {
+		t.Errorf("first event = %+v", event)
+	}
+}
+
+func TestEventStreamStalledSocket(t *testing.T) {
+	defer func(timeout time.Duration) { eventWriteTimeout = timeout }(eventWriteTimeout)
+	eventWriteTimeout = 100 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "events.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	stream, err := OpenEventStream("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	reader := <-accepted
+	defer reader.Close()
+
+	// The reader reads the first event then stalls, the socket buffers fill up
+	stream.Emit(TaskStartedEvent(0, Task{Name: "first"}))
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil || !strings.Contains(line, `"first"`) {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		line := strings.Repeat("x", 64*1024)
+		for index := 0; index < 1000; index++ {
+			stream.Emit(OutputLineEvent(0, Task{Name: "first"}, line))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Emit blocked on a stalled reader")
+	}
+}
@@ -0,0 +1,157 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// TaskResult describes how a task ended.
+type TaskResult struct {
+	Task     Task
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Err      error
+}
+
+// Executor runs the tasks of a plan one after another and stops at the first failure
+// or when the context of Run is cancelled. The callbacks are optional and are called
+// from the goroutine running Run.
+type Executor struct {
+	// Dir is the working directory of the tasks, the one containing packages/.
+	Dir string
+
+	OnTaskStart  func(index int, task Task)
+	OnOutput     func(index int, line string)
+	OnTaskFinish func(index int, result TaskResult)
+	OnRunFinish  func(err error)
+}
+
+// Run executes the plan and returns the error of the failed task, if any.
+// Cancelling ctx terminates the process group of the running task.
+func (executor *Executor) Run(ctx context.Context, plan *Plan) error {
+	var runErr error
+
+	for index, task := range plan.Tasks {
+		if ctx.Err() != nil {
+			runErr = ctx.Err()
+			break
+		}
+
+		result := executor.runTask(ctx, index, task, plan.Envs)
+		if executor.OnTaskFinish != nil {
+			executor.OnTaskFinish(index, result)
+		}
+		if result.Err != nil {
+			runErr = result.Err
+			break
+		}
+	}
+
+	if executor.OnRunFinish != nil {
+		executor.OnRunFinish(runErr)
+	}
+	return runErr
+}
+
+func (executor *Executor) runTask(ctx context.Context, index int, task Task, envs []string) TaskResult {
+	result := TaskResult{Task: task, ExitCode: -1}
+
+	if executor.OnTaskStart != nil {
+		executor.OnTaskStart(index, task)
+	}
+	startTime := time.Now()
+
+	if task.Func != nil {
+		result.Err = executor.runFunc(ctx, index, task)
+		if result.Err == nil {
+			result.ExitCode = 0
+		} else {
+			result.ExitCode = 1
+			result.Stderr = result.Err.Error()
+		}
+		result.Duration = time.Since(startTime)
+		return result
+	}
+
+	cmd := exec.Command("/bin/bash", "-c", task.Command)
+	cmd.Dir = executor.Dir
+	cmd.Env = append(os.Environ(), envs...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	// Kill the process group on cancellation, but never after the process was reaped
+	exited := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		select {
+		case <-ctx.Done():
+			_ = killProcessGroup(cmd.Process)
+		case <-exited:
+		}
+	}()
+
+	executor.reportOutput(index, stdout)
+	close(exited)
+	<-watcherDone
+
+	result.Err = cmd.Wait()
+
+	result.ExitCode = cmd.ProcessState.ExitCode()
+	result.Stderr = stderr.String()
+	result.Duration = time.Since(startTime)
+	return result
+}
+
+func (executor *Executor) runFunc(ctx context.Context, index int, task Task) error {
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := task.Func(ctx, executor.Dir, writer)
+		writer.Close()
+		done <- err
+	}()
+
+	executor.reportOutput(index, reader)
+	return <-done
+}
+
+func (executor *Executor) reportOutput(index int, output io.Reader) {
+	scanner := bufio.NewScanner(output)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if executor.OnOutput != nil {
+			executor.OnOutput(index, scanner.Text())
+		}
+	}
+	// Drain what the scanner couldn't handle, so the writer never blocks on a full pipe
+	_, _ = io.Copy(io.Discard, output)
+}
+
+func killProcessGroup(process *os.Process) error {
+	pgid, err := syscall.Getpgid(process.Pid)
+	if err != nil {
+		return err
+	}
+	return syscall.Kill(-pgid, syscall.SIGTERM)
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingExecutor returns an executor recording its callbacks in order into calls.
+func recordingExecutor(calls *[]string) *Executor {
+	return &Executor{
+		Dir: ".",
+		OnTaskStart: func(index int, task Task) {
+			*calls = append(*calls, fmt.Sprintf("start %d", index))
+		},
+		OnOutput: func(index int, line string) {
+			*calls = append(*calls, fmt.Sprintf("output %d %s", index, line))
+		},
+		OnTaskFinish: func(index int, result TaskResult) {
+			*calls = append(*calls, fmt.Sprintf("finish %d %d", index, result.ExitCode))
+		},
+		OnRunFinish: func(err error) {
+			*calls = append(*calls, fmt.Sprintf("run %v", err != nil))
+		},
+	}
+}
+
+func TestExecutorRunsTasksInOrder(t *testing.T) {
+	var calls []string
+	plan := &Plan{
+		Tasks: []Task{
+			{Name: "shell", Command: "echo $IDO_GREETING; echo two"},
+			{Name: "func", Func: func(ctx context.Context, dir string, output io.Writer) error {
+				fmt.Fprintln(output, "func in "+dir)
+				return nil
+			}},
+			{Name: "stderr", Command: "echo three; echo oops >&2"},
+		},
+		Envs: []string{"IDO_GREETING=one"},
+	}
+
+	err := recordingExecutor(&calls).Run(context.Background(), plan)
+	if err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+	want := []string{
+		"start 0", "output 0 one", "output 0 two", "finish 0 0",
+		"start 1", "output 1 func in .", "finish 1 0",
+		"start 2", "output 2 three", "finish 2 0",
+		"run false",
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestExecutorStopsAtFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		code int
+	}{
+		{"shell", Task{Name: "fail", Command: "echo failing >&2; exit 3"}, 3},
+		{"func", Task{Name: "fail", Func: func(ctx context.Context, dir string, output io.Writer) error {
+			return errors.New("failing")
+		}}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls []string
+			var failed TaskResult
+			executor := recordingExecutor(&calls)
+			onTaskFinish := executor.OnTaskFinish
+			executor.OnTaskFinish = func(index int, result TaskResult) {
+				onTaskFinish(index, result)
+				failed = result
+			}
+			plan := &Plan{Tasks: []Task{test.task, {Name: "never", Command: "echo never"}}}
+
+			err := executor.Run(context.Background(), plan)
+			if err == nil {
+				t.Fatal("Run() = nil, want the error of the failed task")
+			}
+			want := []string{"start 0", fmt.Sprintf("finish 0 %d", test.code), "run true"}
+			if !reflect.DeepEqual(calls, want) {
+				t.Errorf("calls = %q, want %q", calls, want)
+			}
+			if strings.TrimSpace(failed.Stderr) != "failing" {
+				t.Errorf("Stderr = %q, want failing", failed.Stderr)
+			}
+		})
+	}
+}
+
+func TestExecutorCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+	}{
+		// The child of the shell keeps stdout open, the whole process group must be terminated
+		{"shell", Task{Name: "wait", Command: "echo started; sleep 30 & wait"}},
+		{"func", Task{Name: "wait", Func: func(ctx context.Context, dir string, output io.Writer) error {
+			fmt.Fprintln(output, "started")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(30 * time.Second):
+				return nil
+			}
+		}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var calls []string
+			executor := recordingExecutor(&calls)
+			onOutput := executor.OnOutput
+			executor.OnOutput = func(index int, line string) {
+				onOutput(index, line)
+				cancel()
+			}
+			plan := &Plan{Tasks: []Task{test.task, {Name: "never", Command: "echo never"}}}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- executor.Run(ctx, plan)
+			}()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Fatal("Run() = nil, want an error once cancelled")
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("Run() didn't return after the cancellation")
+			}
+			if len(calls) != 4 || calls[0] != "start 0" || calls[1] != "output 0 started" ||
+				!strings.HasPrefix(calls[2], "finish 0 ") || calls[3] != "run true" {
+				t.Errorf("calls = %q, want the first task started, cancelled and the run finished", calls)
+			}
+		})
+	}
+}
+
+func TestExecutorCancelledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var calls []string
+	err := recordingExecutor(&calls).Run(ctx, &Plan{Tasks: []Task{{Name: "never", Command: "echo never"}}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() = %v, want %v", err, context.Canceled)
+	}
+	if want := []string{"run true"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// uiIds are the ids of the web UIs, used as their subdomain or path prefix.
+var uiIds = []string{"grafana", "kibana"}
+
+// webUi is a web UI installed by the kits and the service serving it.
+type webUi struct {
+	Id        string
+	Name      string
+	Namespace string
+	Service   string
+	Port      int
+	NodePort  int
+}
+
+// webUis returns the web UIs installed by config.
+func (config *Config) webUis() []webUi {
+	var uis []webUi
+	if config.InstallPrometheus {
+		uis = append(uis, webUi{Id: "grafana", Name: "Grafana", Namespace: "monitoring", Service: "prometheus-grafana",
+			Port: 80, NodePort: config.BasicInfo.GrafanaNodePort})
+	}
+	if config.InstallLogging {
+		uis = append(uis, webUi{Id: "kibana", Name: "Kibana", Namespace: "logging", Service: "elasticsearch-kibana",
+			Port: 5601, NodePort: config.BasicInfo.KibanaNodePort})
+	}
+	return uis
+}
+
+// subdomainRouted reports whether every UI has its own hostname under the host.
+func (info *BasicInfo) subdomainRouted() bool {
+	return info.SubdomainRouting && info.Exposure == Exposures.IngressHost
+}
+
+// uiHost returns the hostname of the ingress of the UI with id.
+func (info *BasicInfo) uiHost(id string) string {
+	if info.subdomainRouted() {
+		return id + "." + info.Host
+	}
+	return info.Host
+}
+
+// uiPath returns the path prefix of the UI with id, empty when it has its own hostname.
+func (info *BasicInfo) uiPath(id string) string {
+	if info.subdomainRouted() {
+		return ""
+	}
+	return "/" + id
+}
+
+// uiIngressPath returns the path of the ingress rule of the UI with id.
+func (info *BasicInfo) uiIngressPath(id string) string {
+	if info.subdomainRouted() {
+		return "/"
+	}
+	return info.uiPath(id)
+}
+
+// Hostnames returns the host and the hostnames of the UIs, which the certificate must cover.
+func (info *BasicInfo) Hostnames() []string {
+	hostnames := []string{info.Host}
+	if info.subdomainRouted() {
+		for _, id := range uiIds {
+			hostnames = append(hostnames, info.uiHost(id))
+		}
+	}
+	return hostnames
+}
+
+// url returns the URL of ui. address is the address of the LoadBalancer service, the other
+// exposures are reached through the host of the basic info.
+func (info *BasicInfo) url(ui webUi, address string) string {
+	path := info.uiPath(ui.Id)
+	switch info.Exposure {
+	case Exposures.IngressHost:
+		if info.HttpsEnabled {
+			return "https://" + info.uiHost(ui.Id) + path + "/"
+		}
+		return "http://" + info.uiHost(ui.Id) + path + "/"
+	case Exposures.NodePort:
+		return "http://" + net.JoinHostPort(info.Host, strconv.Itoa(ui.NodePort)) + path + "/"
+	case Exposures.LoadBalancer:
+		if ui.Port == 80 {
+			return "http://" + address + path + "/"
+		}
+		return "http://" + net.JoinHostPort(address, strconv.Itoa(ui.Port)) + path + "/"
+	}
+	return "http://" + info.Host + path + "/"
+}
+
+// GetServiceUrls returns one line per web UI installed by config with its URL. The addresses
+// of LoadBalancer services are read from the cluster.
+func GetServiceUrls(config *Config) []string {
+	var urls []string
+	for _, ui := range config.webUis() {
+		address := ""
+		if config.BasicInfo.Exposure == Exposures.LoadBalancer {
+			result, err := ExecCommand("kubectl get service "+ui.Service+" --namespace "+ui.Namespace+" -o jsonpath="+
+				"'{.status.loadBalancer.ingress[0].ip}{.status.loadBalancer.ingress[0].hostname}'", 10)
+			address = strings.TrimSpace(string(result))
+			if err != nil || address == "" {
+				urls = append(urls, ui.Name+": LoadBalancer address of service "+ui.Namespace+"/"+ui.Service+" is pending")
+				continue
+			}
+		}
+		urls = append(urls, ui.Name+": "+config.BasicInfo.url(ui, address))
+	}
+	return urls
+}
@@ -0,0 +1,404 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renderValues renders the values file of a package with the variables of plan, like its
+// install.sh does with envsubst.
+func renderValues(t *testing.T, plan *Plan, file string) map[string]interface{} {
+	content, err := os.ReadFile(filepath.Join(repoDir, file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]interface{}
+	err = yaml.Unmarshal([]byte(envsubst(string(content), plan.Envs)), &values)
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	return values
+}
+
+// valueAt returns the value at path, like grafana.service.type, of values.
+func valueAt(values map[string]interface{}, path string) interface{} {
+	var value interface{} = values
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+const elasticsearchValues = "packages/logging/values-elasticsearch-override.yaml"
+
+func TestExposurePlan(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		exposure string
+		envs     map[string]string
+		values   map[string]interface{}
+	}{
+		{
+			name:     "ingress with host",
+			host:     "cluster.example.com",
+			exposure: Exposures.IngressHost,
+			envs: map[string]string{
+				"IDO_INGRESS_HOSTNAME":         "cluster.example.com",
+				"IDO_INGRESS_ENABLED":          "true",
+				"IDO_SERVICE_TYPE":             "ClusterIP",
+				"IDO_GRAFANA_NODE_PORT":        "",
+				"IDO_KIBANA_NODE_PORT":         "",
+				"IDO_GRAFANA_ROOT_URL":         "http://cluster.example.com/grafana/",
+				"IDO_KIBANA_INGRESS_HOSTNAME":  "cluster.example.com",
+				"IDO_GRAFANA_INGRESS_HOSTNAME": "cluster.example.com",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.enabled":              true,
+				"grafana.ingress.hosts":                []interface{}{"cluster.example.com"},
+				"grafana.ingress.path":                 "/grafana",
+				"grafana.service.type":                 "ClusterIP",
+				"grafana.env.GF_SERVER_ROOT_URL":       "http://cluster.example.com/grafana/",
+				"kibana.ingress.enabled":               true,
+				"kibana.ingress.hostname":              "cluster.example.com",
+				"kibana.ingress.path":                  "/kibana",
+				"kibana.service.type":                  "ClusterIP",
+				"kibana.configuration.server.basePath": "/kibana",
+			},
+		},
+		{
+			name:     "ingress by path",
+			host:     "192.168.1.10",
+			exposure: Exposures.IngressPath,
+			envs: map[string]string{
+				"IDO_INGRESS_HOSTNAME":         "",
+				"IDO_INGRESS_ENABLED":          "true",
+				"IDO_SERVICE_TYPE":             "ClusterIP",
+				"IDO_GRAFANA_ROOT_URL":         "http://192.168.1.10/grafana/",
+				"IDO_GRAFANA_INGRESS_HOSTNAME": "",
+				"IDO_KIBANA_INGRESS_HOSTNAME":  "*",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.enabled": true,
+				"grafana.ingress.hosts":   []interface{}{""},
+				"grafana.ingress.path":    "/grafana",
+				"kibana.ingress.enabled":  true,
+				"kibana.ingress.hostname": "*",
+				"kibana.ingress.path":     "/kibana",
+			},
+		},
+		{
+			name:     "node port",
+			host:     "192.168.1.10",
+			exposure: Exposures.NodePort,
+			envs: map[string]string{
+				"IDO_INGRESS_ENABLED":   "false",
+				"IDO_SERVICE_TYPE":      "NodePort",
+				"IDO_GRAFANA_NODE_PORT": "30300",
+				"IDO_KIBANA_NODE_PORT":  "30561",
+				"IDO_GRAFANA_ROOT_URL":  "http://192.168.1.10:30300/grafana/",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.enabled":       false,
+				"grafana.service.type":          "NodePort",
+				"grafana.service.nodePort":      "30300",
+				"kibana.ingress.enabled":        false,
+				"kibana.service.type":           "NodePort",
+				"kibana.service.nodePorts.http": "30561",
+			},
+		},
+		{
+			name:     "load balancer",
+			host:     "192.168.1.10",
+			exposure: Exposures.LoadBalancer,
+			envs: map[string]string{
+				"IDO_INGRESS_ENABLED":   "false",
+				"IDO_SERVICE_TYPE":      "LoadBalancer",
+				"IDO_GRAFANA_NODE_PORT": "",
+				"IDO_GRAFANA_ROOT_URL":  "%(protocol)s://%(domain)s/grafana/",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.enabled":        false,
+				"grafana.service.type":           "LoadBalancer",
+				"grafana.service.nodePort":       "",
+				"grafana.env.GF_SERVER_ROOT_URL": "%(protocol)s://%(domain)s/grafana/",
+				"kibana.service.type":            "LoadBalancer",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			config.BasicInfo.Host = test.host
+			config.BasicInfo.Exposure = test.exposure
+			config.InstallPrometheus = true
+			config.InstallLogging = true
+			plan := NewPlan(config)
+
+			for key, want := range test.envs {
+				if value, _ := envValue(plan, key); value != want {
+					t.Errorf("%s = %q, want %q", key, value, want)
+				}
+			}
+			values := map[string]map[string]interface{}{
+				"grafana": renderValues(t, plan, prometheusValues),
+				"kibana":  renderValues(t, plan, elasticsearchValues),
+			}
+			for path, want := range test.values {
+				file, _, _ := strings.Cut(path, ".")
+				if value := valueAt(values[file], path); !reflect.DeepEqual(value, want) {
+					t.Errorf("%s = %#v, want %#v", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebUiUrls(t *testing.T) {
+	grafana := webUi{Id: "grafana", Port: 80, NodePort: 30300}
+	kibana := webUi{Id: "kibana", Port: 5601, NodePort: 30561}
+	tests := []struct {
+		name     string
+		host     string
+		exposure string
+		https    bool
+		ui       webUi
+		want     string
+	}{
+		{"ingress with host", "cluster.example.com", Exposures.IngressHost, false, grafana,
+			"http://cluster.example.com/grafana/"},
+		{"ingress with host over https", "cluster.example.com", Exposures.IngressHost, true, kibana,
+			"https://cluster.example.com/kibana/"},
+		{"ingress by path", "192.168.1.10", Exposures.IngressPath, false, kibana, "http://192.168.1.10/kibana/"},
+		{"node port", "192.168.1.10", Exposures.NodePort, false, kibana, "http://192.168.1.10:30561/kibana/"},
+		{"node port of an IPv6 address", "fd00::10", Exposures.NodePort, false, grafana,
+			"http://[fd00::10]:30300/grafana/"},
+		{"load balancer on port 80", "192.168.1.10", Exposures.LoadBalancer, false, grafana,
+			"http://203.0.113.5/grafana/"},
+		{"load balancer", "192.168.1.10", Exposures.LoadBalancer, false, kibana, "http://203.0.113.5:5601/kibana/"},
+	}
+	for _, test := range tests {
+		info := BasicInfo{Host: test.host, Exposure: test.exposure, HttpsEnabled: test.https}
+		if url := info.url(test.ui, "203.0.113.5"); url != test.want {
+			t.Errorf("%s: url = %s, want %s", test.name, url, test.want)
+		}
+	}
+}
+
+func TestValidateExposure(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(info *BasicInfo)
+		err       string
+	}{
+		{"ingress with host", func(info *BasicInfo) {}, ""},
+		{"ingress with the host of an IP address", func(info *BasicInfo) {
+			info.Host = "192.168.1.10"
+		}, "192.168.1.10 is an IP address, please expose the UIs by path, NodePort or LoadBalancer."},
+		{"ingress by path of an IP address", func(info *BasicInfo) {
+			info.Host = "192.168.1.10"
+			info.Exposure = Exposures.IngressPath
+		}, ""},
+		{"node port", func(info *BasicInfo) {
+			info.Exposure = Exposures.NodePort
+		}, ""},
+		{"node port out of range", func(info *BasicInfo) {
+			info.Exposure = Exposures.NodePort
+			info.KibanaNodePort = 8080
+		}, "Node port 8080 is out of range 30000-32767."},
+		{"same node ports", func(info *BasicInfo) {
+			info.Exposure = Exposures.NodePort
+			info.KibanaNodePort = info.GrafanaNodePort
+		}, "Grafana and Kibana node ports are the same."},
+		{"https without an ingress", func(info *BasicInfo) {
+			info.Exposure = Exposures.LoadBalancer
+			info.HttpsEnabled = true
+		}, "Https is only available when the UIs are exposed by ingress with host."},
+		{"no exposure", func(info *BasicInfo) {
+			info.Exposure = ""
+		}, "Please select how to expose the UIs."},
+	}
+	for _, test := range tests {
+		info := NewConfig().BasicInfo
+		info.Host = "cluster.example.com"
+		info.Timezone = "UTC"
+		test.configure(&info)
+		err := info.Validate()
+		if err == nil && test.err != "" || err != nil && err.Error() != test.err {
+			t.Errorf("%s: Validate() = %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestSubdomainRoutingPlan(t *testing.T) {
+	tests := []struct {
+		name       string
+		exposure   string
+		certMethod string
+		envs       map[string]string
+		values     map[string]interface{}
+	}{
+		{
+			name:       "certificates from cert-manager",
+			exposure:   Exposures.IngressHost,
+			certMethod: CertMethods.CertManager,
+			envs: map[string]string{
+				"IDO_GRAFANA_INGRESS_HOSTNAME":    "grafana.cluster.example.com",
+				"IDO_GRAFANA_INGRESS_PATH":        "/",
+				"IDO_GRAFANA_SERVE_FROM_SUB_PATH": "false",
+				"IDO_GRAFANA_ROOT_URL":            "https://grafana.cluster.example.com/",
+				"IDO_GRAFANA_TLS_HOST":            "grafana.cluster.example.com",
+				"IDO_GRAFANA_TLS_SECRET":          "grafana.cluster.example.com",
+				"IDO_KIBANA_INGRESS_HOSTNAME":     "kibana.cluster.example.com",
+				"IDO_KIBANA_INGRESS_PATH":         "/",
+				"IDO_KIBANA_BASE_PATH":            "",
+				"IDO_KIBANA_REWRITE_BASE_PATH":    "false",
+				"IDO_KIBANA_TLS_SECRET":           "kibana.cluster.example.com",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.hosts": []interface{}{"grafana.cluster.example.com"},
+				"grafana.ingress.path":  "/",
+				"grafana.ingress.tls": []interface{}{map[string]interface{}{
+					"secretName": "grafana.cluster.example.com",
+					"hosts":      []interface{}{"grafana.cluster.example.com"},
+				}},
+				"grafana.env.GF_SERVER_SERVE_FROM_SUB_PATH":   "false",
+				"kibana.ingress.hostname":                     "kibana.cluster.example.com",
+				"kibana.ingress.path":                         "/",
+				"kibana.ingress.secretName":                   "kibana.cluster.example.com",
+				"kibana.configuration.server.basePath":        "",
+				"kibana.configuration.server.rewriteBasePath": false,
+			},
+		},
+		{
+			name:       "internal certificate",
+			exposure:   Exposures.IngressHost,
+			certMethod: CertMethods.InternalCa,
+			envs: map[string]string{
+				"IDO_GRAFANA_INGRESS_HOSTNAME": "grafana.cluster.example.com",
+				"IDO_GRAFANA_ROOT_URL":         "https://grafana.cluster.example.com/",
+				"IDO_GRAFANA_TLS_HOST":         "grafana.cluster.example.com",
+				"IDO_GRAFANA_TLS_SECRET":       TlsSecretName,
+				"IDO_KIBANA_TLS_HOST":          "kibana.cluster.example.com",
+				"IDO_KIBANA_TLS_SECRET":        TlsSecretName,
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.tls": []interface{}{map[string]interface{}{
+					"secretName": TlsSecretName,
+					"hosts":      []interface{}{"grafana.cluster.example.com"},
+				}},
+				"kibana.ingress.secretName": TlsSecretName,
+			},
+		},
+		{
+			// Without hostnames of their own, the UIs keep their path prefix
+			name:     "ingress by path",
+			exposure: Exposures.IngressPath,
+			envs: map[string]string{
+				"IDO_GRAFANA_INGRESS_HOSTNAME":    "",
+				"IDO_GRAFANA_INGRESS_PATH":        "/grafana",
+				"IDO_GRAFANA_SERVE_FROM_SUB_PATH": "true",
+				"IDO_GRAFANA_ROOT_URL":            "http://cluster.example.com/grafana/",
+				"IDO_KIBANA_INGRESS_HOSTNAME":     "*",
+				"IDO_KIBANA_BASE_PATH":            "/kibana",
+				"IDO_KIBANA_REWRITE_BASE_PATH":    "true",
+			},
+			values: map[string]interface{}{
+				"grafana.ingress.path":                        "/grafana",
+				"kibana.configuration.server.basePath":        "/kibana",
+				"kibana.configuration.server.rewriteBasePath": true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			config.BasicInfo.Host = "cluster.example.com"
+			config.BasicInfo.Exposure = test.exposure
+			config.BasicInfo.SubdomainRouting = true
+			if test.certMethod != "" {
+				config.BasicInfo.HttpsEnabled = true
+				config.BasicInfo.TlsCert.CertMethod = test.certMethod
+			}
+			config.InstallPrometheus = true
+			config.InstallLogging = true
+			plan := NewPlan(config)
+
+			for key, want := range test.envs {
+				if value, _ := envValue(plan, key); value != want {
+					t.Errorf("%s = %q, want %q", key, value, want)
+				}
+			}
+			values := map[string]map[string]interface{}{
+				"grafana": renderValues(t, plan, prometheusValues),
+				"kibana":  renderValues(t, plan, elasticsearchValues),
+			}
+			for path, want := range test.values {
+				file, _, _ := strings.Cut(path, ".")
+				if value := valueAt(values[file], path); !reflect.DeepEqual(value, want) {
+					t.Errorf("%s = %#v, want %#v", path, value, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSubdomainRoutingCertificates(t *testing.T) {
+	config := NewConfig()
+	config.BasicInfo.Host = "cluster.example.com"
+	config.BasicInfo.SubdomainRouting = true
+	config.BasicInfo.HttpsEnabled = true
+	config.BasicInfo.TlsCert.CertMethod = CertMethods.InternalCa
+	config.InstallPrometheus = true
+	want := []string{"cluster.example.com", "grafana.cluster.example.com", "kibana.cluster.example.com"}
+	if hostnames := config.BasicInfo.Hostnames(); !reflect.DeepEqual(hostnames, want) {
+		t.Errorf("Hostnames() = %q, want %q", hostnames, want)
+	}
+
+	// The internal certificate covers the hostname of every UI
+	dir := t.TempDir()
+	task := planTask(t, NewPlan(config), "Issue Internal Certificates")
+	err := task.Func(context.Background(), dir, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, InternalTlsCertFile)
+	keyFile := filepath.Join(dir, InternalTlsKeyFile)
+	err = ValidateCertificateFiles(certFile, keyFile, want, "")
+	if err != nil {
+		t.Errorf("internal certificate: %v", err)
+	}
+
+	// Certificate files must cover them too
+	caDir := t.TempDir()
+	caCert, caKey, err := createInternalCa(caDir, 365)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = issueServerCertificate(caDir, caCert, caKey, []string{"cluster.example.com", "grafana.cluster.example.com"},
+		30*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := config.BasicInfo
+	info.Timezone = "UTC"
+	info.TlsCert.CertMethod = CertMethods.CertificateFiles
+	info.TlsCert.CertFile = filepath.Join(caDir, "tls.crt")
+	info.TlsCert.KeyFile = filepath.Join(caDir, "tls.key")
+	err = info.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "Certificate doesn't cover kibana.cluster.example.com") {
+		t.Errorf("Validate() = %v, want kibana.cluster.example.com not covered", err)
+	}
+}
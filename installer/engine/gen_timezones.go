@@ -0,0 +1,49 @@
+//go:build ignore
+
+// gen_timezones writes timezones_embedded.go with the zones of the tz database embedded by
+// time/tzdata, which is the zoneinfo.zip of the Go distribution.
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"go/format"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+)
+
+func main() {
+	archive, err := zip.OpenReader(filepath.Join(runtime.GOROOT(), "lib", "time", "zoneinfo.zip"))
+	if err != nil {
+		panic(err)
+	}
+	defer archive.Close()
+
+	var zones []string
+	for _, file := range archive.File {
+		// Zone names start with an upper case letter
+		if name := file.Name; name[0] >= 'A' && name[0] <= 'Z' && !file.FileInfo().IsDir() {
+			zones = append(zones, name)
+		}
+	}
+	sort.Strings(zones)
+
+	var source bytes.Buffer
+	source.WriteString("// Code generated by gen_timezones.go; DO NOT EDIT.\n\npackage engine\n\n" +
+		"// embeddedTimezones are the zones of time/tzdata.\nvar embeddedTimezones = []string{\n")
+	for _, zone := range zones {
+		source.WriteString("\t" + strconv.Quote(zone) + ",\n")
+	}
+	source.WriteString("}\n")
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	err = os.WriteFile("timezones_embedded.go", formatted, 0644)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,244 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// RunRecord is what is kept of a run once the app quits.
+type RunRecord struct {
+	StartTime  time.Time    `json:"startTime"`
+	DurationMs int64        `json:"durationMs"`
+	Success    bool         `json:"success"`
+	Error      string       `json:"error,omitempty"`
+	LogPath    string       `json:"logPath"`
+	Config     Config       `json:"config"`
+	Tasks      []TaskRecord `json:"tasks"`
+}
+
+type TaskRecord struct {
+	Name       string `json:"name"`
+	ExitCode   int    `json:"exitCode"`
+	DurationMs int64  `json:"durationMs"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+}
+
+func NewTaskRecord(result TaskResult) TaskRecord {
+	record := TaskRecord{
+		Name:       result.Task.Name,
+		ExitCode:   result.ExitCode,
+		DurationMs: result.Duration.Milliseconds(),
+		Success:    result.Err == nil,
+	}
+	if result.Err != nil {
+		record.Error = result.Err.Error()
+	}
+	return record
+}
+
+func (record *RunRecord) Finish(err error, duration time.Duration) {
+	record.DurationMs = duration.Milliseconds()
+	record.Success = err == nil
+	if err != nil {
+		record.Error = err.Error()
+	}
+}
+
+func (record *RunRecord) Duration() time.Duration {
+	return time.Duration(record.DurationMs) * time.Millisecond
+}
+
+// SaveRunRecord writes record as a JSON file into dir.
+func SaveRunRecord(dir string, record *RunRecord) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	// A record never overwrites the one of a run started in the same second
+	file, err := os.OpenFile(newTimestampedPath(dir, "install-", record.StartTime, ".json"),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadRunRecords reads the records saved in dir, the newest first. A missing dir means no record.
+func LoadRunRecords(dir string) ([]RunRecord, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var records []RunRecord
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var record RunRecord
+		if err = json.Unmarshal(content, &record); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartTime.After(records[j].StartTime)
+	})
+	return records, nil
+}
+
+// Redacted returns a copy of config without passwords and secrets, safe to be persisted.
+func (config *Config) Redacted() Config {
+	redacted := *config
+
+	redacted.RegistryMirrors = append([]RegistryMirror(nil), config.RegistryMirrors...)
+	for index := range redacted.RegistryMirrors {
+		redacted.RegistryMirrors[index].redact()
+	}
+
+	redacted.Prometheus.Receivers = append([]AlertReceiver(nil), config.Prometheus.Receivers...)
+	for index := range redacted.Prometheus.Receivers {
+		redacted.Prometheus.Receivers[index].redact()
+	}
+
+	redacted.BasicInfo.TlsCert.Acme.redact()
+	redacted.BasicInfo.AccessControl.redact()
+	redacted.BasicInfo.Proxy.redact()
+	redacted.Notification.DingTalkRobotUrl = redactUrl(config.Notification.DingTalkRobotUrl)
+	redacted.Notification.WebhookUrl = redactUrl(config.Notification.WebhookUrl)
+	redacted.Notification.DingTalkSecret = redact(config.Notification.DingTalkSecret)
+	redacted.Notification.WebhookSecret = redact(config.Notification.WebhookSecret)
+	redacted.Notification.SmtpPassword = redact(config.Notification.SmtpPassword)
+	return redacted
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "******"
+}
+
+// sensitiveQueryKeys are the query parameters of URLs carrying credentials, like the
+// access_token of the DingTalk robots and the key of the WeCom ones.
+var sensitiveQueryKeys = []string{"access_token", "token", "key", "sign"}
+
+// redactUrl hides the user info and the sensitive query parameters of rawUrl.
+func redactUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return redact(rawUrl)
+	}
+	user := parsed.User
+	parsed.User = nil
+	// The other parameters keep their order and encoding
+	parameters := strings.Split(parsed.RawQuery, "&")
+	for index, parameter := range parameters {
+		key, value, found := strings.Cut(parameter, "=")
+		name, err := url.QueryUnescape(key)
+		if err != nil {
+			name = key
+		}
+		if found && value != "" && slices.Contains(sensitiveQueryKeys, strings.ToLower(name)) {
+			parameters[index] = key + "=" + redact(value)
+		}
+	}
+	parsed.RawQuery = strings.Join(parameters, "&")
+	if user != nil {
+		// url escapes the asterisks of a user
+		return strings.Replace(parsed.String(), "//", "//"+redact(user.String())+"@", 1)
+	}
+	return parsed.String()
+}
+
+// DiffConfigs compares two configs setting by setting and returns one line per difference.
+func DiffConfigs(old *Config, new *Config) ([]string, error) {
+	oldSettings, err := flattenConfig(old)
+	if err != nil {
+		return nil, err
+	}
+	newSettings, err := flattenConfig(new)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]bool{}
+	for k := range oldSettings {
+		keys[k] = true
+	}
+	for k := range newSettings {
+		keys[k] = true
+	}
+	var sortedKeys []string
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var diff []string
+	for _, k := range sortedKeys {
+		oldValue, inOld := oldSettings[k]
+		newValue, inNew := newSettings[k]
+		switch {
+		case !inOld:
+			diff = append(diff, "+ "+k+": "+newValue)
+		case !inNew:
+			diff = append(diff, "- "+k+": "+oldValue)
+		case oldValue != newValue:
+			diff = append(diff, "~ "+k+": "+oldValue+" -> "+newValue)
+		}
+	}
+	return diff, nil
+}
+
+func flattenConfig(config *Config) (map[string]string, error) {
+	content, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var tree interface{}
+	if err = json.Unmarshal(content, &tree); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]string{}
+	flatten("", tree, settings)
+	return settings, nil
+}
+
+func flatten(prefix string, node interface{}, settings map[string]string) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			flatten(strings.TrimPrefix(prefix+"."+k, "."), v, settings)
+		}
+	case []interface{}:
+		for i, v := range value {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), v, settings)
+		}
+	case nil:
+	default:
+		content, _ := json.Marshal(value)
+		settings[prefix] = string(content)
+	}
+}
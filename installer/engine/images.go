@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+)
+
+// Packages are the names of the packages, in the order they are installed.
+var Packages = []string{"ingress-nginx", "cert-manager", "oauth2-proxy", "local-path-provisioner",
+	"nfs-provisioner", "prometheus", "logging", "fluent-bit-to-alertmanager"}
+
+// packageImages are the images of the packages, by their upstream registry. Keep them in sync
+// with the charts and the values files when upgrading a package.
+var packageImages = map[string][]string{
+	"ingress-nginx": {
+		"registry.k8s.io/ingress-nginx/controller:v1.9.4",
+		"registry.k8s.io/ingress-nginx/kube-webhook-certgen:v20231011-8b53cabe0",
+	},
+	"cert-manager": {
+		"quay.io/jetstack/cert-manager-controller:v1.13.2",
+		"quay.io/jetstack/cert-manager-webhook:v1.13.2",
+		"quay.io/jetstack/cert-manager-cainjector:v1.13.2",
+		"quay.io/jetstack/cert-manager-acmesolver:v1.13.2",
+		"quay.io/jetstack/cert-manager-ctl:v1.13.2",
+	},
+	"oauth2-proxy": {
+		"quay.io/oauth2-proxy/oauth2-proxy:v7.5.1",
+	},
+	"local-path-provisioner": {
+		"docker.io/rancher/local-path-provisioner:v0.0.28",
+		"docker.io/library/busybox:latest",
+	},
+	"nfs-provisioner": {
+		"registry.k8s.io/sig-storage/nfs-subdir-external-provisioner:v4.0.2",
+	},
+	"prometheus": {
+		"quay.io/prometheus-operator/prometheus-operator:v0.66.0",
+		"quay.io/prometheus-operator/prometheus-config-reloader:v0.66.0",
+		"registry.k8s.io/ingress-nginx/kube-webhook-certgen:v20221220-controller-v1.5.1-58-g787ea74b6",
+		"quay.io/prometheus/prometheus:v2.45.0",
+		"quay.io/prometheus/alertmanager:v0.25.0",
+		"quay.io/prometheus/node-exporter:v1.6.0",
+		"registry.k8s.io/kube-state-metrics/kube-state-metrics:v2.9.2",
+		"docker.io/grafana/grafana:10.0.2",
+		"quay.io/kiwigrid/k8s-sidecar:1.24.6",
+		"docker.io/library/busybox:1.31.1",
+		"docker.io/timonwong/prometheus-webhook-dingtalk:v2.1.0",
+	},
+	"logging": {
+		"docker.io/bitnami/elasticsearch:8.14.3-debian-12-r4",
+		"docker.io/bitnami/os-shell:12-debian-12-r26",
+		"docker.io/bitnami/kibana:8.14.3-debian-12-r2",
+		"cr.fluentbit.io/fluent/fluent-bit:3.1.4",
+	},
+	"fluent-bit-to-alertmanager": {
+		"docker.io/xinnj/fluent-bit-to-alertmanager:1.1.0",
+	},
+}
+
+// SelectedPackages returns the names of the packages installed by config.
+func (config *Config) SelectedPackages() []string {
+	basicInfo := &config.BasicInfo
+	selected := map[string]bool{
+		"ingress-nginx": config.InstallIngressNginx,
+		"cert-manager":  basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertManager,
+		"oauth2-proxy": basicInfo.AccessControl.Method == AccessControlMethods.OAuth2Proxy &&
+			len(config.IngressNamespaces()) > 0,
+		"local-path-provisioner":     config.InstallLocalPathProvisioner,
+		"nfs-provisioner":            config.InstallNfsProvisioner,
+		"prometheus":                 config.InstallPrometheus,
+		"logging":                    config.InstallLogging,
+		"fluent-bit-to-alertmanager": config.InstallLogging && config.Logging.ErrorLogAlert,
+	}
+	var packages []string
+	for _, name := range Packages {
+		if selected[name] {
+			packages = append(packages, name)
+		}
+	}
+	return packages
+}
+
+// PackageImages returns the images of packages, each one once.
+func PackageImages(packages []string) ([]string, error) {
+	var images []string
+	seen := map[string]bool{}
+	for _, name := range packages {
+		list, ok := packageImages[name]
+		if !ok {
+			return nil, errors.New("Unknown package " + name + ", the packages are " + strings.Join(Packages, ", ") + ".")
+		}
+		for _, image := range list {
+			if !seen[image] {
+				seen[image] = true
+				images = append(images, image)
+			}
+		}
+	}
+	return images, nil
+}
+
+// Images returns the images pulled by the packages selected in config, each one once.
+func (config *Config) Images() []string {
+	images, _ := PackageImages(config.SelectedPackages())
+	return images
+}
+
+// splitImage splits image into its registry, repository and tag.
+func splitImage(image string) (registry string, repository string, tag string) {
+	registry, repository, _ = strings.Cut(image, "/")
+	tag = "latest"
+	if index := strings.LastIndex(repository, ":"); index >= 0 {
+		repository, tag = repository[:index], repository[index+1:]
+	}
+	return registry, repository, tag
+}
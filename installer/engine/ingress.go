@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+type IngressController struct {
+	Nginx          string
+	Traefik        string
+	HaproxyIngress string
+	HaproxyTech    string
+	Other          string
+}
+
+var IngressControllers = IngressController{
+	Nginx:          "nginx",
+	Traefik:        "traefik",
+	HaproxyIngress: "haproxy-ingress",
+	HaproxyTech:    "haproxy",
+	Other:          "other",
+}
+
+// IngressNginxClass is the IngressClass created by the bundled ingress-nginx package.
+const IngressNginxClass = "nginx"
+
+// IngressClass is an IngressClass of the cluster.
+type IngressClass struct {
+	Name       string
+	Controller string
+	Default    bool
+}
+
+// GetIngressClasses returns the IngressClasses of the cluster, with the kind of their controller.
+func GetIngressClasses() ([]IngressClass, error) {
+	result, err := ExecCommand("kubectl get ingressclass --no-headers -o custom-columns="+
+		"\":metadata.name,:spec.controller,:metadata.annotations.ingressclass\\.kubernetes\\.io/is-default-class\"", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var classes []IngressClass
+	for _, line := range strings.Split(strings.TrimSpace(string(result)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		classes = append(classes, IngressClass{
+			Name:       fields[0],
+			Controller: IngressControllerOf(fields[1]),
+			Default:    fields[2] == "true",
+		})
+	}
+	return classes, nil
+}
+
+// IngressControllerOf maps the spec.controller of an IngressClass to the kind of ingress controller.
+func IngressControllerOf(controller string) string {
+	switch {
+	case controller == "k8s.io/ingress-nginx":
+		return IngressControllers.Nginx
+	case controller == "traefik.io/ingress-controller":
+		return IngressControllers.Traefik
+	case controller == "haproxy-ingress.github.io/controller":
+		return IngressControllers.HaproxyIngress
+	case strings.HasPrefix(controller, "haproxy.org/ingress-controller"):
+		return IngressControllers.HaproxyTech
+	}
+	return IngressControllers.Other
+}
+
+// ParseBodySize parses a size like "50m" into bytes. The suffixes k, m and g are powers of 1024.
+func ParseBodySize(size string) (int64, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(size, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(size, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(size, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+
+	value, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, errors.New("Max request body size is empty or format is wrong.")
+	}
+	return value * multiplier, nil
+}
+
+// ingressAnnotations returns the annotations of the ingresses in namespace, indented to be
+// substituted in the annotations block of the values files. protected ingresses get the access control.
+func (info *BasicInfo) ingressAnnotations(namespace string, tlsAcme bool, protected bool) string {
+	forceSslRedirect := strconv.FormatBool(info.HttpsEnabled && info.TlsCert.ForceSslRedirect)
+	annotations := [][2]string{
+		{"kubernetes.io/tls-acme", strconv.FormatBool(tlsAcme)},
+	}
+
+	switch info.IngressController {
+	case IngressControllers.Nginx:
+		annotations = append(annotations,
+			[2]string{"nginx.ingress.kubernetes.io/force-ssl-redirect", forceSslRedirect},
+			[2]string{"nginx.ingress.kubernetes.io/proxy-body-size", info.MaxBodySize})
+	case IngressControllers.Traefik:
+		// Traefik configures both with middlewares, created in every namespace by packages/ingress
+		middlewares := []string{namespace + "-om-kits-body-size@kubernetescrd"}
+		if forceSslRedirect == "true" {
+			middlewares = append(middlewares, namespace+"-om-kits-redirect-https@kubernetescrd")
+		}
+		if protected && info.AccessControl.Method == AccessControlMethods.BasicAuth {
+			middlewares = append(middlewares, namespace+"-"+BasicAuthSecret+"@kubernetescrd")
+		}
+		annotations = append(annotations,
+			[2]string{"traefik.ingress.kubernetes.io/router.middlewares", strings.Join(middlewares, ",")})
+	case IngressControllers.HaproxyIngress:
+		annotations = append(annotations,
+			[2]string{"haproxy-ingress.github.io/ssl-redirect", forceSslRedirect},
+			[2]string{"haproxy-ingress.github.io/proxy-body-size", info.MaxBodySize})
+	case IngressControllers.HaproxyTech:
+		// The controller has no body size annotation, the backend rejects larger requests itself
+		maxBodyBytes, _ := ParseBodySize(info.MaxBodySize)
+		annotations = append(annotations,
+			[2]string{"haproxy.org/ssl-redirect", forceSslRedirect},
+			[2]string{"haproxy.org/backend-config-snippet",
+				"http-request deny deny_status 413 if { req.hdr_val(content-length) gt " +
+					strconv.FormatInt(maxBodyBytes, 10) + " }"})
+	}
+	if protected && info.IngressController != IngressControllers.Traefik {
+		annotations = append(annotations, info.authAnnotations(namespace)...)
+	}
+
+	annotationsString := ""
+	for _, annotation := range annotations {
+		annotationsString = annotationsString + "      " + annotation[0] + ": \"" + annotation[1] + "\"\n"
+	}
+	return annotationsString
+}
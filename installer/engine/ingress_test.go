@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBodySize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		valid bool
+	}{
+		{"50m", 50 << 20, true},
+		{"512K", 512 << 10, true},
+		{"1g", 1 << 30, true},
+		{"1024", 1024, true},
+		{"", 0, false},
+		{"0m", 0, false},
+		{"ten", 0, false},
+	}
+	for _, test := range tests {
+		bytes, err := ParseBodySize(test.size)
+		if (err == nil) != test.valid || bytes != test.bytes {
+			t.Errorf("ParseBodySize(%q) = %d, %v, want %d", test.size, bytes, err, test.bytes)
+		}
+	}
+}
+
+func TestIngressAnnotationsLimitTheBodySize(t *testing.T) {
+	tests := []struct {
+		controller string
+		annotation string
+	}{
+		{IngressControllers.Nginx, `nginx.ingress.kubernetes.io/proxy-body-size: "50m"`},
+		{IngressControllers.Traefik, `monitoring-om-kits-body-size@kubernetescrd`},
+		{IngressControllers.HaproxyIngress, `haproxy-ingress.github.io/proxy-body-size: "50m"`},
+		{IngressControllers.HaproxyTech, `haproxy.org/backend-config-snippet: ` +
+			`"http-request deny deny_status 413 if { req.hdr_val(content-length) gt 52428800 }"`},
+	}
+	for _, test := range tests {
+		t.Run(test.controller, func(t *testing.T) {
+			info := NewConfig().BasicInfo
+			info.IngressController = test.controller
+			info.MaxBodySize = "50m"
+			annotations := info.ingressAnnotations("monitoring", false, false)
+			if !strings.Contains(annotations, test.annotation) {
+				t.Errorf("annotations lack %s:\n%s", test.annotation, annotations)
+			}
+		})
+	}
+}
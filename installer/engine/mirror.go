@@ -0,0 +1,347 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RegistryMirror is the registry the images of a source registry are pulled from. Username
+// and Password log in to the mirror, Password holds the token of registries using tokens.
+type RegistryMirror struct {
+	Source   string
+	Mirror   string
+	Username string
+	Password string
+}
+
+// MirrorPreset is a named list of mirrors offered on the Mirror page. Hint tells how to fill in
+// the mirrors the preset leaves empty.
+type MirrorPreset struct {
+	Name    string
+	Mirrors []RegistryMirror
+	Hint    string
+}
+
+const (
+	// RegistryPullSecret is the dockerconfigjson secret with the logins of the mirrors in every
+	// namespace the packages are installed to.
+	RegistryPullSecret = "om-kits-registry"
+	// RegistryAuthFile is the docker config generated in the working directory of the executor.
+	RegistryAuthFile = "registry/config.json"
+)
+
+// MirrorPresetsFile is the file next to the installer with additional presets, like
+//
+//	[{"name": "Our Harbor", "mirrors": {"docker.io": "harbor.example.com/dockerhub"}}]
+const MirrorPresetsFile = "mirror-presets.json"
+
+// mirrorEnvNames are the environment variables of the source registries the values files of
+// the packages refer to. They are always set, to the source registry when it has no mirror.
+var mirrorEnvNames = map[string]string{
+	"docker.io":       "IDO_DOCKER_CONTAINER_MIRROR",
+	"quay.io":         "IDO_QUAY_CONTAINER_MIRROR",
+	"registry.k8s.io": "IDO_K8S_CONTAINER_MIRROR",
+	"k8s.gcr.io":      "IDO_GCR_CONTAINER_MIRROR",
+}
+
+var (
+	registryPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?$`)
+	mirrorPattern   = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?(:[0-9]+)?(/[a-z0-9._/-]+)?$`)
+	envNamePattern  = regexp.MustCompile(`[^A-Z0-9]+`)
+)
+
+// BuiltinMirrorPresets are the presets shipped with the installer. DaoCloud has no host for
+// cr.fluentbit.io and mirrors it under the path of m.daocloud.io. Aliyun and Tencent Cloud only
+// mirror Docker Hub, Aliyun with an accelerator address of your own account, left to be entered.
+var BuiltinMirrorPresets = []MirrorPreset{
+	{Name: "DaoCloud", Mirrors: []RegistryMirror{
+		{Source: "cr.fluentbit.io", Mirror: "m.daocloud.io/cr.fluentbit.io"},
+		{Source: "docker.io", Mirror: "docker.m.daocloud.io"},
+		{Source: "docker.elastic.co", Mirror: "elastic.m.daocloud.io"},
+		{Source: "gcr.io", Mirror: "gcr.m.daocloud.io"},
+		{Source: "ghcr.io", Mirror: "ghcr.m.daocloud.io"},
+		{Source: "k8s.gcr.io", Mirror: "k8s-gcr.m.daocloud.io"},
+		{Source: "quay.io", Mirror: "quay.m.daocloud.io"},
+		{Source: "registry.k8s.io", Mirror: "k8s.m.daocloud.io"},
+	}},
+	{Name: "Aliyun", Mirrors: []RegistryMirror{
+		{Source: "docker.io"},
+	}, Hint: "Please enter the accelerator address of your Aliyun account as the mirror of docker.io, " +
+		"like xxxxxxxx.mirror.aliyuncs.com, found under Container Registry > Image Tools > Image Accelerator."},
+	{Name: "Tencent Cloud", Mirrors: []RegistryMirror{
+		{Source: "docker.io", Mirror: "mirror.ccs.tencentyun.com"},
+	}},
+	{Name: "Internal Harbor", Mirrors: []RegistryMirror{
+		{Source: "cr.fluentbit.io", Mirror: "harbor.example.com/fluentbit"},
+		{Source: "docker.io", Mirror: "harbor.example.com/dockerhub"},
+		{Source: "docker.elastic.co", Mirror: "harbor.example.com/elastic"},
+		{Source: "gcr.io", Mirror: "harbor.example.com/gcr"},
+		{Source: "ghcr.io", Mirror: "harbor.example.com/ghcr"},
+		{Source: "k8s.gcr.io", Mirror: "harbor.example.com/k8s-gcr"},
+		{Source: "quay.io", Mirror: "harbor.example.com/quay"},
+		{Source: "registry.k8s.io", Mirror: "harbor.example.com/k8s"},
+	}},
+}
+
+// DefaultMirrors returns the public download mirrors offered when the mirror is enabled.
+func DefaultMirrors() []RegistryMirror {
+	return append([]RegistryMirror{}, BuiltinMirrorPresets[0].Mirrors...)
+}
+
+// LoadMirrorPresets reads the presets of file, in the format of MirrorPresetsFile.
+func LoadMirrorPresets(file string) ([]MirrorPreset, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Name    string            `json:"name"`
+		Mirrors map[string]string `json:"mirrors"`
+	}
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		return nil, errors.New("Can't parse " + file + ": " + err.Error())
+	}
+
+	var presets []MirrorPreset
+	for _, entry := range entries {
+		if entry.Name == "" {
+			return nil, errors.New("A preset of " + file + " has no name.")
+		}
+		preset := MirrorPreset{Name: entry.Name}
+		for source, mirror := range entry.Mirrors {
+			preset.Mirrors = append(preset.Mirrors, RegistryMirror{Source: source, Mirror: mirror})
+		}
+		sortMirrors(preset.Mirrors)
+		presets = append(presets, preset)
+	}
+	return presets, nil
+}
+
+func sortMirrors(mirrors []RegistryMirror) {
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].Source < mirrors[j].Source
+	})
+}
+
+// ValidateMirrors checks the source registries and their mirrors when the mirror is enabled.
+func (config *Config) ValidateMirrors() error {
+	if !config.EnableMirror {
+		return nil
+	}
+	if len(config.RegistryMirrors) == 0 {
+		return errors.New("Please add a source registry and its mirror.")
+	}
+	sources := map[string]bool{}
+	for _, mirror := range config.RegistryMirrors {
+		if !registryPattern.MatchString(mirror.Source) {
+			return errors.New("Source registry " + mirror.Source + " isn't a registry host.")
+		}
+		if sources[mirror.Source] {
+			return errors.New("Source registry " + mirror.Source + " is added twice.")
+		}
+		sources[mirror.Source] = true
+		if mirror.Mirror == "" {
+			return errors.New("Mirror of " + mirror.Source + " is empty.")
+		}
+		if !mirrorPattern.MatchString(mirror.Mirror) {
+			return errors.New("Mirror of " + mirror.Source + " isn't a registry host with an optional path.")
+		}
+		if (mirror.Username == "") != (mirror.Password == "") {
+			return errors.New("Please enter both the username and the password or token of the mirror of " +
+				mirror.Source + ".")
+		}
+	}
+
+	// A pull secret holds one login per host
+	logins := map[string]RegistryMirror{}
+	for _, mirror := range config.RegistryMirrors {
+		host := mirror.host()
+		if login, ok := logins[host]; ok &&
+			(login.Username != mirror.Username || login.Password != mirror.Password) {
+			return errors.New("The mirrors of " + login.Source + " and " + mirror.Source + " are both on " + host +
+				", please use the same login for them.")
+		}
+		logins[host] = mirror
+	}
+	return nil
+}
+
+// host returns the registry host of the mirror, without its path.
+func (mirror *RegistryMirror) host() string {
+	host, _, _ := strings.Cut(mirror.Mirror, "/")
+	return host
+}
+
+func (mirror *RegistryMirror) redact() {
+	mirror.Password = redact(mirror.Password)
+}
+
+// mirrorLogin returns the login of the mirror on host, empty when there is none.
+func (config *Config) mirrorLogin(host string) (string, string) {
+	if config.EnableMirror {
+		for _, mirror := range config.RegistryMirrors {
+			if mirror.host() == host && mirror.Username != "" {
+				return mirror.Username, mirror.Password
+			}
+		}
+	}
+	return "", ""
+}
+
+// pullSecretNeeded reports whether a mirror needs a login, the packages then pull with
+// RegistryPullSecret.
+func (config *Config) pullSecretNeeded() bool {
+	for _, mirror := range config.RegistryMirrors {
+		if config.EnableMirror && mirror.Username != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// PullSecretNamespaces returns the namespaces the selected packages are installed to.
+func (config *Config) PullSecretNamespaces() []string {
+	basicInfo := &config.BasicInfo
+	var namespaces []string
+	if config.InstallIngressNginx {
+		namespaces = append(namespaces, "ingress-nginx")
+	}
+	if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertManager {
+		namespaces = append(namespaces, "cert-manager")
+	}
+	if basicInfo.AccessControl.Method == AccessControlMethods.OAuth2Proxy && len(config.IngressNamespaces()) > 0 {
+		namespaces = append(namespaces, OAuth2ProxyNamespace)
+	}
+	if config.InstallLocalPathProvisioner {
+		namespaces = append(namespaces, "local-path-storage")
+	}
+	if config.InstallNfsProvisioner {
+		namespaces = append(namespaces, "nfs-provisioner")
+	}
+	if config.InstallPrometheus {
+		namespaces = append(namespaces, "monitoring")
+	}
+	if config.InstallLogging {
+		namespaces = append(namespaces, "logging")
+	}
+	return namespaces
+}
+
+// WriteDockerConfig writes the docker config with the logins of mirrors to file, the content of
+// the dockerconfigjson pull secret.
+func WriteDockerConfig(file string, mirrors []RegistryMirror, output io.Writer) error {
+	type auth struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Auth     string `json:"auth"`
+	}
+	auths := map[string]auth{}
+	for _, mirror := range mirrors {
+		if mirror.Username != "" {
+			auths[mirror.host()] = auth{Username: mirror.Username, Password: mirror.Password,
+				Auth: basicCredentials(mirror.Username, mirror.Password)}
+		}
+	}
+	content, err := json.Marshal(map[string]interface{}{"auths": auths})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(file, content, 0600)
+	if err != nil {
+		return err
+	}
+	var hosts []string
+	for host := range auths {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	fmt.Fprintln(output, "Logins of "+strings.Join(hosts, ", ")+" written to "+file)
+	return nil
+}
+
+// pullSecretTasks returns the tasks creating RegistryPullSecret in the namespaces of the packages.
+func (config *Config) pullSecretTasks() []Task {
+	mirrors := append([]RegistryMirror(nil), config.RegistryMirrors...)
+	return []Task{
+		{Name: "Generate Registry Logins",
+			Func: func(ctx context.Context, dir string, output io.Writer) error {
+				return WriteDockerConfig(filepath.Join(dir, RegistryAuthFile), mirrors, output)
+			}},
+		{Name: "Create Registry Pull Secrets",
+			Command: "chmod +x packages/registry/install.sh; packages/registry/install.sh"},
+	}
+}
+
+// pullSecretEnvs returns the environment variables of packages/registry and the imagePullSecrets
+// of the values files.
+func (config *Config) pullSecretEnvs() []string {
+	imagePullSecrets := "[]"
+	if config.pullSecretNeeded() {
+		imagePullSecrets = "[{name: " + RegistryPullSecret + "}]"
+	}
+	return []string{
+		"IDO_IMAGE_PULL_SECRETS=" + imagePullSecrets,
+		"IDO_REGISTRY_PULL_SECRET=" + RegistryPullSecret,
+		"IDO_REGISTRY_AUTH_FILE=" + RegistryAuthFile,
+		"IDO_PULL_SECRET_NAMESPACES=" + strings.Join(config.PullSecretNamespaces(), " "),
+	}
+}
+
+// pullRegistry returns where the images of registry are pulled from, its mirror or itself, and
+// whether a mirror covers it.
+func (config *Config) pullRegistry(registry string) (string, bool) {
+	if config.EnableMirror {
+		for _, mirror := range config.RegistryMirrors {
+			if mirror.Source == registry && mirror.Mirror != "" {
+				return strings.TrimSuffix(mirror.Mirror, "/"), true
+			}
+		}
+	}
+	return registry, false
+}
+
+// mirrorEnvName returns the environment variable of the mirror of source, like
+// IDO_GHCR_IO_CONTAINER_MIRROR for ghcr.io.
+func mirrorEnvName(source string) string {
+	if name, ok := mirrorEnvNames[source]; ok {
+		return name
+	}
+	return "IDO_" + strings.Trim(envNamePattern.ReplaceAllString(strings.ToUpper(source), "_"), "_") +
+		"_CONTAINER_MIRROR"
+}
+
+// mirrorEnvs returns the environment variable of every source registry of the mirrors, and of
+// the ones the values files refer to.
+func (config *Config) mirrorEnvs() []string {
+	sources := map[string]bool{}
+	for source := range mirrorEnvNames {
+		sources[source] = true
+	}
+	if config.EnableMirror {
+		for _, mirror := range config.RegistryMirrors {
+			sources[mirror.Source] = true
+		}
+	}
+
+	var envs []string
+	for source := range sources {
+		pullFrom, _ := config.pullRegistry(source)
+		envs = append(envs, mirrorEnvName(source)+"="+pullFrom)
+	}
+	sort.Strings(envs)
+	return envs
+}
@@ -0,0 +1,23 @@
+package engine
+
+import "testing"
+
+func TestBuiltinMirrorPresets(t *testing.T) {
+	for _, preset := range BuiltinMirrorPresets {
+		config := NewConfig()
+		config.EnableMirror = true
+		config.RegistryMirrors = append([]RegistryMirror{}, preset.Mirrors...)
+		// The mirrors a preset leaves empty are entered as its hint tells
+		for i := range config.RegistryMirrors {
+			if config.RegistryMirrors[i].Mirror == "" {
+				if preset.Hint == "" {
+					t.Errorf("%s: mirror of %s is empty without a hint", preset.Name, config.RegistryMirrors[i].Source)
+				}
+				config.RegistryMirrors[i].Mirror = "0123abcd.mirror.aliyuncs.com"
+			}
+		}
+		if err := config.ValidateMirrors(); err != nil {
+			t.Errorf("%s: ValidateMirrors() = %v", preset.Name, err)
+		}
+	}
+}
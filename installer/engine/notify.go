@@ -0,0 +1,292 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultDingTalkRobotUrl = "https://oapi.dingtalk.com/robot/send?access_token="
+
+// NotificationConfig describes where the result of a run is sent to.
+type NotificationConfig struct {
+	DingTalkEnabled  bool
+	DingTalkRobotUrl string
+	DingTalkSecret   string
+
+	WebhookEnabled bool
+	WebhookUrl     string
+	WebhookSecret  string
+
+	EmailEnabled bool
+	SmtpHost     string
+	SmtpPort     int
+	SmtpUsername string
+	SmtpPassword string
+	EmailFrom    string
+	EmailTo      string
+}
+
+func (config *NotificationConfig) Validate() error {
+	if config.DingTalkEnabled {
+		if _, err := url.ParseRequestURI(config.DingTalkRobotUrl); err != nil ||
+			strings.HasSuffix(config.DingTalkRobotUrl, "access_token=") {
+			return errors.New("DingTalk robot URL is empty or format is wrong.")
+		}
+	}
+	if config.WebhookEnabled {
+		if _, err := url.ParseRequestURI(config.WebhookUrl); err != nil {
+			return errors.New("Webhook URL is empty or format is wrong.")
+		}
+	}
+	if config.EmailEnabled {
+		if config.SmtpHost == "" {
+			return errors.New("SMTP host is empty.")
+		}
+		if config.SmtpPort <= 0 || config.SmtpPort > 65535 {
+			return errors.New("SMTP port is out of range.")
+		}
+		if _, err := mail.ParseAddress(config.EmailFrom); err != nil {
+			return errors.New("Email sender is empty or format is wrong.")
+		}
+		if _, err := mail.ParseAddressList(config.EmailTo); err != nil {
+			return errors.New("Email recipients are empty or format is wrong.")
+		}
+	}
+	return nil
+}
+
+// RunSummary is the content of a notification.
+type RunSummary struct {
+	Host       string        `json:"host"`
+	Success    bool          `json:"success"`
+	Duration   time.Duration `json:"-"`
+	DurationMs int64         `json:"durationMs"`
+	FailedTask string        `json:"failedTask,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	LogPath    string        `json:"logPath"`
+	Urls       []string      `json:"urls,omitempty"`
+	Timestamp  int64         `json:"timestamp"`
+}
+
+func NewRunSummary(host string, err error, duration time.Duration, failedTask string, logPath string) RunSummary {
+	summary := RunSummary{
+		Host:       host,
+		Success:    err == nil,
+		Duration:   duration,
+		DurationMs: duration.Milliseconds(),
+		FailedTask: failedTask,
+		LogPath:    logPath,
+		Timestamp:  time.Now().Unix(),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	return summary
+}
+
+func (summary RunSummary) Title() string {
+	if summary.Success {
+		return "OM-Kits install on " + summary.Host + " succeeded"
+	}
+	return "OM-Kits install on " + summary.Host + " failed"
+}
+
+func (summary RunSummary) Text() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Duration: %s\n", summary.Duration.Round(time.Second))
+	if !summary.Success {
+		fmt.Fprintf(&text, "Failed task: %s\n", summary.FailedTask)
+		fmt.Fprintf(&text, "Error: %s\n", summary.Error)
+	}
+	for _, url := range summary.Urls {
+		fmt.Fprintf(&text, "%s\n", url)
+	}
+	fmt.Fprintf(&text, "Log: %s\n", summary.LogPath)
+	return text.String()
+}
+
+var notifyClient = &http.Client{Timeout: 10 * time.Second}
+
+const (
+	// NotifyTimeout bounds the notifications of a run, which an unreachable target would hold.
+	NotifyTimeout = 30 * time.Second
+	// smtpTimeout bounds the connection to the SMTP server, from the dial to the quit.
+	smtpTimeout = 20 * time.Second
+)
+
+// Notifier sends the summaries of the runs, posting to the robots and webhooks with Client.
+type Notifier struct {
+	Client *http.Client
+}
+
+// NewNotifier returns a notifier posting with the client of the installer, which trusts the CA
+// bundle and uses the proxy.
+func NewNotifier() *Notifier {
+	return &Notifier{Client: notifyClient}
+}
+
+// Notify sends summary to every enabled target with the notifier of the installer and returns
+// the errors of the failed ones.
+func Notify(ctx context.Context, config *NotificationConfig, summary RunSummary) []error {
+	return NewNotifier().Notify(ctx, config, summary)
+}
+
+// Notify sends summary to every enabled target, until ctx is done, and returns the errors of the
+// failed ones.
+func (notifier *Notifier) Notify(ctx context.Context, config *NotificationConfig, summary RunSummary) []error {
+	var errs []error
+
+	if config.DingTalkEnabled {
+		if err := notifier.sendDingTalk(ctx, config.DingTalkRobotUrl, config.DingTalkSecret, summary); err != nil {
+			errs = append(errs, fmt.Errorf("DingTalk: %w", err))
+		}
+	}
+	if config.WebhookEnabled {
+		if err := notifier.sendWebhook(ctx, config.WebhookUrl, config.WebhookSecret, summary); err != nil {
+			errs = append(errs, fmt.Errorf("Webhook: %w", err))
+		}
+	}
+	if config.EmailEnabled {
+		if err := sendEmail(ctx, config, summary); err != nil {
+			errs = append(errs, fmt.Errorf("Email: %w", err))
+		}
+	}
+	return errs
+}
+
+// SignDingTalk returns the sign parameter of a DingTalk robot with security settings "additional signature".
+func SignDingTalk(secret string, timestampMs int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestampMs, 10) + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (notifier *Notifier) sendDingTalk(ctx context.Context, robotUrl string, secret string, summary RunSummary) error {
+	if secret != "" {
+		timestampMs := time.Now().UnixMilli()
+		robotUrl += "&timestamp=" + strconv.FormatInt(timestampMs, 10) +
+			"&sign=" + url.QueryEscape(SignDingTalk(secret, timestampMs))
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": summary.Title(),
+			"text":  "### " + summary.Title() + "\n\n" + strings.ReplaceAll(summary.Text(), "\n", "\n\n"),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	response, err := notifier.postJson(ctx, robotUrl, body, nil)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err = json.Unmarshal(response, &result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return errors.New(result.ErrMsg)
+	}
+	return nil
+}
+
+// SignWebhook returns the hex encoded HMAC-SHA256 of body, sent in the X-OM-Kits-Signature header.
+func SignWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (notifier *Notifier) sendWebhook(ctx context.Context, webhookUrl string, secret string, summary RunSummary) error {
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	headers := map[string]string{}
+	if secret != "" {
+		headers["X-OM-Kits-Signature"] = "sha256=" + SignWebhook(secret, body)
+	}
+	_, err = notifier.postJson(ctx, webhookUrl, body, headers)
+	return err
+}
+
+func (notifier *Notifier) postJson(ctx context.Context, postUrl string, body []byte,
+	headers map[string]string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, postUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := notifier.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, errors.New(response.Status + ": " + string(responseBody))
+	}
+	return responseBody, nil
+}
+
+func sendEmail(ctx context.Context, config *NotificationConfig, summary RunSummary) error {
+	var recipients []string
+	addresses, err := mail.ParseAddressList(config.EmailTo)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		recipients = append(recipients, address.Address)
+	}
+
+	var message strings.Builder
+	message.WriteString("From: " + config.EmailFrom + "\r\n")
+	message.WriteString("To: " + config.EmailTo + "\r\n")
+	message.WriteString("Subject: " + summary.Title() + "\r\n")
+	message.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(summary.Text(), "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if config.SmtpUsername != "" {
+		auth = smtp.PlainAuth("", config.SmtpUsername, config.SmtpPassword, config.SmtpHost)
+	}
+
+	from, err := mail.ParseAddress(config.EmailFrom)
+	if err != nil {
+		return err
+	}
+	return sendMail(ctx, config.SmtpHost+":"+strconv.Itoa(config.SmtpPort), auth, from.Address, recipients,
+		[]byte(message.String()))
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationConfigValidate(t *testing.T) {
+	email := NotificationConfig{EmailEnabled: true, SmtpHost: "smtp.example.com", SmtpPort: 25,
+		EmailFrom: "installer@example.com", EmailTo: "ops@example.com"}
+	tests := []struct {
+		name   string
+		modify func(config *NotificationConfig)
+		valid  bool
+	}{
+		{"email", func(config *NotificationConfig) {}, true},
+		{"port 0", func(config *NotificationConfig) { config.SmtpPort = 0 }, false},
+		{"negative port", func(config *NotificationConfig) { config.SmtpPort = -25 }, false},
+		{"port above 65535", func(config *NotificationConfig) { config.SmtpPort = 65536 }, false},
+		{"port 65535", func(config *NotificationConfig) { config.SmtpPort = 65535 }, true},
+		{"no recipient", func(config *NotificationConfig) { config.EmailTo = "" }, false},
+		{"robot without token", func(config *NotificationConfig) {
+			config.DingTalkEnabled = true
+			config.DingTalkRobotUrl = DefaultDingTalkRobotUrl
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := email
+			test.modify(&config)
+			if err := config.Validate(); (err == nil) != test.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func sampleRunSummary() RunSummary {
+	return NewRunSummary("cluster.example.com", errors.New("exit status 1"), 90*time.Second,
+		"Install Prometheus", "/opt/om-kits/logs/install-1.log")
+}
+
+func TestNotifierDingTalk(t *testing.T) {
+	var errCode int
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		query := request.URL.Query()
+		timestamp, _ := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+		if query.Get("access_token") != "token" || query.Get("sign") != SignDingTalk("secret", timestamp) {
+			t.Errorf("robot called with %s", request.URL.RawQuery)
+		}
+		var message struct {
+			MsgType  string            `json:"msgtype"`
+			Markdown map[string]string `json:"markdown"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&message); err != nil {
+			t.Error(err)
+		}
+		if message.MsgType != "markdown" || !strings.Contains(message.Markdown["text"], "Failed task: Install Prometheus") {
+			t.Errorf("message = %+v", message)
+		}
+		writer.Write([]byte(`{"errcode":` + strconv.Itoa(errCode) + `,"errmsg":"keywords not in content"}`))
+	}))
+	defer server.Close()
+
+	config := &NotificationConfig{DingTalkEnabled: true, DingTalkRobotUrl: server.URL + "/robot/send?access_token=token",
+		DingTalkSecret: "secret"}
+	notifier := &Notifier{Client: server.Client()}
+	if errs := notifier.Notify(context.Background(), config, sampleRunSummary()); len(errs) > 0 {
+		t.Errorf("Notify() = %v", errs)
+	}
+	errCode = 310000
+	errs := notifier.Notify(context.Background(), config, sampleRunSummary())
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "keywords not in content") {
+		t.Errorf("Notify() = %v, want the error of the robot", errs)
+	}
+}
+
+func TestNotifierWebhook(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, _ := io.ReadAll(request.Body)
+		if request.Header.Get("X-OM-Kits-Signature") != "sha256="+SignWebhook("secret", body) {
+			t.Errorf("signature = %q", request.Header.Get("X-OM-Kits-Signature"))
+		}
+		var summary RunSummary
+		if err := json.Unmarshal(body, &summary); err != nil {
+			t.Error(err)
+		}
+		if summary.Success || summary.FailedTask != "Install Prometheus" || summary.DurationMs != 90000 {
+			t.Errorf("summary = %+v", summary)
+		}
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	config := &NotificationConfig{WebhookEnabled: true, WebhookUrl: server.URL + "/hook", WebhookSecret: "secret"}
+	notifier := &Notifier{Client: server.Client()}
+	if errs := notifier.Notify(context.Background(), config, sampleRunSummary()); len(errs) > 0 {
+		t.Errorf("Notify() = %v", errs)
+	}
+	status = http.StatusInternalServerError
+	if errs := notifier.Notify(context.Background(), config, sampleRunSummary()); len(errs) != 1 {
+		t.Errorf("Notify() = %v, want the error of the webhook", errs)
+	}
+}
+
+// serveSmtp accepts one SMTP session on listener without STARTTLS nor AUTH and sends the data of
+// the message to messages.
+func serveSmtp(listener net.Listener, messages chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(command, "DATA"):
+			reply("354 go ahead")
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestNotifierEmail(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	messages := make(chan string, 1)
+	go serveSmtp(listener, messages)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	config := &NotificationConfig{EmailEnabled: true, SmtpHost: "127.0.0.1", SmtpPort: port,
+		EmailFrom: "installer@example.com", EmailTo: "Ops <ops@example.com>"}
+	if errs := NewNotifier().Notify(context.Background(), config, sampleRunSummary()); len(errs) > 0 {
+		t.Fatalf("Notify() = %v", errs)
+	}
+	message := <-messages
+	for _, want := range []string{"Subject: OM-Kits install on cluster.example.com failed\r\n",
+		"To: Ops <ops@example.com>\r\n", "Failed task: Install Prometheus\r\n"} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message lacks %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestNotifierEmailTimeout(t *testing.T) {
+	// The server accepts the connection but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	config := &NotificationConfig{EmailEnabled: true, SmtpHost: "127.0.0.1", SmtpPort: port,
+		EmailFrom: "installer@example.com", EmailTo: "ops@example.com"}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if errs := NewNotifier().Notify(ctx, config, sampleRunSummary()); len(errs) != 1 {
+		t.Errorf("Notify() = %v, want the timeout of the email", errs)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Notify() returned after %s", elapsed)
+	}
+}
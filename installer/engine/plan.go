@@ -0,0 +1,367 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Task struct {
+	Name    string
+	Command string
+	// Func runs in the installer process instead of Command when it is set. dir is the
+	// working directory of the executor and what is written to output is reported line by line.
+	Func func(ctx context.Context, dir string, output io.Writer) error
+}
+
+// Plan is the ordered list of tasks to run and the environment variables passed to every task.
+type Plan struct {
+	Tasks []Task
+	Envs  []string
+}
+
+// NewPlan builds the install plan for config.
+func NewPlan(config *Config) *Plan {
+	var tasks []Task
+	var envs []string
+	basicInfo := config.BasicInfo
+	// The bundled controller serves the ingresses of the kits, whatever class was detected
+	if config.InstallIngressNginx {
+		basicInfo.IngressClass = IngressNginxClass
+		basicInfo.IngressController = IngressControllers.Nginx
+	}
+	// A wildcard certificate is requested by the installer, not by the ingresses
+	tlsAcme := basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertManager &&
+		!basicInfo.TlsCert.Acme.Wildcard
+
+	envs = append(envs, "IDO_TIMEZONE="+basicInfo.Timezone)
+	envs = append(envs, "IDO_TIME_TEMPLATE="+timeTemplate(basicInfo.Timezone))
+	envs = append(envs, "IDO_CLUSTER_HOSTNAME="+basicInfo.Host)
+
+	if basicInfo.Exposure == Exposures.IngressHost && net.ParseIP(basicInfo.Host) == nil {
+		envs = append(envs, "IDO_INGRESS_HOSTNAME="+basicInfo.Host)
+	} else {
+		envs = append(envs, "IDO_INGRESS_HOSTNAME=")
+	}
+
+	var clusterUrl string
+	tlsSecret := ""
+	if basicInfo.HttpsEnabled {
+		clusterUrl = "https://" + basicInfo.Host
+		envs = append(envs, "IDO_CLUSTER_URL="+clusterUrl)
+		envs = append(envs, "IDO_TLS_KEY=tls")
+		envs = append(envs, "IDO_TLS_HOST="+basicInfo.Host)
+
+		switch basicInfo.TlsCert.CertMethod {
+		case CertMethods.DefaultTlsSecret:
+			envs = append(envs, "IDO_TLS_ACME=false")
+		case CertMethods.CertManager:
+			envs = append(envs, "IDO_TLS_ACME="+strconv.FormatBool(tlsAcme))
+			tlsSecret = basicInfo.Host
+		case CertMethods.CertificateFiles, CertMethods.InternalCa:
+			envs = append(envs, "IDO_TLS_ACME=false")
+			tlsSecret = TlsSecretName
+		}
+		envs = append(envs, "IDO_TLS_SECRET="+tlsSecret)
+	} else {
+		clusterUrl = "http://" + basicInfo.Host
+		envs = append(envs, "IDO_CLUSTER_URL="+clusterUrl)
+		envs = append(envs, "IDO_TLS_KEY=tls-disabled")
+		envs = append(envs, "IDO_TLS_HOST=")
+		envs = append(envs, "IDO_TLS_ACME=false")
+		envs = append(envs, "IDO_TLS_SECRET=")
+	}
+	envs = append(envs, "IDO_FORCE_SSL_REDIRECT="+strconv.FormatBool(basicInfo.TlsCert.ForceSslRedirect))
+
+	maxBodyBytes, _ := ParseBodySize(basicInfo.MaxBodySize)
+	envs = append(envs, "IDO_INGRESS_CLASS="+basicInfo.IngressClass)
+	envs = append(envs, "IDO_INGRESS_CONTROLLER="+basicInfo.IngressController)
+	envs = append(envs, "IDO_INGRESS_NAMESPACES="+strings.Join(config.IngressNamespaces(), " "))
+	envs = append(envs, "IDO_INGRESS_MAX_BODY_BYTES="+strconv.FormatInt(maxBodyBytes, 10))
+	envs = append(envs, "IDO_MONITORING_INGRESS_ANNOTATIONS="+basicInfo.ingressAnnotations("monitoring", tlsAcme, true))
+	envs = append(envs, "IDO_LOGGING_INGRESS_ANNOTATIONS="+basicInfo.ingressAnnotations("logging", tlsAcme, true))
+	envs = append(envs, basicInfo.accessControlEnvs(tlsAcme, tlsSecret)...)
+
+	serviceType := "ClusterIP"
+	grafanaNodePort := ""
+	kibanaNodePort := ""
+	switch basicInfo.Exposure {
+	case Exposures.NodePort:
+		serviceType = "NodePort"
+		grafanaNodePort = strconv.Itoa(basicInfo.GrafanaNodePort)
+		kibanaNodePort = strconv.Itoa(basicInfo.KibanaNodePort)
+	case Exposures.LoadBalancer:
+		serviceType = "LoadBalancer"
+	}
+	grafanaRootUrl := basicInfo.url(webUi{Id: "grafana", Port: 80, NodePort: basicInfo.GrafanaNodePort}, "")
+	if basicInfo.Exposure == Exposures.LoadBalancer {
+		// The address of the LoadBalancer is only known once the service is created
+		grafanaRootUrl = "%(protocol)s://%(domain)s" + basicInfo.uiPath("grafana") + "/"
+	}
+	envs = append(envs, "IDO_INGRESS_ENABLED="+strconv.FormatBool(basicInfo.IngressExposed()))
+	envs = append(envs, "IDO_SERVICE_TYPE="+serviceType)
+	envs = append(envs, "IDO_GRAFANA_NODE_PORT="+grafanaNodePort)
+	envs = append(envs, "IDO_KIBANA_NODE_PORT="+kibanaNodePort)
+	envs = append(envs, "IDO_GRAFANA_ROOT_URL="+grafanaRootUrl)
+	envs = append(envs, "IDO_GRAFANA_SERVE_FROM_SUB_PATH="+strconv.FormatBool(basicInfo.uiPath("grafana") != ""))
+	envs = append(envs, "IDO_GRAFANA_INGRESS_PATH="+basicInfo.uiIngressPath("grafana"))
+	envs = append(envs, "IDO_KIBANA_BASE_PATH="+basicInfo.uiPath("kibana"))
+	envs = append(envs, "IDO_KIBANA_REWRITE_BASE_PATH="+strconv.FormatBool(basicInfo.uiPath("kibana") != ""))
+	envs = append(envs, "IDO_KIBANA_INGRESS_PATH="+basicInfo.uiIngressPath("kibana"))
+
+	// Every ingress has its own hostname with subdomain routing, and then its own certificate from cert-manager
+	for _, id := range []string{"grafana", "kibana", "prometheus", "alertmanager"} {
+		prefix := "IDO_" + strings.ToUpper(id) + "_"
+		hostname := ""
+		if basicInfo.Exposure == Exposures.IngressHost {
+			hostname = basicInfo.uiHost(id)
+		}
+		if hostname == "" && id == "kibana" {
+			// The Kibana chart creates no rule without a hostname, "*" is a rule matching any host
+			hostname = "*"
+		}
+		uiTlsHost := ""
+		uiTlsSecret := tlsSecret
+		if basicInfo.HttpsEnabled {
+			uiTlsHost = basicInfo.uiHost(id)
+			if tlsAcme {
+				uiTlsSecret = basicInfo.uiHost(id)
+			}
+		}
+		envs = append(envs, prefix+"INGRESS_HOSTNAME="+hostname)
+		envs = append(envs, prefix+"TLS_HOST="+uiTlsHost)
+		envs = append(envs, prefix+"TLS_SECRET="+uiTlsSecret)
+	}
+
+	envs = append(envs, config.mirrorEnvs()...)
+	envs = append(envs, config.pullSecretEnvs()...)
+	envs = append(envs, config.caBundleEnvs()...)
+	envs = append(envs, config.proxyEnvs()...)
+	// A missing image fails the install before any chart is installed, not in the middle of it
+	if config.EnableMirror && len(config.Images()) > 0 {
+		mirrorConfig := *config
+		tasks = append(tasks, Task{Name: "Check Mirror Images",
+			Func: func(ctx context.Context, dir string, output io.Writer) error {
+				lines, err := TestMirrors(ctx, &mirrorConfig)
+				for _, line := range lines {
+					fmt.Fprintln(output, line)
+				}
+				return err
+			}})
+	}
+	// The CA bundle and the pull secrets go first, every package trusts it and pulls with them.
+	// SSL_CERT_FILE points to the bundle from the start.
+	if basicInfo.CaBundleFile != "" {
+		tasks = append(tasks, config.caBundleTasks()...)
+	}
+	if config.pullSecretNeeded() {
+		tasks = append(tasks, config.pullSecretTasks()...)
+	}
+
+	// Helm renders every chart through packages/post-render.sh, which runs the rewrite-images
+	// command of the installer
+	installer, err := os.Executable()
+	if err != nil {
+		installer = os.Args[0]
+	}
+	envs = append(envs, "IDO_INSTALLER="+installer)
+	envs = append(envs, "IDO_POST_RENDERER=packages/post-render.sh")
+	envs = append(envs, "IDO_REGISTRY_REWRITES="+FormatRewrites(config.RegistryRewrites()))
+	envs = append(envs, "IDO_IMAGE_REWRITE_REPORT="+ImageRewriteReport)
+
+	// The ingress controller goes first, HTTP-01 challenges and the other kits need it
+	if config.InstallIngressNginx {
+		ingressNginx := config.IngressNginx
+		tasks = append(tasks, Task{Name: "Install Ingress-nginx",
+			Command: "chmod +x packages/ingress-nginx/install.sh; packages/ingress-nginx/install.sh"})
+
+		kind := "Deployment"
+		hostNetwork := false
+		dnsPolicy := "ClusterFirst"
+		serviceType := "ClusterIP"
+		httpNodePort := ""
+		httpsNodePort := ""
+		switch ingressNginx.Exposure {
+		case IngressNginxExposures.HostNetwork:
+			kind = "DaemonSet"
+			hostNetwork = true
+			dnsPolicy = "ClusterFirstWithHostNet"
+		case IngressNginxExposures.NodePort:
+			serviceType = "NodePort"
+			httpNodePort = strconv.Itoa(ingressNginx.HttpNodePort)
+			httpsNodePort = strconv.Itoa(ingressNginx.HttpsNodePort)
+		case IngressNginxExposures.LoadBalancer:
+			serviceType = "LoadBalancer"
+		}
+		envs = append(envs, "IDO_INGRESS_NGINX_CLASS="+IngressNginxClass)
+		envs = append(envs, "IDO_INGRESS_NGINX_DEFAULT_CLASS="+strconv.FormatBool(ingressNginx.DefaultClass))
+		envs = append(envs, "IDO_INGRESS_NGINX_KIND="+kind)
+		envs = append(envs, "IDO_INGRESS_NGINX_HOST_NETWORK="+strconv.FormatBool(hostNetwork))
+		envs = append(envs, "IDO_INGRESS_NGINX_DNS_POLICY="+dnsPolicy)
+		envs = append(envs, "IDO_INGRESS_NGINX_SERVICE_TYPE="+serviceType)
+		envs = append(envs, "IDO_INGRESS_NGINX_HTTP_NODE_PORT="+httpNodePort)
+		envs = append(envs, "IDO_INGRESS_NGINX_HTTPS_NODE_PORT="+httpsNodePort)
+	}
+
+	if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertManager {
+		tasks = append(tasks, Task{Name: "Install Cert-manager",
+			Command: "chmod +x packages/cert-manager/install.sh; packages/cert-manager/install.sh"})
+		envs = append(envs, "IDO_ACME_EMAIL="+basicInfo.TlsCert.AcmeEmail)
+		envs = append(envs, basicInfo.TlsCert.Acme.solverEnvs(basicInfo.IngressClass)...)
+		envs = append(envs, "IDO_TLS_NAMESPACES="+strings.Join(config.TlsNamespaces(), " "))
+	}
+
+	if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertificateFiles {
+		tasks = append(tasks, tlsSecretsTask)
+		envs = append(envs, tlsSecretsEnvs(basicInfo.TlsCert.CertFile, basicInfo.TlsCert.KeyFile, false,
+			config.TlsNamespaces())...)
+	}
+
+	if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.InternalCa {
+		tlsCert := basicInfo.TlsCert
+		tasks = append(tasks, Task{Name: "Issue Internal Certificates",
+			Func: func(ctx context.Context, dir string, output io.Writer) error {
+				// The hostnames of the UIs with subdomain routing come after the host
+				extraSans := append(basicInfo.Hostnames()[1:], tlsCert.ExtraSanList()...)
+				err := IssueInternalCertificates(filepath.Join(dir, InternalCaDir), basicInfo.Host,
+					extraSans, tlsCert.CaValidityDays, tlsCert.CertValidityDays, tlsCert.RotateCa, output)
+				if err == nil {
+					fmt.Fprintln(output, "Renew it before expiry with: "+
+						filepath.Join(dir, filepath.Base(os.Args[0]))+" renew-cert")
+				}
+				return err
+			}})
+		tasks = append(tasks, tlsSecretsTask)
+		envs = append(envs, tlsSecretsEnvs(InternalTlsCertFile, InternalTlsKeyFile, true, config.TlsNamespaces())...)
+	}
+
+	if basicInfo.IngressController == IngressControllers.Traefik && len(config.IngressNamespaces()) > 0 {
+		tasks = append(tasks, Task{Name: "Prepare Ingress",
+			Command: "chmod +x packages/ingress/install.sh; packages/ingress/install.sh"})
+	}
+
+	if basicInfo.AccessControl.Method != AccessControlMethods.None && len(config.IngressNamespaces()) > 0 {
+		tasks = append(tasks, basicInfo.accessControlTasks()...)
+	}
+
+	if config.InstallLocalPathProvisioner {
+		tasks = append(tasks, Task{Name: "Install Local-Path Provisioner",
+			Command: "chmod +x packages/storage/local-path/install.sh; packages/storage/local-path/install.sh"})
+	}
+
+	if config.InstallNfsProvisioner {
+		nfs := config.NfsProvisioner
+		options := strings.Split(nfs.MountOptions, ",")
+		optionsString := ""
+		if len(options) > 0 {
+			for oneOption := range options {
+				optionsString = optionsString + "    - " + options[oneOption] + "\n"
+			}
+		}
+		tasks = append(tasks, Task{Name: "Install NFS Provisioner",
+			Command: "chmod +x packages/storage/nfs/install.sh; packages/storage/nfs/install.sh"})
+		envs = append(envs, "IDO_NFS_SERVER="+nfs.Server)
+		envs = append(envs, "IDO_NFS_PATH="+nfs.Path)
+		envs = append(envs, "IDO_NFS_MOUNTOPTIONS="+optionsString)
+	}
+
+	if config.InstallPrometheus {
+		prometheus := config.Prometheus
+		// The check renders the values file with the variables of the plan, complete once it is built
+		tasks = append(tasks, prometheus.alertmanagerCheckTask(basicInfo.Timezone, &envs))
+		tasks = append(tasks, prometheus.alertTemplatesTask(basicInfo.Timezone))
+		tasks = append(tasks, Task{Name: "Install Prometheus",
+			Command: "chmod +x packages/prometheus/install.sh; packages/prometheus/install.sh"})
+		envs = append(envs, "IDO_ALTERMANAGER_STORAGE_SIZE="+strconv.Itoa(prometheus.AlertmanagerStorageSizeGi)+"Gi")
+		envs = append(envs, "IDO_GRAFANA_STORAGE_SIZE="+strconv.Itoa(prometheus.GrafanaStorageSizeGi)+"Gi")
+		envs = append(envs, "IDO_PROMETHEUS_STORAGE_SIZE="+strconv.Itoa(prometheus.PrometheusStorageSizeGi)+"Gi")
+		envs = append(envs, "IDO_PROMETHEUS_STORAGE_CLASS="+prometheus.StorageClass)
+		envs = append(envs, prometheus.alertingEnvs(&basicInfo.Proxy)...)
+	}
+
+	if config.InstallLogging {
+		logging := config.Logging
+		tasks = append(tasks, Task{Name: "Install Logging",
+			Command: "chmod +x packages/logging/install.sh; packages/logging/install.sh"})
+
+		var logPath string
+		if logging.CollectNamespaces != "" {
+			var logPathSlice []string
+			logNamespaces := strings.Split(logging.CollectNamespaces, ",")
+			for namespace := range logNamespaces {
+				logPathSlice = append(logPathSlice, "/var/log/containers/*_"+logNamespaces[namespace]+"_*.log")
+			}
+			logPath = strings.Join(logPathSlice, ",")
+		} else {
+			logPath = "/var/log/containers/*.log"
+		}
+
+		nodeAffinityPreset := ""
+		if logging.NodeAffinity {
+			nodeAffinityPreset = "hard"
+		}
+
+		alertLogLevel := "none"
+		if logging.ErrorLogAlert {
+			alertLogLevel = "ERROR"
+		}
+
+		envs = append(envs, "IDO_FLUENT_LOG_PATH="+logPath)
+		envs = append(envs, "IDO_ES_STORAGE_SIZE="+strconv.Itoa(logging.EsStorageSizeGi)+"Gi")
+		envs = append(envs, "IDO_ES_STORAGE_CLASS="+logging.StorageClass)
+		envs = append(envs, "IDO_ES_NODE_AFFINITY="+nodeAffinityPreset)
+		envs = append(envs, "IDO_ES_INDEX_AGE="+strconv.Itoa(logging.EsIndexAgeDay)+"d")
+		envs = append(envs, "IDO_FLUENT_ALERT_LOG_LEVEL="+alertLogLevel)
+	}
+
+	// The receivers are verified once the other packages had time to start
+	if config.InstallPrometheus && len(config.Prometheus.Receivers) > 0 {
+		tasks = append(tasks, config.Prometheus.alertTestTask())
+	}
+
+	tasks = append(tasks, Task{Name: "Final Check",
+		Command: "chmod +x packages/final-check.sh; packages/final-check.sh"})
+
+	return &Plan{Tasks: tasks, Envs: envs}
+}
+
+// NewRenewCertPlan builds the plan which renews the server certificate of the internal CA when it
+// expires within renewBeforeDays, and updates the TLS secrets in namespaces.
+func NewRenewCertPlan(renewBeforeDays int, namespaces []string) *Plan {
+	return &Plan{
+		Tasks: []Task{
+			{Name: "Renew Internal Certificates",
+				Func: func(ctx context.Context, dir string, output io.Writer) error {
+					_, err := RenewInternalCertificates(filepath.Join(dir, InternalCaDir), renewBeforeDays, output)
+					return err
+				}},
+			tlsSecretsTask,
+		},
+		Envs: tlsSecretsEnvs(InternalTlsCertFile, InternalTlsKeyFile, true, namespaces),
+	}
+}
+
+var tlsSecretsTask = Task{Name: "Create TLS Secrets",
+	Command: "chmod +x packages/tls/install.sh; packages/tls/install.sh"}
+
+func tlsSecretsEnvs(certFile string, keyFile string, internalCa bool, namespaces []string) (envs []string) {
+	envs = append(envs, "IDO_TLS_SECRET_NAME="+TlsSecretName)
+	envs = append(envs, "IDO_TLS_CERT_FILE="+certFile)
+	envs = append(envs, "IDO_TLS_KEY_FILE="+keyFile)
+	envs = append(envs, "IDO_TLS_NAMESPACES="+strings.Join(namespaces, " "))
+	if internalCa {
+		envs = append(envs, "IDO_TLS_CA_SECRET_NAME="+InternalCaSecret)
+		envs = append(envs, "IDO_TLS_CA_CERT_FILE="+InternalCaCertFile)
+		envs = append(envs, "IDO_TLS_CA_KEY_FILE="+InternalCaKeyFile)
+	} else {
+		envs = append(envs, "IDO_TLS_CA_SECRET_NAME=")
+		envs = append(envs, "IDO_TLS_CA_CERT_FILE=")
+		envs = append(envs, "IDO_TLS_CA_KEY_FILE=")
+	}
+	return
+}
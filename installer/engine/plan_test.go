@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// taskNames returns the names of the tasks of plan.
+func taskNames(plan *Plan) []string {
+	var names []string
+	for _, task := range plan.Tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
+
+// envValue returns the value of the environment variable key of plan, and whether it is set.
+func envValue(plan *Plan, key string) (string, bool) {
+	for _, env := range plan.Envs {
+		if strings.HasPrefix(env, key+"=") {
+			return strings.TrimPrefix(env, key+"="), true
+		}
+	}
+	return "", false
+}
+
+func TestNewPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(config *Config)
+		tasks     []string
+		envs      map[string]string
+	}{
+		{
+			name: "nfs, prometheus and logging over http",
+			configure: func(config *Config) {
+				config.BasicInfo.Host = "192.168.1.10"
+				config.InstallNfsProvisioner = true
+				config.NfsProvisioner.Server = "10.0.0.2"
+				config.InstallPrometheus = true
+				config.InstallLogging = true
+			},
+			tasks: []string{"Install NFS Provisioner", "Check Alertmanager Config", "Generate Alert Templates",
+				"Install Prometheus", "Install Logging", "Final Check"},
+			envs: map[string]string{
+				"IDO_CLUSTER_URL":            "http://192.168.1.10",
+				"IDO_TLS_KEY":                "tls-disabled",
+				"IDO_TLS_SECRET":             "",
+				"IDO_INGRESS_NAMESPACES":     "monitoring logging",
+				"IDO_NFS_SERVER":             "10.0.0.2",
+				"IDO_GRAFANA_ROOT_URL":       "http://192.168.1.10/grafana/",
+				"IDO_FLUENT_LOG_PATH":        "/var/log/containers/*.log",
+				"IDO_DINGTALK_ENABLED":       "false",
+				"IDO_PULL_SECRET_NAMESPACES": "nfs-provisioner monitoring logging",
+			},
+		},
+		{
+			name: "local-path through a mirror",
+			configure: func(config *Config) {
+				config.BasicInfo.Host = "192.168.1.10"
+				config.InstallLocalPathProvisioner = true
+				config.EnableMirror = true
+				config.RegistryMirrors = []RegistryMirror{{Source: "docker.io", Mirror: "harbor.example.com/dockerhub"}}
+			},
+			tasks: []string{"Check Mirror Images", "Install Local-Path Provisioner", "Final Check"},
+			envs: map[string]string{
+				"IDO_DOCKER_CONTAINER_MIRROR": "harbor.example.com/dockerhub",
+				"IDO_REGISTRY_REWRITES":       "docker.io=harbor.example.com/dockerhub",
+			},
+		},
+		{
+			name: "internal ca and an alert receiver",
+			configure: func(config *Config) {
+				config.BasicInfo.Host = "cluster.example.com"
+				config.BasicInfo.HttpsEnabled = true
+				config.BasicInfo.TlsCert.CertMethod = CertMethods.InternalCa
+				config.InstallPrometheus = true
+				config.Prometheus.Receivers = []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.DingTalk,
+					AccessToken: "token"}}
+			},
+			tasks: []string{"Issue Internal Certificates", "Create TLS Secrets", "Check Alertmanager Config",
+				"Generate Alert Templates", "Install Prometheus", "Verify Alert Receivers", "Final Check"},
+			envs: map[string]string{
+				"IDO_CLUSTER_URL":        "https://cluster.example.com",
+				"IDO_TLS_KEY":            "tls",
+				"IDO_TLS_SECRET":         TlsSecretName,
+				"IDO_TLS_CA_SECRET_NAME": InternalCaSecret,
+				"IDO_INGRESS_NAMESPACES": "monitoring",
+				"IDO_DINGTALK_ENABLED":   "true",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			test.configure(config)
+			plan := NewPlan(config)
+
+			if names := taskNames(plan); !reflect.DeepEqual(names, test.tasks) {
+				t.Errorf("tasks = %q, want %q", names, test.tasks)
+			}
+			for key, want := range test.envs {
+				value, ok := envValue(plan, key)
+				if !ok {
+					t.Errorf("%s isn't set", key)
+				} else if value != want {
+					t.Errorf("%s = %q, want %q", key, value, want)
+				}
+			}
+
+			// A task only sees the last value of a variable set twice
+			keys := map[string]bool{}
+			for _, env := range plan.Envs {
+				key := strings.SplitN(env, "=", 2)[0]
+				if keys[key] {
+					t.Errorf("%s is set twice", key)
+				}
+				keys[key] = true
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package engine
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// ProxyConfig is the corporate proxy the installer and the components reach the outside world
+// through. NoProxy lists more hosts, domains and CIDRs reached directly, like NO_PROXY, besides
+// the ones of the cluster.
+type ProxyConfig struct {
+	Enabled    bool
+	HttpProxy  string
+	HttpsProxy string
+	NoProxy    string
+	// ClusterAddresses are the addresses of the cluster found by DetectClusterAddresses
+	ClusterAddresses []string
+}
+
+// defaultNoProxy are the addresses always reached directly: the node itself and the services of
+// the cluster, which the components mostly reach by short names like elasticsearch.logging.
+var defaultNoProxy = []string{"localhost", "127.0.0.1", ".svc", ".cluster.local",
+	// The namespaces the kits install to
+	".cert-manager", ".ingress-nginx", ".local-path-storage", ".nfs-provisioner", ".monitoring", ".logging",
+	"." + OAuth2ProxyNamespace,
+	// The services reached from their own namespace
+	"elasticsearch", "fluent-bit-to-alertmanager", "prometheus-operated", "prometheus-webhook-dingtalk"}
+
+// serviceCidrPattern matches the valid range the API server reports for a bad cluster IP.
+var serviceCidrPattern = regexp.MustCompile(`valid IPs is ([0-9a-fA-F.:/]+)`)
+
+// activeProxy is the proxy of the HTTP clients of the installer, nil for the one of the
+// environment.
+var activeProxy *ProxyConfig
+
+func (proxy *ProxyConfig) Validate() error {
+	if !proxy.Enabled {
+		return nil
+	}
+	if proxy.HttpProxy == "" && proxy.HttpsProxy == "" {
+		return errors.New("Please set the HTTP or the HTTPS proxy.")
+	}
+	for _, proxyUrl := range []string{proxy.HttpProxy, proxy.HttpsProxy} {
+		if proxyUrl == "" {
+			continue
+		}
+		parsed, err := url.Parse(proxyUrl)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.New("Proxy " + proxyUrl + " isn't like http://proxy.example.com:3128.")
+		}
+	}
+	return nil
+}
+
+func (proxy *ProxyConfig) redact() {
+	proxy.HttpProxy = redactUrl(proxy.HttpProxy)
+	proxy.HttpsProxy = redactUrl(proxy.HttpsProxy)
+}
+
+// DetectClusterAddresses returns the addresses of the cluster reached without the proxy: the API
+// server, the addresses of the nodes and the CIDRs of the pods and services. The addresses
+// kubectl can't find are left out.
+func DetectClusterAddresses() []string {
+	var addresses []string
+	add := func(entries ...string) {
+		for _, entry := range entries {
+			if entry != "" && !slices.Contains(addresses, entry) {
+				addresses = append(addresses, entry)
+			}
+		}
+	}
+
+	result, err := ExecCommand("kubectl config view --minify -o jsonpath='{.clusters[0].cluster.server}'", 10)
+	if err == nil {
+		if server, err := url.Parse(strings.TrimSpace(string(result))); err == nil {
+			add(server.Hostname())
+		}
+	}
+	result, err = ExecCommand("kubectl get nodes -o jsonpath="+
+		"'{.items[*].status.addresses[?(@.type==\"InternalIP\")].address} {.items[*].spec.podCIDRs[*]}'", 10)
+	if err == nil {
+		add(strings.Fields(string(result))...)
+	}
+	// The API server rejects a cluster IP out of the service CIDR and names the CIDR
+	result, _ = ExecCommand("kubectl create service clusterip om-kits-cidr-probe --tcp=80 --clusterip=1.1.1.1 "+
+		"--namespace default --dry-run=server", 10)
+	if match := serviceCidrPattern.FindStringSubmatch(string(result)); match != nil {
+		add(match[1])
+	}
+	return addresses
+}
+
+// noProxy returns the NO_PROXY of the proxy: the addresses always reached directly, the ones of
+// the cluster and NoProxy.
+func (proxy *ProxyConfig) noProxy() string {
+	var entries []string
+	for _, list := range [][]string{defaultNoProxy, proxy.ClusterAddresses, strings.Split(proxy.NoProxy, ",")} {
+		for _, entry := range list {
+			entry = strings.TrimSpace(entry)
+			if entry != "" && !slices.Contains(entries, entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// proxyUrl returns the proxy target is reached through, empty when it is reached directly. Like
+// HTTPS_PROXY, the HTTPS proxy is the only one of https targets.
+func (proxy *ProxyConfig) proxyUrl(target *url.URL) string {
+	if !proxy.Enabled || noProxyMatches(proxy.noProxy(), target.Hostname()) {
+		return ""
+	}
+	if target.Scheme == "https" {
+		return proxy.HttpsProxy
+	}
+	return proxy.HttpProxy
+}
+
+// UseProxy makes the HTTP clients of the installer use proxy, or the proxy of the environment
+// when it isn't enabled.
+func UseProxy(proxy ProxyConfig) {
+	if proxy.Enabled {
+		activeProxy = &proxy
+	} else {
+		activeProxy = nil
+	}
+	updateTransports()
+}
+
+// proxyFor is the Proxy of the transports of the installer.
+func proxyFor(request *http.Request) (*url.URL, error) {
+	proxy := activeProxy
+	if proxy == nil {
+		return http.ProxyFromEnvironment(request)
+	}
+	proxyUrl := proxy.proxyUrl(request.URL)
+	if proxyUrl == "" {
+		return nil, nil
+	}
+	return url.Parse(proxyUrl)
+}
+
+// noProxyMatches reports whether host is one of the hosts, domains or CIDRs of noProxy.
+func noProxyMatches(noProxy string, host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		// A port of the entry is ignored
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if host == strings.TrimPrefix(entry, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyEnvs returns the proxy variables of the tasks, so helm, kubectl and the scripts use the
+// proxy, and the ones of the values files.
+func (config *Config) proxyEnvs() []string {
+	proxy := config.BasicInfo.Proxy
+	if !proxy.Enabled {
+		return []string{
+			"IDO_HTTP_PROXY=",
+			"IDO_HTTPS_PROXY=",
+			"IDO_NO_PROXY=",
+			"IDO_PROXY_ENV=[]",
+		}
+	}
+
+	// kubectl and helm reach the API server directly, whatever NoProxy holds
+	noProxy := proxy.noProxy()
+	variables := [][2]string{
+		{"HTTP_PROXY", proxy.HttpProxy}, {"HTTPS_PROXY", proxy.HttpsProxy}, {"NO_PROXY", noProxy},
+		{"http_proxy", proxy.HttpProxy}, {"https_proxy", proxy.HttpsProxy}, {"no_proxy", noProxy},
+	}
+	var envs []string
+	var containerEnv []string
+	for _, variable := range variables {
+		envs = append(envs, variable[0]+"="+variable[1])
+		containerEnv = append(containerEnv, "{name: "+variable[0]+", value: "+strconv.Quote(variable[1])+"}")
+	}
+	return append(envs,
+		"IDO_HTTP_PROXY="+proxy.HttpProxy,
+		"IDO_HTTPS_PROXY="+proxy.HttpsProxy,
+		"IDO_NO_PROXY="+noProxy,
+		"IDO_PROXY_ENV=["+strings.Join(containerEnv, ", ")+"]",
+	)
+}
@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestProxyEnvsNoProxy(t *testing.T) {
+	config := NewConfig()
+	config.BasicInfo.Proxy = ProxyConfig{Enabled: true, HttpsProxy: "http://proxy.example.com:3128",
+		ClusterAddresses: []string{"10.0.0.1", "10.244.0.0/16", "10.96.0.0/12"}}
+	want := strings.Join(defaultNoProxy, ",") + ",10.0.0.1,10.244.0.0/16,10.96.0.0/12"
+
+	for _, noProxy := range []string{"", "intranet.example.com, 10.0.0.1"} {
+		config.BasicInfo.Proxy.NoProxy = noProxy
+		wantNoProxy := want
+		if noProxy != "" {
+			wantNoProxy += ",intranet.example.com"
+		}
+		for _, env := range config.proxyEnvs() {
+			key, value, _ := strings.Cut(env, "=")
+			if (key == "NO_PROXY" || key == "IDO_NO_PROXY") && value != wantNoProxy {
+				t.Errorf("NoProxy %q: %s = %q, want %q", noProxy, key, value, wantNoProxy)
+			}
+		}
+	}
+}
+
+func TestAlertmanagerReceiverProxy(t *testing.T) {
+	proxy := &ProxyConfig{Enabled: true, HttpProxy: "http://proxy.example.com:3128",
+		HttpsProxy: "http://proxy.example.com:3129", NoProxy: "intranet.example.com",
+		ClusterAddresses: []string{"10.96.0.0/12"}}
+	tests := []struct {
+		name     string
+		receiver AlertReceiver
+		proxy    *ProxyConfig
+		want     string
+	}{
+		{"https webhook", AlertReceiver{Type: AlertReceiverTypes.Webhook, Url: "https://hooks.example.com/alerts"},
+			proxy, "http://proxy.example.com:3129"},
+		{"http webhook", AlertReceiver{Type: AlertReceiverTypes.Webhook, Url: "http://hooks.example.com/alerts"},
+			proxy, "http://proxy.example.com:3128"},
+		{"intranet webhook", AlertReceiver{Type: AlertReceiverTypes.Webhook, Url: "http://intranet.example.com/alerts"},
+			proxy, ""},
+		{"webhook in the cluster", AlertReceiver{Type: AlertReceiverTypes.Webhook,
+			Url: "http://receiver.monitoring.svc:8080/alerts"}, proxy, ""},
+		{"webhook on a service ip", AlertReceiver{Type: AlertReceiverTypes.FeishuFlow, Url: "http://10.96.4.2/hook"},
+			proxy, ""},
+		{"wecom", AlertReceiver{Type: AlertReceiverTypes.WeCom}, proxy, "http://proxy.example.com:3129"},
+		{"dingtalk webhook", AlertReceiver{Type: AlertReceiverTypes.DingTalk}, proxy, ""},
+		{"proxy disabled", AlertReceiver{Type: AlertReceiverTypes.Webhook, Url: "https://hooks.example.com/alerts"},
+			&ProxyConfig{}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.receiver.Name = "ops"
+			config := flowYaml(test.receiver.alertmanagerReceiver(false, test.proxy))
+			hasProxy := strings.Contains(config, `"proxy_url"`)
+			if test.want == "" && hasProxy || test.want != "" && !strings.Contains(config, `"proxy_url":"`+test.want+`"`) {
+				t.Errorf("receiver = %s, want proxy %q", config, test.want)
+			}
+		})
+	}
+}
+
+// valuesHostPatterns match the hosts of the URLs of the values files and the hosts of the
+// outputs of fluent-bit.
+var valuesHostPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`https?://([^/:"'\s]+)`),
+	regexp.MustCompile(`^\s*Host\s+(\S+)`),
+}
+
+// publicSuffixes are the top level domains of the hosts of the values files outside the cluster.
+var publicSuffixes = []string{".com", ".dev", ".io", ".org", ".net", ".cn"}
+
+func TestNoProxyCoversCluster(t *testing.T) {
+	// The URLs the charts and the installer render, besides the ones of the values files
+	hosts := map[string]string{
+		"prometheus-kube-prometheus-prometheus.monitoring":   "Grafana datasource",
+		"prometheus-kube-prometheus-alertmanager.monitoring": "Grafana datasource",
+		"prometheus-webhook-dingtalk":                        "DingTalk receiver",
+	}
+	for _, rawUrl := range []string{dingTalkWebhookUrl, "http://oauth2-proxy." + OAuth2ProxyNamespace} {
+		parsed, err := url.Parse(rawUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hosts[parsed.Hostname()] = "installer"
+	}
+
+	var files []string
+	for _, pattern := range []string{"../../packages/*/values*override*.yaml", "../../packages/*/*/values*override*.yaml"} {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, matches...)
+	}
+	if len(files) == 0 {
+		t.Fatal("no values files")
+	}
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			for _, pattern := range valuesHostPatterns {
+				for _, match := range pattern.FindAllStringSubmatch(line, -1) {
+					if !strings.Contains(match[1], "${") {
+						hosts[match[1]] = file
+					}
+				}
+			}
+		}
+	}
+
+	config := NewConfig()
+	config.BasicInfo.Proxy = ProxyConfig{Enabled: true, HttpProxy: "http://proxy.example.com:3128"}
+	var noProxy string
+	for _, env := range config.proxyEnvs() {
+		if key, value, _ := strings.Cut(env, "="); key == "IDO_NO_PROXY" {
+			noProxy = value
+		}
+	}
+	for host, source := range hosts {
+		external := false
+		for _, suffix := range publicSuffixes {
+			external = external || strings.HasSuffix(host, suffix)
+		}
+		if !external && !noProxyMatches(noProxy, host) {
+			t.Errorf("%s of %s isn't in NO_PROXY %s", host, source, noProxy)
+		}
+	}
+}
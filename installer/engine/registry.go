@@ -0,0 +1,361 @@
+package engine
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestMediaTypes are the manifests and image indexes accepted when resolving a tag.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// MirrorTester resolves the images of the selected packages through the registries they are
+// pulled from, calling the registries with Client.
+type MirrorTester struct {
+	Client *http.Client
+}
+
+// NewMirrorTester returns a tester calling the registries with the client of the installer,
+// which trusts the CA bundle and uses the proxy.
+func NewMirrorTester() *MirrorTester {
+	return &MirrorTester{Client: registryHttpClient}
+}
+
+// TestMirrors tests the mirrors of config with NewMirrorTester.
+func TestMirrors(ctx context.Context, config *Config) ([]string, error) {
+	return NewMirrorTester().Test(ctx, config)
+}
+
+// Test calls the /v2/ endpoint of every registry the images of config are pulled from and
+// resolves the manifest of each image through it. It returns one line per registry and image,
+// and an error when a registry or an image isn't available.
+func (tester *MirrorTester) Test(ctx context.Context, config *Config) ([]string, error) {
+	err := config.ValidateMirrors()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	clients := map[string]*registryClient{}
+	registryErrors := map[string]error{}
+	failed := 0
+	images := config.Images()
+	for _, image := range images {
+		registry, repository, tag := splitImage(image)
+		pullFrom, mirrored := config.pullRegistry(registry)
+		host, prefix, _ := strings.Cut(pullFrom, "/")
+		if prefix != "" {
+			repository = prefix + "/" + repository
+		}
+		reference := host + "/" + repository + ":" + tag
+
+		client, ok := clients[host]
+		if !ok {
+			client = newRegistryClient(host)
+			client.httpClient, client.transferClient = tester.Client, tester.Client
+			if username, password := config.mirrorLogin(host); username != "" {
+				client.username, client.password = username, password
+			}
+			clients[host] = client
+			registryErrors[host] = client.ping(ctx)
+			if registryErrors[host] != nil {
+				lines = append(lines, "FAIL     registry "+host+": "+registryErrors[host].Error())
+			} else {
+				lines = append(lines, "OK       registry "+host)
+			}
+		}
+		note := ""
+		if config.EnableMirror && !mirrored {
+			note = " (no mirror for " + registry + ")"
+		}
+		if registryErrors[host] != nil {
+			failed++
+			lines = append(lines, "FAIL     "+reference+note+": Registry isn't available.")
+			continue
+		}
+		err = client.resolveManifest(ctx, repository, tag)
+		if err != nil {
+			failed++
+			lines = append(lines, "MISSING  "+reference+note+": "+err.Error())
+		} else {
+			lines = append(lines, "OK       "+reference+note)
+		}
+	}
+
+	if failed > 0 {
+		return lines, fmt.Errorf("%d of %d images can't be pulled.", failed, len(images))
+	}
+	return lines, nil
+}
+
+// registryClient talks to the Docker Registry HTTP API V2 of one registry.
+type registryClient struct {
+	host     string
+	scheme   string
+	username string
+	password string
+
+	// The authentication challenge of the /v2/ endpoint, empty when it's open
+	authScheme string
+	realm      string
+	service    string
+	tokens     map[string]string
+
+	// httpClient sends the short calls, transferClient the ones which may transfer layers
+	httpClient     *http.Client
+	transferClient *http.Client
+}
+
+var registryHttpClient = &http.Client{Timeout: 10 * time.Second}
+
+// registryTransferClient downloads and uploads images, which may take a lot longer.
+var registryTransferClient = &http.Client{}
+
+func newRegistryClient(host string) *registryClient {
+	apiHost := host
+	// Docker Hub serves the API on another host than its image names
+	if host == "docker.io" {
+		apiHost = "registry-1.docker.io"
+	}
+	username, password := dockerCredentials(host)
+	return &registryClient{host: apiHost, scheme: "https", username: username, password: password,
+		tokens: map[string]string{}, httpClient: registryHttpClient, transferClient: registryTransferClient}
+}
+
+// ping calls /v2/ and records how the registry authenticates. A registry serving plain HTTP,
+// like a local registry:2, is called over HTTP.
+func (client *registryClient) ping(ctx context.Context) error {
+	response, err := client.get(ctx, http.MethodGet, "/v2/", nil)
+	if err != nil && strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+		client.scheme = "http"
+		response, err = client.get(ctx, http.MethodGet, "/v2/", nil)
+	}
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		client.authScheme, client.realm, client.service = parseChallenge(response.Header.Get("WWW-Authenticate"))
+		if client.authScheme == "basic" && client.username == "" {
+			return errors.New("Needs credentials, log in with docker login " + client.host + ".")
+		}
+		if client.authScheme != "basic" && client.authScheme != "bearer" {
+			return errors.New("Unsupported authentication " + response.Header.Get("WWW-Authenticate") + ".")
+		}
+		return nil
+	}
+	return errors.New("/v2/ returned " + response.Status + ".")
+}
+
+// resolveManifest checks that the manifest of repository:tag exists.
+func (client *registryClient) resolveManifest(ctx context.Context, repository string, tag string) error {
+	headers := map[string]string{"Accept": strings.Join(manifestMediaTypes, ", ")}
+	response, err := client.request(ctx, http.MethodHead, "/v2/"+repository+"/manifests/"+tag, repository, "pull",
+		headers, nil)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errors.New("Tag " + tag + " not found.")
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.New("Access denied.")
+	}
+	return errors.New("Manifest returned " + response.Status + ".")
+}
+
+// authorization returns the Authorization header for actions, like "pull" or "pull,push", on
+// repository, empty when the registry is open.
+func (client *registryClient) authorization(ctx context.Context, repository string, actions string) (string, error) {
+	switch client.authScheme {
+	case "basic":
+		return "Basic " + basicCredentials(client.username, client.password), nil
+	case "bearer":
+		token, err := client.token(ctx, "repository:"+repository+":"+actions)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+	}
+	return "", nil
+}
+
+// token returns the bearer token of scope, with the credentials if there are any.
+func (client *registryClient) token(ctx context.Context, scope string) (string, error) {
+	if token, ok := client.tokens[scope]; ok {
+		return token, nil
+	}
+
+	query := url.Values{}
+	if client.service != "" {
+		query.Set("service", client.service)
+	}
+	query.Set("scope", scope)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, client.realm+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if client.username != "" {
+		request.SetBasicAuth(client.username, client.password)
+	}
+	response, err := client.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New("Token service " + client.realm + " returned " + response.Status + ".")
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return "", err
+	}
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+	client.tokens[scope] = token
+	return token, nil
+}
+
+func (client *registryClient) get(ctx context.Context, method string, path string,
+	headers map[string]string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, client.scheme+"://"+client.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	return client.httpClient.Do(request)
+}
+
+// request sends a request to target, a path or the absolute URL of an upload, authorized for
+// actions on repository. Without a timeout of its own, it may transfer large layers.
+func (client *registryClient) request(ctx context.Context, method string, target string, repository string,
+	actions string, headers map[string]string, body io.Reader) (*http.Response, error) {
+	base, err := url.Parse(client.scheme + "://" + client.host + "/")
+	if err != nil {
+		return nil, err
+	}
+	targetUrl, err := base.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, method, targetUrl.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	// Uploads are streamed with the length of the blob
+	if length := request.Header.Get("Content-Length"); length != "" {
+		request.ContentLength, _ = strconv.ParseInt(length, 10, 64)
+	}
+	authorization, err := client.authorization(ctx, repository, actions)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	if method == http.MethodHead {
+		return client.httpClient.Do(request)
+	}
+	return client.transferClient.Do(request)
+}
+
+// parseChallenge returns the scheme, in lower case, and the realm and service of a
+// WWW-Authenticate header like `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(header string) (scheme string, realm string, service string) {
+	scheme, params, _ := strings.Cut(header, " ")
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		switch strings.ToLower(key) {
+		case "realm":
+			realm = value
+		case "service":
+			service = value
+		}
+	}
+	return strings.ToLower(scheme), realm, service
+}
+
+// dockerCredentials returns the credentials of host saved by docker login, empty when there
+// are none. Credential helpers aren't supported.
+func dockerCredentials(host string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	var dockerConfig struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(content, &dockerConfig) != nil {
+		return "", ""
+	}
+	for _, key := range []string{host, "https://" + host, "https://" + host + "/v1/", "https://index.docker.io/v1/"} {
+		auth, ok := dockerConfig.Auths[key]
+		if !ok || (key == "https://index.docker.io/v1/" && host != "docker.io") {
+			continue
+		}
+		if auth.Username != "" {
+			return auth.Username, auth.Password
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err == nil {
+			username, password, _ := strings.Cut(string(decoded), ":")
+			return username, password
+		}
+	}
+	return "", ""
+}
+
+func basicCredentials(username string, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
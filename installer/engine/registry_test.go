@@ -0,0 +1,124 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry returns a registry v2 stand-in authenticating like Docker Hub: /v2/ asks for
+// a bearer token, which the token endpoint hands out to username and password. manifests are
+// the media types of the manifests by repository:tag, a manifest is only served when its type
+// is accepted.
+func newTestRegistry(t *testing.T, manifests map[string]string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path == "/token" {
+			username, password, _ := request.BasicAuth()
+			if username != "robot" || password != "secret" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if request.URL.Query().Get("service") != "test-registry" {
+				t.Errorf("token requested for service %q", request.URL.Query().Get("service"))
+			}
+			writer.Write([]byte(`{"token": "token of ` + request.URL.Query().Get("scope") + `"}`))
+			return
+		}
+
+		path := strings.TrimPrefix(request.URL.Path, "/v2/")
+		repository, tag, _ := strings.Cut(path, "/manifests/")
+		if request.Header.Get("Authorization") != "Bearer token of repository:"+repository+":pull" {
+			writer.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test-registry"`)
+			writer.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mediaType, ok := manifests[repository+":"+tag]
+		if !ok || !strings.Contains(request.Header.Get("Accept"), mediaType) {
+			writer.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", mediaType)
+	}))
+	return server
+}
+
+func TestMirrorTester(t *testing.T) {
+	// The credentials of docker login aren't used
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	tests := []struct {
+		name      string
+		manifests map[string]string
+		password  string
+		lines     []string
+		err       string
+	}{
+		{
+			name: "manifest list and manifest",
+			manifests: map[string]string{
+				"dockerhub/rancher/local-path-provisioner:v0.0.28": "application/vnd.docker.distribution.manifest.list.v2+json",
+				"dockerhub/library/busybox:latest":                 "application/vnd.oci.image.manifest.v1+json",
+			},
+			password: "secret",
+			lines: []string{"OK       registry HOST",
+				"OK       HOST/dockerhub/rancher/local-path-provisioner:v0.0.28",
+				"OK       HOST/dockerhub/library/busybox:latest"},
+		},
+		{
+			name: "missing tag",
+			manifests: map[string]string{
+				"dockerhub/rancher/local-path-provisioner:v0.0.28": "application/vnd.oci.image.index.v1+json",
+				"dockerhub/library/busybox:1.31.1":                 "application/vnd.oci.image.manifest.v1+json",
+			},
+			password: "secret",
+			lines: []string{"OK       registry HOST",
+				"OK       HOST/dockerhub/rancher/local-path-provisioner:v0.0.28",
+				"MISSING  HOST/dockerhub/library/busybox:latest: Tag latest not found."},
+			err: "1 of 2 images can't be pulled.",
+		},
+		{
+			name: "wrong password",
+			manifests: map[string]string{
+				"dockerhub/rancher/local-path-provisioner:v0.0.28": "application/vnd.oci.image.index.v1+json",
+				"dockerhub/library/busybox:latest":                 "application/vnd.oci.image.manifest.v1+json",
+			},
+			password: "wrong",
+			lines: []string{"OK       registry HOST",
+				"MISSING  HOST/dockerhub/rancher/local-path-provisioner:v0.0.28: Token service " +
+					"URL/token returned 401 Unauthorized.",
+				"MISSING  HOST/dockerhub/library/busybox:latest: Token service URL/token returned 401 Unauthorized."},
+			err: "2 of 2 images can't be pulled.",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestRegistry(t, test.manifests)
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			config := NewConfig()
+			config.InstallLocalPathProvisioner = true
+			config.EnableMirror = true
+			config.RegistryMirrors = []RegistryMirror{{Source: "docker.io", Mirror: host + "/dockerhub",
+				Username: "robot", Password: test.password}}
+			tester := &MirrorTester{Client: server.Client()}
+			lines, err := tester.Test(context.Background(), config)
+
+			replacer := strings.NewReplacer("HOST", host, "URL", server.URL)
+			if len(lines) != len(test.lines) {
+				t.Fatalf("lines = %q, want %d lines", lines, len(test.lines))
+			}
+			for i, want := range test.lines {
+				if want = replacer.Replace(want); lines[i] != want {
+					t.Errorf("line %d = %q, want %q", i, lines[i], want)
+				}
+			}
+			if err == nil && test.err != "" || err != nil && err.Error() != test.err {
+				t.Errorf("Test() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ImageRewriteReport is the file in the working directory of the executor listing the images
+// pulled from registries without a mirror.
+const ImageRewriteReport = "image-rewrite-report.txt"
+
+// imageLinePatterns match the image references in rendered manifests: the image of containers
+// and custom resources, and the images the Prometheus operator passes to its pods.
+var imageLinePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(\s*(?:-\s+)?"?image"?:\s*["']?)([^"'\s{}]+)(["']?\s*)$`),
+	regexp.MustCompile(`^(\s*-\s+["']?--prometheus-config-reloader=)([^"'\s]+)(["']?\s*)$`),
+}
+
+// RegistryRewrites returns the mirror of every source registry when the mirror is enabled.
+func (config *Config) RegistryRewrites() map[string]string {
+	rewrites := map[string]string{}
+	for _, mirror := range config.RegistryMirrors {
+		if pullFrom, mirrored := config.pullRegistry(mirror.Source); mirrored {
+			rewrites[mirror.Source] = pullFrom
+		}
+	}
+	return rewrites
+}
+
+// FormatRewrites formats rewrites like "docker.io=docker.m.daocloud.io quay.io=quay.m.daocloud.io".
+func FormatRewrites(rewrites map[string]string) string {
+	var pairs []string
+	for registry, mirror := range rewrites {
+		pairs = append(pairs, registry+"="+mirror)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// ParseRewrites parses the rewrites formatted by FormatRewrites.
+func ParseRewrites(s string) map[string]string {
+	rewrites := map[string]string{}
+	for _, pair := range strings.Fields(s) {
+		registry, mirror, ok := strings.Cut(pair, "=")
+		if ok && registry != "" && mirror != "" {
+			rewrites[registry] = mirror
+		}
+	}
+	return rewrites
+}
+
+// normalizeImage splits reference into its registry and the rest, like docker does: the
+// registry is docker.io when the first component isn't a host, and the official images of
+// Docker Hub are in library/.
+func normalizeImage(reference string) (registry string, remainder string) {
+	first, rest, found := strings.Cut(reference, "/")
+	if found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first, rest
+	}
+	if !found {
+		return "docker.io", "library/" + reference
+	}
+	return "docker.io", reference
+}
+
+// rewriteImage returns reference pulled from the mirror of its registry. ok is false when no
+// mirror covers the registry, an image already pulled from a mirror is left as it is.
+func rewriteImage(reference string, rewrites map[string]string) (rewritten string, ok bool) {
+	registry, remainder := normalizeImage(reference)
+	if registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		registry = "docker.io"
+	}
+	if mirror, found := rewrites[registry]; found {
+		return mirror + "/" + remainder, true
+	}
+	for _, mirror := range rewrites {
+		if reference == mirror || strings.HasPrefix(reference, mirror+"/") {
+			return reference, true
+		}
+	}
+	return reference, false
+}
+
+// RewriteImages copies the manifests from input to output with every image pulled from the
+// mirror of its registry, and returns the images no mirror covers. Nothing is rewritten
+// without rewrites.
+func RewriteImages(input io.Reader, output io.Writer, rewrites map[string]string) ([]string, error) {
+	var skipped []string
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	writer := bufio.NewWriter(output)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(rewrites) > 0 {
+			for _, pattern := range imageLinePatterns {
+				match := pattern.FindStringSubmatch(line)
+				if match == nil {
+					continue
+				}
+				rewritten, ok := rewriteImage(match[2], rewrites)
+				if !ok {
+					skipped = append(skipped, match[2])
+				}
+				line = match[1] + rewritten + match[3]
+				break
+			}
+		}
+		_, err := writer.WriteString(line + "\n")
+		if err != nil {
+			return skipped, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return skipped, err
+	}
+	return skipped, writer.Flush()
+}
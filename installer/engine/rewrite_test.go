@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testRewrites = map[string]string{
+	"cr.fluentbit.io": "m.daocloud.io/cr.fluentbit.io",
+	"docker.io":       "docker.m.daocloud.io",
+	"quay.io":         "quay.m.daocloud.io",
+}
+
+func TestRewriteImage(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		reference string
+		want      string
+		ok        bool
+	}{
+		{"quay.io/prometheus/prometheus:v2.45.0", "quay.m.daocloud.io/prometheus/prometheus:v2.45.0", true},
+		{"cr.fluentbit.io/fluent/fluent-bit:3.1.4", "m.daocloud.io/cr.fluentbit.io/fluent/fluent-bit:3.1.4", true},
+		{"docker.io/grafana/grafana:10.0.2", "docker.m.daocloud.io/grafana/grafana:10.0.2", true},
+		{"grafana/grafana:10.0.2", "docker.m.daocloud.io/grafana/grafana:10.0.2", true},
+		{"busybox:1.31.1", "docker.m.daocloud.io/library/busybox:1.31.1", true},
+		{"busybox", "docker.m.daocloud.io/library/busybox", true},
+		{"index.docker.io/library/busybox:1.31.1", "docker.m.daocloud.io/library/busybox:1.31.1", true},
+		{"registry-1.docker.io/bitnami/kibana:8.14.3", "docker.m.daocloud.io/bitnami/kibana:8.14.3", true},
+		{"quay.io/kiwigrid/k8s-sidecar@" + digest, "quay.m.daocloud.io/kiwigrid/k8s-sidecar@" + digest, true},
+		{"busybox:1.31.1@" + digest, "docker.m.daocloud.io/library/busybox:1.31.1@" + digest, true},
+		{"docker.m.daocloud.io/library/busybox:1.31.1", "docker.m.daocloud.io/library/busybox:1.31.1", true},
+		{"registry.k8s.io/ingress-nginx/controller:v1.8.1", "registry.k8s.io/ingress-nginx/controller:v1.8.1", false},
+		{"localhost/busybox:1.31.1", "localhost/busybox:1.31.1", false},
+		{"localhost:5000/busybox:1.31.1", "localhost:5000/busybox:1.31.1", false},
+	}
+	for _, test := range tests {
+		rewritten, ok := rewriteImage(test.reference, testRewrites)
+		if rewritten != test.want || ok != test.ok {
+			t.Errorf("rewriteImage(%s) = %s, %v, want %s, %v", test.reference, rewritten, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestRewriteImages(t *testing.T) {
+	manifests := `apiVersion: apps/v1
+kind: DaemonSet
+spec:
+  template:
+    spec:
+      containers:
+        - name: fluent-bit
+          image: "cr.fluentbit.io/fluent/fluent-bit:3.1.4"
+          imagePullPolicy: IfNotPresent
+        - image: busybox:1.31.1
+          name: init
+        - name: controller
+          image: 'registry.k8s.io/ingress-nginx/controller:v1.8.1'
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+spec:
+  image: quay.io/prometheus/prometheus:v2.45.0
+---
+spec:
+  args:
+    - --prometheus-config-reloader=quay.io/prometheus-operator/prometheus-config-reloader:v0.66.0
+    - --log-level=info
+  # image: quay.io/commented/out:latest
+  env:
+    - name: image
+      value: "image: docker.io/not/an/image"
+`
+	want := `apiVersion: apps/v1
+kind: DaemonSet
+spec:
+  template:
+    spec:
+      containers:
+        - name: fluent-bit
+          image: "m.daocloud.io/cr.fluentbit.io/fluent/fluent-bit:3.1.4"
+          imagePullPolicy: IfNotPresent
+        - image: docker.m.daocloud.io/library/busybox:1.31.1
+          name: init
+        - name: controller
+          image: 'registry.k8s.io/ingress-nginx/controller:v1.8.1'
+---
+apiVersion: monitoring.coreos.com/v1
+kind: Prometheus
+spec:
+  image: quay.m.daocloud.io/prometheus/prometheus:v2.45.0
+---
+spec:
+  args:
+    - --prometheus-config-reloader=quay.m.daocloud.io/prometheus-operator/prometheus-config-reloader:v0.66.0
+    - --log-level=info
+  # image: quay.io/commented/out:latest
+  env:
+    - name: image
+      value: "image: docker.io/not/an/image"
+`
+	var output strings.Builder
+	skipped, err := RewriteImages(strings.NewReader(manifests), &output, testRewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != want {
+		t.Errorf("RewriteImages() =\n%s\nwant\n%s", output.String(), want)
+	}
+	if wantSkipped := []string{"registry.k8s.io/ingress-nginx/controller:v1.8.1"}; !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("skipped = %v, want %v", skipped, wantSkipped)
+	}
+
+	// Nothing is rewritten without a mirror
+	output.Reset()
+	skipped, err = RewriteImages(strings.NewReader(manifests), &output, map[string]string{})
+	if err != nil || output.String() != manifests || len(skipped) != 0 {
+		t.Errorf("RewriteImages() without rewrites = %v, %v, changed %v", skipped, err, output.String() != manifests)
+	}
+}
+
+func TestRegistryRewrites(t *testing.T) {
+	config := NewConfig()
+	config.EnableMirror = true
+	config.RegistryMirrors = DefaultMirrors()
+	rewrites := config.RegistryRewrites()
+	if rewrites["cr.fluentbit.io"] != "m.daocloud.io/cr.fluentbit.io" {
+		t.Errorf("cr.fluentbit.io is rewritten to %q", rewrites["cr.fluentbit.io"])
+	}
+	if parsed := ParseRewrites(FormatRewrites(rewrites)); !reflect.DeepEqual(parsed, rewrites) {
+		t.Errorf("ParseRewrites(FormatRewrites()) = %v, want %v", parsed, rewrites)
+	}
+
+	// Every image of the packages is covered by the default mirrors
+	for _, images := range packageImages {
+		for _, image := range images {
+			if _, ok := rewriteImage(image, rewrites); !ok {
+				t.Errorf("%s has no mirror", image)
+			}
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AlertRouting is the routing tree of Alertmanager below its root route, which sends what no
+// route matches to the 'null' receiver, with the inhibition rules and the mute time intervals
+// of the maintenance windows.
+type AlertRouting struct {
+	GroupBy           string
+	GroupWait         string
+	GroupInterval     string
+	RepeatInterval    string
+	Routes            []AlertRoute
+	InhibitRules      []InhibitRule
+	MuteTimeIntervals []MuteTimeInterval
+}
+
+// AlertRoute sends the alerts of its matchers to its receiver, or to one of its child routes.
+// The grouping and timing of the parent route are kept when they are empty.
+type AlertRoute struct {
+	Receiver          string
+	Matchers          string
+	GroupBy           string
+	GroupWait         string
+	GroupInterval     string
+	RepeatInterval    string
+	MuteTimeIntervals string
+	Continue          bool
+	Routes            []AlertRoute
+}
+
+// InhibitRule mutes the alerts of TargetMatchers while an alert of SourceMatchers fires with the
+// same Equal labels.
+type InhibitRule struct {
+	SourceMatchers string
+	TargetMatchers string
+	Equal          string
+}
+
+// MuteTimeInterval is a named time interval the routes mute their notifications in. Every field
+// is a comma separated list like the ones of Alertmanager, empty meaning any time.
+type MuteTimeInterval struct {
+	Name string
+	// Times like 22:00-24:00
+	Times string
+	// Weekdays like monday:friday or saturday, sunday
+	Weekdays string
+	// DaysOfMonth like 1:7 or -1, counted from the end of the month when negative
+	DaysOfMonth string
+	// Months like january:march or 12
+	Months string
+	// Years like 2024:2025
+	Years string
+	// Location of the times, like Asia/Shanghai, UTC when empty
+	Location string
+}
+
+// matcher is one label matcher of Alertmanager, like severity=~"critical|warning".
+type matcher struct {
+	name     string
+	operator string
+	value    string
+}
+
+var (
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	matcherPattern   = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(=~|!~|!=|=)\s*((?s).*?)\s*$`)
+	// durationPattern is the duration format of Prometheus, which Alertmanager uses.
+	durationPattern  = regexp.MustCompile(`^0$|^(([0-9]+)y)?(([0-9]+)w)?(([0-9]+)d)?(([0-9]+)h)?(([0-9]+)m)?(([0-9]+)s)?(([0-9]+)ms)?$`)
+	timeRangePattern = regexp.MustCompile(`^(\d{2}):(\d{2})-(\d{2}):(\d{2})$`)
+)
+
+var weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+var months = []string{"january", "february", "march", "april", "may", "june", "july", "august", "september",
+	"october", "november", "december"}
+
+// DefaultAlertRouting returns the routing Alertmanager had before the routing could be edited:
+// alerts grouped by namespace, and critical alerts inhibiting the warning and info ones of the
+// same alert, warning the info ones, InfoInhibitor the info ones of its namespace.
+func DefaultAlertRouting() AlertRouting {
+	return AlertRouting{
+		GroupBy:        "namespace",
+		GroupWait:      "30s",
+		GroupInterval:  "5m",
+		RepeatInterval: "12h",
+		InhibitRules: []InhibitRule{
+			{SourceMatchers: `severity="critical"`, TargetMatchers: `severity=~"warning|info"`,
+				Equal: "namespace, alertname"},
+			{SourceMatchers: `severity="warning"`, TargetMatchers: `severity="info"`, Equal: "namespace, alertname"},
+			{SourceMatchers: `alertname="InfoInhibitor"`, TargetMatchers: `severity="info"`, Equal: "namespace"},
+		},
+	}
+}
+
+// ValidateRouting checks the routing like amtool check-config: the matchers, durations and
+// labels parse, and the receivers and time intervals the routes name exist.
+func (config *PrometheusConfig) ValidateRouting() error {
+	routing := &config.Routing
+	err := validateGroupBy(routing.GroupBy)
+	if err != nil {
+		return errors.New("Root route: " + err.Error())
+	}
+	for _, duration := range [][2]string{{"Group wait", routing.GroupWait},
+		{"Group interval", routing.GroupInterval}, {"Repeat interval", routing.RepeatInterval}} {
+		if duration[1] == "" {
+			return errors.New("Root route: " + duration[0] + " is empty.")
+		}
+		err = validateDuration(duration[0], duration[1])
+		if err != nil {
+			return errors.New("Root route: " + err.Error())
+		}
+	}
+
+	intervals := map[string]bool{}
+	for _, interval := range routing.MuteTimeIntervals {
+		if !receiverNamePattern.MatchString(interval.Name) {
+			return errors.New("Mute time interval name " + interval.Name + " may only have letters, digits, '_' and '-'.")
+		}
+		if intervals[interval.Name] {
+			return errors.New("Mute time interval " + interval.Name + " is added twice.")
+		}
+		intervals[interval.Name] = true
+		err = interval.validate()
+		if err != nil {
+			return errors.New("Mute time interval " + interval.Name + ": " + err.Error())
+		}
+	}
+
+	receivers := map[string]bool{"null": true}
+	for _, receiver := range config.Receivers {
+		receivers[receiver.Name] = true
+	}
+	for index := range routing.Routes {
+		err = routing.Routes[index].validate(strconv.Itoa(index+1), receivers, intervals)
+		if err != nil {
+			return err
+		}
+	}
+
+	for index, rule := range routing.InhibitRules {
+		err = rule.validate()
+		if err != nil {
+			return fmt.Errorf("Inhibition rule %d: %s", index+1, err.Error())
+		}
+	}
+	return nil
+}
+
+// validate checks route and its child routes, path being its position in the tree like 1.2.
+func (route *AlertRoute) validate(path string, receivers map[string]bool, intervals map[string]bool) error {
+	fail := func(err error) error {
+		return errors.New("Route " + path + ": " + err.Error())
+	}
+	if route.Receiver == "" {
+		return fail(errors.New("Receiver is empty."))
+	}
+	if !receivers[route.Receiver] {
+		return fail(errors.New("Receiver " + route.Receiver + " doesn't exist."))
+	}
+	_, err := parseMatchers(route.Matchers)
+	if err != nil {
+		return fail(err)
+	}
+	err = validateGroupBy(route.GroupBy)
+	if err != nil {
+		return fail(err)
+	}
+	for _, duration := range [][2]string{{"Group wait", route.GroupWait},
+		{"Group interval", route.GroupInterval}, {"Repeat interval", route.RepeatInterval}} {
+		if duration[1] != "" {
+			err = validateDuration(duration[0], duration[1])
+			if err != nil {
+				return fail(err)
+			}
+		}
+	}
+	for _, name := range splitList(route.MuteTimeIntervals) {
+		if !intervals[name] {
+			return fail(errors.New("Mute time interval " + name + " doesn't exist."))
+		}
+	}
+	for index := range route.Routes {
+		err = route.Routes[index].validate(path+"."+strconv.Itoa(index+1), receivers, intervals)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rule *InhibitRule) validate() error {
+	if _, err := parseMatchers(rule.SourceMatchers); err != nil {
+		return errors.New("Source matchers: " + err.Error())
+	}
+	targets, err := parseMatchers(rule.TargetMatchers)
+	if err != nil {
+		return errors.New("Target matchers: " + err.Error())
+	}
+	if len(targets) == 0 {
+		return errors.New("Target matchers are empty, the rule would inhibit every alert.")
+	}
+	for _, label := range splitList(rule.Equal) {
+		if !labelNamePattern.MatchString(label) {
+			return errors.New(label + " isn't a label name.")
+		}
+	}
+	return nil
+}
+
+func (interval *MuteTimeInterval) validate() error {
+	for _, times := range splitList(interval.Times) {
+		match := timeRangePattern.FindStringSubmatch(times)
+		if match == nil {
+			return errors.New("Time " + times + " isn't like 22:00-24:00.")
+		}
+		var minutes [2]int
+		for index := range minutes {
+			hour, _ := strconv.Atoi(match[1+index*2])
+			minute, _ := strconv.Atoi(match[2+index*2])
+			minutes[index] = hour*60 + minute
+			if minute > 59 || minutes[index] > 24*60 {
+				return errors.New("Time " + times + " is out of 00:00-24:00.")
+			}
+		}
+		if minutes[0] >= minutes[1] {
+			return errors.New("Time " + times + " ends before it starts.")
+		}
+	}
+	err := validateRanges(interval.Weekdays, "weekday", func(value string) (int, bool) {
+		return namedIndex(weekdays, value, 0)
+	})
+	if err != nil {
+		return err
+	}
+	// Like Alertmanager, the days counted from the end of the month are ordered as in a month of
+	// 28 days, and a range starting from the end of the month ends from it too
+	for _, item := range splitList(interval.DaysOfMonth) {
+		start, end, isRange := strings.Cut(item, ":")
+		if isRange && strings.HasPrefix(strings.TrimSpace(start), "-") && !strings.HasPrefix(strings.TrimSpace(end), "-") {
+			return errors.New("Range " + item + " starts from the end of the month, it must end from it too.")
+		}
+	}
+	err = validateRanges(interval.DaysOfMonth, "day of month", func(value string) (int, bool) {
+		day, err := strconv.Atoi(value)
+		if day < 0 {
+			return 28 + day, err == nil && day >= -31
+		}
+		return day, err == nil && day != 0 && day <= 31
+	})
+	if err != nil {
+		return err
+	}
+	err = validateRanges(interval.Months, "month", func(value string) (int, bool) {
+		if month, err := strconv.Atoi(value); err == nil {
+			return month, month >= 1 && month <= 12
+		}
+		return namedIndex(months, value, 1)
+	})
+	if err != nil {
+		return err
+	}
+	err = validateRanges(interval.Years, "year", func(value string) (int, bool) {
+		year, err := strconv.Atoi(value)
+		return year, err == nil && year > 0
+	})
+	if err != nil {
+		return err
+	}
+	if interval.Location != "" {
+		if _, err := time.LoadLocation(interval.Location); err != nil {
+			return errors.New("Location " + interval.Location + " isn't a time zone.")
+		}
+	}
+	return nil
+}
+
+// validateRanges checks the values and start:end ranges of list with parse, which returns the
+// position of a value.
+func validateRanges(list string, kind string, parse func(value string) (int, bool)) error {
+	for _, item := range splitList(list) {
+		start, end, isRange := strings.Cut(item, ":")
+		first, ok := parse(strings.ToLower(strings.TrimSpace(start)))
+		if !ok {
+			return errors.New(start + " isn't a " + kind + ".")
+		}
+		if !isRange {
+			continue
+		}
+		last, ok := parse(strings.ToLower(strings.TrimSpace(end)))
+		if !ok {
+			return errors.New(end + " isn't a " + kind + ".")
+		}
+		if first > last {
+			return errors.New("Range " + item + " ends before it starts.")
+		}
+	}
+	return nil
+}
+
+// namedIndex returns the position of value in names, counted from base.
+func namedIndex(names []string, value string, base int) (int, bool) {
+	for index, name := range names {
+		if name == value {
+			return index + base, true
+		}
+	}
+	return 0, false
+}
+
+func validateGroupBy(groupBy string) error {
+	for _, label := range splitList(groupBy) {
+		if label != "..." && !labelNamePattern.MatchString(label) {
+			return errors.New(label + " isn't a label name to group by.")
+		}
+	}
+	return nil
+}
+
+func validateDuration(name string, duration string) error {
+	if duration == "" || !durationPattern.MatchString(duration) {
+		return errors.New(name + " " + duration + " isn't a duration like 30s, 5m or 12h.")
+	}
+	if name != "Group wait" && strings.Trim(duration, "0ywdhms") == "" {
+		return errors.New(name + " can't be zero.")
+	}
+	return nil
+}
+
+// parseMatchers parses a comma separated list of matchers like amtool and Alertmanager 0.25 do,
+// e.g. severity="critical", namespace=~"default|kube-.*", optionally in braces. The values of the
+// regular expressions match whole label values, like in Alertmanager.
+func parseMatchers(list string) ([]matcher, error) {
+	list = strings.TrimSuffix(strings.TrimPrefix(list, "{"), "}")
+	var items []string
+	var item strings.Builder
+	quoted := false
+	escaped := false
+	for _, char := range list {
+		switch char {
+		case ',':
+			if !quoted {
+				items = append(items, item.String())
+				item.Reset()
+				continue
+			}
+		case '"':
+			if !escaped {
+				quoted = !quoted
+			} else {
+				escaped = false
+			}
+		case '\\':
+			escaped = !escaped
+		default:
+			escaped = false
+		}
+		item.WriteRune(char)
+	}
+	items = append(items, item.String())
+
+	var matchers []matcher
+	for _, text := range items {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		match := matcherPattern.FindStringSubmatch(text)
+		if match == nil {
+			return nil, errors.New("Matcher " + strings.TrimSpace(text) + " isn't like severity=\"critical\".")
+		}
+		value, err := unescapeMatcherValue(match[3])
+		if err != nil {
+			return nil, errors.New("Matcher " + strings.TrimSpace(text) + ": " + err.Error())
+		}
+		if match[2] == "=~" || match[2] == "!~" {
+			if _, err := regexp.Compile("^(?:" + value + ")$"); err != nil {
+				return nil, errors.New("Matcher " + strings.TrimSpace(text) + ": " + err.Error())
+			}
+		}
+		matchers = append(matchers, matcher{name: match[1], operator: match[2], value: value})
+	}
+	return matchers, nil
+}
+
+// unescapeMatcherValue returns the value of a matcher, quoted or not. Like Alertmanager, \n, \"
+// and \\ are escapes and a backslash before another character, like in \d, is kept.
+func unescapeMatcherValue(raw string) (string, error) {
+	quoted := strings.HasPrefix(raw, `"`)
+	if quoted {
+		raw = raw[1:]
+	}
+	var value strings.Builder
+	escaped := false
+	for index, char := range raw {
+		if escaped {
+			escaped = false
+			switch char {
+			case 'n':
+				value.WriteRune('\n')
+			case '"', '\\':
+				value.WriteRune(char)
+			default:
+				value.WriteRune('\\')
+				value.WriteRune(char)
+			}
+			continue
+		}
+		switch char {
+		case '\\':
+			if index < len(raw)-1 {
+				escaped = true
+			} else {
+				value.WriteRune(char)
+			}
+		case '"':
+			if !quoted || index < len(raw)-1 {
+				return "", errors.New("the value has a quote which isn't escaped.")
+			}
+			quoted = false
+		default:
+			value.WriteRune(char)
+		}
+	}
+	if quoted {
+		return "", errors.New("the quote of the value isn't closed.")
+	}
+	return value.String(), nil
+}
+
+// String returns the matcher as Alertmanager reads it.
+func (m matcher) String() string {
+	value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(m.value)
+	return m.name + ` ` + m.operator + ` "` + value + `"`
+}
+
+// matcherStrings returns the matchers of list as Alertmanager reads them, list being valid.
+func matcherStrings(list string) []string {
+	matchers, _ := parseMatchers(list)
+	result := []string{}
+	for _, m := range matchers {
+		result = append(result, m.String())
+	}
+	return result
+}
+
+// alertmanagerRoute returns the route of the Alertmanager config with its child routes.
+func (route *AlertRoute) alertmanagerRoute() map[string]interface{} {
+	result := map[string]interface{}{
+		"receiver": route.Receiver,
+		"matchers": matcherStrings(route.Matchers),
+		"continue": route.Continue,
+	}
+	if groupBy := splitList(route.GroupBy); len(groupBy) > 0 {
+		result["group_by"] = groupBy
+	}
+	for key, value := range map[string]string{"group_wait": route.GroupWait,
+		"group_interval": route.GroupInterval, "repeat_interval": route.RepeatInterval} {
+		if value != "" {
+			result[key] = value
+		}
+	}
+	if intervals := splitList(route.MuteTimeIntervals); len(intervals) > 0 {
+		result["mute_time_intervals"] = intervals
+	}
+	if len(route.Routes) > 0 {
+		var routes []interface{}
+		for index := range route.Routes {
+			routes = append(routes, route.Routes[index].alertmanagerRoute())
+		}
+		result["routes"] = routes
+	}
+	return result
+}
+
+// alertmanagerTimeInterval returns the time interval of the Alertmanager config.
+func (interval *MuteTimeInterval) alertmanagerTimeInterval() map[string]interface{} {
+	spec := map[string]interface{}{}
+	var times []interface{}
+	for _, item := range splitList(interval.Times) {
+		start, end, _ := strings.Cut(item, "-")
+		times = append(times, map[string]string{"start_time": start, "end_time": end})
+	}
+	if len(times) > 0 {
+		spec["times"] = times
+	}
+	for key, list := range map[string]string{"weekdays": interval.Weekdays, "days_of_month": interval.DaysOfMonth,
+		"months": interval.Months, "years": interval.Years} {
+		var items []string
+		for _, item := range splitList(list) {
+			items = append(items, strings.ToLower(strings.ReplaceAll(item, " ", "")))
+		}
+		if len(items) > 0 {
+			spec[key] = items
+		}
+	}
+	if interval.Location != "" {
+		spec["location"] = interval.Location
+	}
+	return map[string]interface{}{"name": interval.Name, "time_intervals": []interface{}{spec}}
+}
+
+// routingEnvs returns the grouping and timing of the root route, the inhibition rules and the
+// time intervals of the Alertmanager config.
+func (routing *AlertRouting) routingEnvs() []string {
+	inhibitRules := []interface{}{}
+	for _, rule := range routing.InhibitRules {
+		inhibitRule := map[string]interface{}{
+			"source_matchers": matcherStrings(rule.SourceMatchers),
+			"target_matchers": matcherStrings(rule.TargetMatchers),
+		}
+		if equal := splitList(rule.Equal); len(equal) > 0 {
+			inhibitRule["equal"] = equal
+		}
+		inhibitRules = append(inhibitRules, inhibitRule)
+	}
+	timeIntervals := []interface{}{}
+	for index := range routing.MuteTimeIntervals {
+		timeIntervals = append(timeIntervals, routing.MuteTimeIntervals[index].alertmanagerTimeInterval())
+	}
+	groupBy := splitList(routing.GroupBy)
+	if groupBy == nil {
+		groupBy = []string{}
+	}
+	return []string{
+		"IDO_ALERTMANAGER_GROUP_BY=" + flowYaml(groupBy),
+		"IDO_ALERTMANAGER_GROUP_WAIT=" + routing.GroupWait,
+		"IDO_ALERTMANAGER_GROUP_INTERVAL=" + routing.GroupInterval,
+		"IDO_ALERTMANAGER_REPEAT_INTERVAL=" + routing.RepeatInterval,
+		"IDO_ALERTMANAGER_INHIBIT_RULES=" + flowYaml(inhibitRules),
+		"IDO_ALERTMANAGER_TIME_INTERVALS=" + flowYaml(timeIntervals),
+	}
+}
+
+// alertmanagerCheckTask returns the task checking the Alertmanager config before Helm applies it,
+// reporting what it found like amtool check-config. The config checked is the one install.sh
+// renders with envs, the variables of the plan once it is built, which amtool checks too when it
+// is installed. The templates the receivers notify with are rendered for sample alerts in
+// timezone.
+func (config *PrometheusConfig) alertmanagerCheckTask(timezone string, envs *[]string) Task {
+	prometheus := *config
+	return Task{Name: "Check Alertmanager Config",
+		Func: func(ctx context.Context, dir string, output io.Writer) error {
+			err := prometheus.ValidateReceivers()
+			if err == nil {
+				err = prometheus.ValidateRouting()
+			}
+			if err == nil {
+				err = prometheus.Templates.Validate()
+			}
+			if err == nil {
+				err = prometheus.checkAlertTemplates(dir, timezone)
+			}
+			var rendered []byte
+			var alertmanager *alertmanagerConfig
+			if err == nil {
+				rendered, err = renderAlertmanagerConfig(dir, *envs)
+			}
+			if err == nil {
+				alertmanager, err = parseAlertmanagerConfig(rendered)
+			}
+			if err == nil {
+				_, err = amtoolCheckConfig(ctx, rendered, output)
+			}
+			if err != nil {
+				fmt.Fprintln(output, "FAILED: "+err.Error())
+				return err
+			}
+			fmt.Fprintln(output, "Found:")
+			fmt.Fprintf(output, " - a route with %d child routes\n", countRouteConfigs(alertmanager.Route))
+			fmt.Fprintf(output, " - %d inhibit rules\n", len(alertmanager.InhibitRules))
+			fmt.Fprintf(output, " - %d receivers\n", len(alertmanager.Receivers))
+			fmt.Fprintf(output, " - %d time intervals\n", len(alertmanager.TimeIntervals))
+			if prometheus.Templates.enabled() {
+				fmt.Fprintln(output, " - templates of "+prometheus.Templates.Set)
+			}
+			fmt.Fprintln(output, "SUCCESS")
+			return nil
+		}}
+}
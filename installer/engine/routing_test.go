@@ -0,0 +1,318 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMatchers(t *testing.T) {
+	tests := []struct {
+		list     string
+		matchers []string
+		err      string
+	}{
+		{list: "", matchers: []string{}},
+		{list: `severity="critical"`, matchers: []string{`severity = "critical"`}},
+		{list: `severity = critical , namespace!=default`,
+			matchers: []string{`severity = "critical"`, `namespace != "default"`}},
+		{list: `{severity=~"warning|info", job!~"node-.*"}`,
+			matchers: []string{`severity =~ "warning|info"`, `job !~ "node-.*"`}},
+		{list: `msg="a, b", instance=~"node\d+"`, matchers: []string{`msg = "a, b"`, `instance =~ "node\\d+"`}},
+		{list: `msg="say \"hi\"\n"`, matchers: []string{`msg = "say \"hi\"\n"`}},
+		{list: `path="C:\\dir"`, matchers: []string{`path = "C:\\dir"`}},
+		{list: `msg=\"quoted\"`, matchers: []string{`msg = "\"quoted\""`}},
+		{list: `empty=""`, matchers: []string{`empty = ""`}},
+		{list: `__name__:total="1"`, matchers: []string{`__name__:total = "1"`}},
+		{list: `severity="critical",`, matchers: []string{`severity = "critical"`}},
+		{list: `severity`, err: `Matcher severity isn't like severity="critical".`},
+		{list: `="critical"`, err: `Matcher ="critical" isn't like severity="critical".`},
+		{list: `1severity="critical"`, err: `Matcher 1severity="critical" isn't like severity="critical".`},
+		{list: `severity=="critical"`, err: `Matcher severity=="critical": the value has a quote which isn't escaped.`},
+		{list: `severity="critical`, err: `Matcher severity="critical: the quote of the value isn't closed.`},
+		{list: `severity=crit"ical`, err: `Matcher severity=crit"ical: the value has a quote which isn't escaped.`},
+		{list: `job=~"node-("`, err: "Matcher job=~\"node-(\": error parsing regexp: missing closing ): `^(?:node-()$`"},
+	}
+	for _, test := range tests {
+		t.Run(test.list, func(t *testing.T) {
+			matchers, err := parseMatchers(test.list)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Errorf("parseMatchers() = %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			strings := []string{}
+			for _, m := range matchers {
+				strings = append(strings, m.String())
+			}
+			if !reflect.DeepEqual(strings, test.matchers) {
+				t.Errorf("matchers = %q, want %q", strings, test.matchers)
+			}
+		})
+	}
+}
+
+func TestValidateDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration string
+		valid    bool
+	}{
+		{"Group wait", "30s", true},
+		{"Group wait", "0", true},
+		{"Group wait", "0s", true},
+		{"Group interval", "1h30m", true},
+		{"Repeat interval", "1w2d", true},
+		{"Group interval", "500ms", true},
+		{"Group interval", "0", false},
+		{"Repeat interval", "0h0m", false},
+		{"Group wait", "", false},
+		{"Group wait", "1.5h", false},
+		{"Group wait", "30m1h", false},
+		{"Group wait", "5 m", false},
+		{"Group wait", "-5m", false},
+		{"Group wait", "5", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name+" "+test.duration, func(t *testing.T) {
+			if err := validateDuration(test.name, test.duration); (err == nil) != test.valid {
+				t.Errorf("validateDuration() = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
+
+func TestMuteTimeIntervalValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval MuteTimeInterval
+		err      string
+	}{
+		{"any time", MuteTimeInterval{}, ""},
+		{"night", MuteTimeInterval{Times: "22:00-24:00, 00:00-06:00"}, ""},
+		{"start at 24:00", MuteTimeInterval{Times: "24:00-24:00"}, "Time 24:00-24:00 ends before it starts."},
+		{"after 24:00", MuteTimeInterval{Times: "23:00-24:30"}, "Time 23:00-24:30 is out of 00:00-24:00."},
+		{"minute 60", MuteTimeInterval{Times: "22:60-23:00"}, "Time 22:60-23:00 is out of 00:00-24:00."},
+		{"reversed times", MuteTimeInterval{Times: "22:00-06:00"}, "Time 22:00-06:00 ends before it starts."},
+		{"empty times", MuteTimeInterval{Times: "10:00-10:00"}, "Time 10:00-10:00 ends before it starts."},
+		{"one digit hour", MuteTimeInterval{Times: "9:00-17:00"}, "Time 9:00-17:00 isn't like 22:00-24:00."},
+		{"weekdays", MuteTimeInterval{Weekdays: "Monday:friday, sunday"}, ""},
+		{"reversed weekdays", MuteTimeInterval{Weekdays: "saturday:monday"}, "Range saturday:monday ends before it starts."},
+		{"unknown weekday", MuteTimeInterval{Weekdays: "mon"}, "mon isn't a weekday."},
+		{"days", MuteTimeInterval{DaysOfMonth: "1:7, 15, 31"}, ""},
+		{"last week", MuteTimeInterval{DaysOfMonth: "-7:-1"}, ""},
+		{"negative end", MuteTimeInterval{DaysOfMonth: "1:-1"}, ""},
+		{"reversed negative days", MuteTimeInterval{DaysOfMonth: "-1:-7"}, "Range -1:-7 ends before it starts."},
+		{"end before start", MuteTimeInterval{DaysOfMonth: "25:-5"}, "Range 25:-5 ends before it starts."},
+		{"negative start", MuteTimeInterval{DaysOfMonth: "-3:5"},
+			"Range -3:5 starts from the end of the month, it must end from it too."},
+		{"day 0", MuteTimeInterval{DaysOfMonth: "0"}, "0 isn't a day of month."},
+		{"day 32", MuteTimeInterval{DaysOfMonth: "32"}, "32 isn't a day of month."},
+		{"day -32", MuteTimeInterval{DaysOfMonth: "-32"}, "-32 isn't a day of month."},
+		{"months", MuteTimeInterval{Months: "january:3, December"}, ""},
+		{"reversed months", MuteTimeInterval{Months: "december:january"}, "Range december:january ends before it starts."},
+		{"month 13", MuteTimeInterval{Months: "13"}, "13 isn't a month."},
+		{"years", MuteTimeInterval{Years: "2024:2025"}, ""},
+		{"reversed years", MuteTimeInterval{Years: "2025:2024"}, "Range 2025:2024 ends before it starts."},
+		{"location", MuteTimeInterval{Location: "Asia/Shanghai"}, ""},
+		{"unknown location", MuteTimeInterval{Location: "Mars/Olympus"}, "Location Mars/Olympus isn't a time zone."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.interval.validate()
+			if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("validate() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidateRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *PrometheusConfig)
+		err    string
+	}{
+		{"default", func(config *PrometheusConfig) {}, ""},
+		{"routes", func(config *PrometheusConfig) {
+			config.Routing.MuteTimeIntervals = []MuteTimeInterval{{Name: "nights", Times: "22:00-24:00"}}
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops", Matchers: `team="ops"`, GroupWait: "0",
+				MuteTimeIntervals: "nights", Routes: []AlertRoute{{Receiver: "null", Matchers: `severity="info"`}}}}
+		}, ""},
+		{"unknown receiver", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops"}, {Receiver: "dev"}}
+		}, "Route 2: Receiver dev doesn't exist."},
+		{"unknown receiver of a child route", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops", Routes: []AlertRoute{{Receiver: "dev"}}}}
+		}, "Route 1.1: Receiver dev doesn't exist."},
+		{"empty receiver", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{}}
+		}, "Route 1: Receiver is empty."},
+		{"unknown time interval", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops", MuteTimeIntervals: "weekends"}}
+		}, "Route 1: Mute time interval weekends doesn't exist."},
+		{"time interval added twice", func(config *PrometheusConfig) {
+			config.Routing.MuteTimeIntervals = []MuteTimeInterval{{Name: "nights"}, {Name: "nights"}}
+		}, "Mute time interval nights is added twice."},
+		{"invalid time interval", func(config *PrometheusConfig) {
+			config.Routing.MuteTimeIntervals = []MuteTimeInterval{{Name: "nights", Times: "22:00-06:00"}}
+		}, "Mute time interval nights: Time 22:00-06:00 ends before it starts."},
+		{"zero repeat interval", func(config *PrometheusConfig) {
+			config.Routing.RepeatInterval = "0"
+		}, "Root route: Repeat interval can't be zero."},
+		{"empty group wait", func(config *PrometheusConfig) {
+			config.Routing.GroupWait = ""
+		}, "Root route: Group wait is empty."},
+		{"invalid route duration", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops", GroupInterval: "5 minutes"}}
+		}, "Route 1: Group interval 5 minutes isn't a duration like 30s, 5m or 12h."},
+		{"invalid group by", func(config *PrometheusConfig) {
+			config.Routing.GroupBy = "namespace, alert-name"
+		}, "Root route: alert-name isn't a label name to group by."},
+		{"group by everything", func(config *PrometheusConfig) {
+			config.Routing.GroupBy = "..."
+		}, ""},
+		{"invalid matcher", func(config *PrometheusConfig) {
+			config.Routing.Routes = []AlertRoute{{Receiver: "ops", Matchers: `team="ops`}}
+		}, `Route 1: Matcher team="ops: the quote of the value isn't closed.`},
+		{"inhibition without target", func(config *PrometheusConfig) {
+			config.Routing.InhibitRules = append(config.Routing.InhibitRules,
+				InhibitRule{SourceMatchers: `alertname="NodeDown"`})
+		}, "Inhibition rule 4: Target matchers are empty, the rule would inhibit every alert."},
+		{"inhibition with invalid equal", func(config *PrometheusConfig) {
+			config.Routing.InhibitRules[0].Equal = "namespace, 1job"
+		}, "Inhibition rule 1: 1job isn't a label name."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig().Prometheus
+			config.Receivers = []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.Webhook,
+				Url: "https://hooks.example.com/alerts"}}
+			test.modify(&config)
+			err := config.ValidateRouting()
+			if test.err == "" && err != nil || test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("ValidateRouting() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+// planTask returns the task of plan named name.
+func planTask(t *testing.T, plan *Plan, name string) Task {
+	for _, task := range plan.Tasks {
+		if task.Name == name {
+			return task
+		}
+	}
+	t.Fatalf("no %s task", name)
+	return Task{}
+}
+
+func TestAlertmanagerCheckTask(t *testing.T) {
+	config := NewConfig()
+	config.InstallPrometheus = true
+	config.BasicInfo.Host = "cluster.example.com"
+	config.BasicInfo.Proxy = ProxyConfig{Enabled: true, HttpsProxy: "http://proxy.example.com:3128"}
+	config.Prometheus.Receivers = []AlertReceiver{
+		{Name: "ops", Type: AlertReceiverTypes.Webhook, Url: "https://hooks.example.com/alerts"},
+		{Name: "dev", Type: AlertReceiverTypes.Webhook, Url: "https://hooks.example.com/dev"},
+		{Name: "mail", Type: AlertReceiverTypes.Email, SmtpHost: "smtp.example.com", SmtpPort: 25,
+			SmtpUsername: "alerts", SmtpPassword: "secret", EmailFrom: "alerts@example.com", EmailTo: "ops@example.com"},
+		{Name: "wecom", Type: AlertReceiverTypes.WeCom, CorpId: "corp", AgentId: "1000002", ApiSecret: "secret",
+			ToUser: "@all", Severities: "critical"},
+		{Name: "robot", Type: AlertReceiverTypes.DingTalk, AccessToken: "token", Namespaces: "default, kube-system"},
+	}
+	config.Prometheus.Templates.Set = AlertTemplateSets.Concise
+	config.Prometheus.Routing.MuteTimeIntervals = []MuteTimeInterval{{Name: "nights", Times: "22:00-24:00",
+		Weekdays: "monday:friday", DaysOfMonth: "-7:-1", Location: "Asia/Shanghai"}}
+	config.Prometheus.Routing.Routes = []AlertRoute{{Receiver: "ops", Matchers: `team="ops", job=~"node\\d+"`,
+		Routes: []AlertRoute{{Receiver: "dev", MuteTimeIntervals: "nights", GroupWait: "1m"}}}}
+
+	task := planTask(t, NewPlan(config), "Check Alertmanager Config")
+	var output bytes.Buffer
+	if err := task.Func(context.Background(), repoDir, &output); err != nil {
+		t.Fatalf("Func() = %v, output = %q", err, output.String())
+	}
+	// The watchdog route, the two routes of the tree and one route per receiver
+	want := "Found:\n - a route with 8 child routes\n - 3 inhibit rules\n - 6 receivers\n - 1 time intervals\n"
+	if !strings.Contains(output.String(), want) || !strings.HasSuffix(output.String(), "SUCCESS\n") {
+		t.Errorf("output = %q, want it to contain %q", output.String(), want)
+	}
+
+	config.Prometheus.Routing.Routes[0].Routes[0].Receiver = "qa"
+	task = planTask(t, NewPlan(config), "Check Alertmanager Config")
+	output.Reset()
+	err := task.Func(context.Background(), repoDir, &output)
+	if err == nil || output.String() != "FAILED: Route 1.1: Receiver qa doesn't exist.\n" {
+		t.Errorf("Func() = %v, output = %q", err, output.String())
+	}
+}
+
+func TestAlertmanagerCheckTaskRenderedConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		// env is set after the ones of the plan, like a bug of their rendering
+		env string
+		err string
+	}{
+		{name: "unclosed flow mapping", env: `IDO_ALERTMANAGER_RECEIVERS=    - {"name": "ops"`,
+			err: "Rendered values of Prometheus: yaml: line"},
+		{name: "quoted boolean", env: `IDO_ALERTMANAGER_RECEIVERS=    - {"name": "ops", "webhook_configs": ` +
+			`[{"url": "https://hooks.example.com/alerts", "send_resolved": "true"}]}`,
+			err: "Alertmanager config: yaml: unmarshal errors:"},
+		{name: "unknown key", env: `IDO_ALERTMANAGER_RECEIVERS=    - {"name": "ops", "webhook_config": ` +
+			`[{"url": "https://hooks.example.com/alerts"}]}`,
+			err: "field webhook_config not found"},
+		{name: "receiver indented as a route", env: `IDO_ALERTMANAGER_RECEIVERS=      - {"name": "ops"}`,
+			err: "Rendered values of Prometheus: yaml: line"},
+		{name: "route to a missing receiver", env: `IDO_ALERTMANAGER_ROUTES=      - {"receiver": "qa"}`,
+			err: "Alertmanager config: Route 2: Receiver qa doesn't exist."},
+		{name: "matcher of a route", env: `IDO_ALERTMANAGER_ROUTES=      - {"receiver": "ops", "matchers": ["severity =~ \"(critical\""]}`,
+			err: "Alertmanager config: Route 2: Matcher"},
+		{name: "duration", env: "IDO_ALERTMANAGER_GROUP_WAIT=30 seconds",
+			err: "Alertmanager config: Root route: Group wait 30 seconds isn't a duration"},
+		{name: "matcher of an inhibition rule", env: `IDO_ALERTMANAGER_INHIBIT_RULES=[{"source_matchers": ["severity"], ` +
+			`"target_matchers": ["severity=\"info\""]}]`,
+			err: "Alertmanager config: Inhibition rule 1: Matcher severity"},
+		{name: "time interval", env: `IDO_ALERTMANAGER_TIME_INTERVALS=[{"name": "nights", "time_intervals": ` +
+			`[{"times": [{"start_time": "22:00", "end_time": "25:00"}]}]}]`,
+			err: "Alertmanager config: Time interval nights: Time 22:00-25:00 is out of 00:00-24:00."},
+		{name: "proxy url", env: `IDO_ALERTMANAGER_RECEIVERS=    - {"name": "ops", "webhook_configs": ` +
+			`[{"url": "https://hooks.example.com/alerts", "http_config": {"proxy_url": "proxy.example.com"}}]}`,
+			err: "Alertmanager config: Receiver ops: Proxy URL proxy.example.com isn't an http or https URL."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			config.InstallPrometheus = true
+			config.BasicInfo.Host = "cluster.example.com"
+			config.Prometheus.Receivers = []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.Webhook,
+				Url: "https://hooks.example.com/alerts"}}
+			plan := NewPlan(config)
+			plan.Envs = append(plan.Envs, test.env)
+			task := config.Prometheus.alertmanagerCheckTask("UTC", &plan.Envs)
+
+			var output bytes.Buffer
+			err := task.Func(context.Background(), repoDir, &output)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Func() = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEnvsubst(t *testing.T) {
+	t.Setenv("IDO_FROM_ENVIRONMENT", "environment")
+	envs := []string{"IDO_HOST=cluster.example.com", "IDO_FROM_ENVIRONMENT=plan"}
+	text := "${IDO_HOST} $IDO_HOST ${IDO_FROM_ENVIRONMENT} ${IDO_UNSET}|$1 $ {IDO_HOST} $$"
+	want := "cluster.example.com cluster.example.com plan |$1 $ {IDO_HOST} $$"
+	if got := envsubst(text, envs); got != want {
+		t.Errorf("envsubst() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,544 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// AlertTemplates are the templates of the notifications of the receivers: a set bundled in
+// AlertTemplatesDir, or the .tmpl files of Files.
+type AlertTemplates struct {
+	Set string
+	// Files are the comma separated .tmpl files of the custom set
+	Files string
+}
+
+type AlertTemplateSet struct {
+	Default        string
+	Concise        string
+	ConciseChinese string
+	Custom         string
+}
+
+var AlertTemplateSets = AlertTemplateSet{
+	Default:        "Default of Alertmanager and DingTalk",
+	Concise:        "Concise",
+	ConciseChinese: "Concise Chinese (简体中文)",
+	Custom:         "Custom .tmpl files",
+}
+
+const (
+	// AlertTemplatesDir holds a directory per bundled set, with the templates of Alertmanager and
+	// of the DingTalk webhook.
+	AlertTemplatesDir = "packages/prometheus/templates"
+	// alertTemplatesValues and dingTalkTemplatesValues are the values files shipping the
+	// templates, JSON so that envsubst never sees the $ of the templates.
+	alertTemplatesValues    = "packages/prometheus/values-templates.json"
+	dingTalkTemplatesValues = "packages/prometheus/values-dingtalk-templates.json"
+)
+
+// alertTemplateSetDirs are the directories of the bundled sets.
+var alertTemplateSetDirs = map[string]string{
+	AlertTemplateSets.Concise:        "concise",
+	AlertTemplateSets.ConciseChinese: "concise-zh",
+}
+
+// Templates the receivers notify with, which the sets and the custom files define.
+const (
+	emailSubjectTemplate    = "om_kits.email.subject"
+	emailHtmlTemplate       = "om_kits.email.html"
+	wechatMessageTemplate   = "om_kits.wechat.message"
+	dingTalkTitleTemplate   = "om_kits.dingtalk.title"
+	dingTalkContentTemplate = "om_kits.dingtalk.content"
+)
+
+var templateFileNamePattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+\.tmpl$`)
+
+// defaultTemplateHelpers are the helpers of the default templates of Alertmanager which custom
+// templates often call, for the preview.
+const defaultTemplateHelpers = `
+{{ define "__alertmanager" }}Alertmanager{{ end }}
+{{ define "__alertmanagerURL" }}{{ .ExternalURL }}/#/alerts?receiver={{ .Receiver | urlquery }}{{ end }}
+{{ define "__subject" }}[{{ .Status | toUpper }}{{ if eq .Status "firing" }}:{{ .Alerts.Firing | len }}{{ end }}] {{ .GroupLabels.SortedPairs.Values | join " " }} {{ if gt (len .CommonLabels) (len .GroupLabels) }}({{ with .CommonLabels.Remove .GroupLabels.Names }}{{ .Values | join " " }}{{ end }}){{ end }}{{ end }}
+{{ define "__description" }}{{ end }}
+{{ define "__text_alert_list" }}{{ range . }}Labels:
+{{ range .Labels.SortedPairs }} - {{ .Name }} = {{ .Value }}
+{{ end }}Annotations:
+{{ range .Annotations.SortedPairs }} - {{ .Name }} = {{ .Value }}
+{{ end }}Source: {{ .GeneratorURL }}
+{{ end }}{{ end }}
+`
+
+func (templates *AlertTemplates) Validate() error {
+	if templates.Set != AlertTemplateSets.Custom {
+		return nil
+	}
+	files := splitList(templates.Files)
+	if len(files) == 0 {
+		return errors.New("Please give the .tmpl files of the custom templates.")
+	}
+	names := map[string]bool{}
+	for index, file := range files {
+		if !templateFileNamePattern.MatchString(filepath.Base(file)) {
+			return errors.New("Template file " + file + " isn't named like notifications.tmpl.")
+		}
+		if names[filepath.Base(file)] {
+			return errors.New("Two template files are named " + filepath.Base(file) + ".")
+		}
+		names[filepath.Base(file)] = true
+		if _, err := os.Stat(file); err != nil {
+			return errors.New("Template file " + file + " doesn't exist.")
+		}
+		// Tasks don't run in the current directory
+		files[index], _ = filepath.Abs(file)
+	}
+	templates.Files = strings.Join(files, ",")
+	return nil
+}
+
+// enabled reports whether the receivers notify with the templates rather than the defaults.
+func (templates *AlertTemplates) enabled() bool {
+	return templates.Set != "" && templates.Set != AlertTemplateSets.Default
+}
+
+// load returns the template files of Alertmanager by name and the template of the DingTalk
+// webhook, dir being the one containing packages/.
+func (templates *AlertTemplates) load(dir string) (map[string]string, string, error) {
+	files := map[string]string{}
+	if !templates.enabled() {
+		return files, "", nil
+	}
+	if templates.Set == AlertTemplateSets.Custom {
+		// The webhook reads one file, which gets all of them
+		var dingTalk []string
+		for _, file := range splitList(templates.Files) {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, "", err
+			}
+			files[filepath.Base(file)] = string(content)
+			dingTalk = append(dingTalk, string(content))
+		}
+		return files, strings.Join(dingTalk, "\n"), nil
+	}
+
+	setDir, ok := alertTemplateSetDirs[templates.Set]
+	if !ok {
+		return nil, "", errors.New("Template set " + templates.Set + " doesn't exist.")
+	}
+	setDir = filepath.Join(dir, AlertTemplatesDir, setDir)
+	alertmanager, err := os.ReadFile(filepath.Join(setDir, "alertmanager.tmpl"))
+	if err != nil {
+		return nil, "", err
+	}
+	dingTalk, err := os.ReadFile(filepath.Join(setDir, "dingtalk.tmpl"))
+	if err != nil {
+		return nil, "", err
+	}
+	files["om-kits-templates.tmpl"] = string(alertmanager)
+	return files, string(dingTalk), nil
+}
+
+// WriteAlertTemplates writes the values files shipping the templates to Alertmanager and the
+// DingTalk webhook into dir.
+func WriteAlertTemplates(dir string, templates AlertTemplates, timezone string, output io.Writer) error {
+	files, dingTalk, err := templates.load(dir)
+	if err != nil {
+		return err
+	}
+	if dingTalk != "" {
+		dingTalk = timeTemplate(timezone) + "\n" + dingTalk
+	}
+
+	values := []struct {
+		file   string
+		values interface{}
+	}{
+		{alertTemplatesValues, map[string]interface{}{"alertmanager": map[string]interface{}{"templateFiles": files}}},
+		{dingTalkTemplatesValues, map[string]interface{}{"template": dingTalk}},
+	}
+	for _, value := range values {
+		content, err := json.MarshalIndent(value.values, "", "  ")
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filepath.Join(dir, value.file), content, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	if templates.enabled() {
+		var names []string
+		for name := range files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintln(output, "Templates of "+templates.Set+": "+strings.Join(names, ", "))
+	} else {
+		fmt.Fprintln(output, "The receivers notify with the default templates.")
+	}
+	return nil
+}
+
+// templateKV is the label set of the templates of Alertmanager.
+type templateKV map[string]string
+
+type templatePair struct {
+	Name  string
+	Value string
+}
+
+type templatePairs []templatePair
+
+func (kv templateKV) SortedPairs() templatePairs {
+	var pairs templatePairs
+	for name, value := range kv {
+		pairs = append(pairs, templatePair{name, value})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		// alertname comes first, like in Alertmanager
+		if pairs[i].Name == "alertname" || pairs[j].Name == "alertname" {
+			return pairs[i].Name == "alertname"
+		}
+		return pairs[i].Name < pairs[j].Name
+	})
+	return pairs
+}
+
+func (kv templateKV) Remove(names []string) templateKV {
+	result := templateKV{}
+	for name, value := range kv {
+		result[name] = value
+	}
+	for _, name := range names {
+		delete(result, name)
+	}
+	return result
+}
+
+func (kv templateKV) Names() []string {
+	return kv.SortedPairs().Names()
+}
+
+func (kv templateKV) Values() []string {
+	return kv.SortedPairs().Values()
+}
+
+func (pairs templatePairs) Names() []string {
+	var names []string
+	for _, pair := range pairs {
+		names = append(names, pair.Name)
+	}
+	return names
+}
+
+func (pairs templatePairs) Values() []string {
+	var values []string
+	for _, pair := range pairs {
+		values = append(values, pair.Value)
+	}
+	return values
+}
+
+type templateAlert struct {
+	Status       string
+	Labels       templateKV
+	Annotations  templateKV
+	StartsAt     time.Time
+	EndsAt       time.Time
+	GeneratorURL string
+	Fingerprint  string
+}
+
+type templateAlerts []templateAlert
+
+func (alerts templateAlerts) Firing() templateAlerts {
+	return alerts.withStatus("firing")
+}
+
+func (alerts templateAlerts) Resolved() templateAlerts {
+	return alerts.withStatus("resolved")
+}
+
+func (alerts templateAlerts) withStatus(status string) templateAlerts {
+	var result templateAlerts
+	for _, alert := range alerts {
+		if alert.Status == status {
+			result = append(result, alert)
+		}
+	}
+	return result
+}
+
+// templateData is the data the templates of Alertmanager and of the DingTalk webhook render.
+type templateData struct {
+	Receiver          string
+	Status            string
+	Alerts            templateAlerts
+	GroupLabels       templateKV
+	CommonLabels      templateKV
+	CommonAnnotations templateKV
+	ExternalURL       string
+}
+
+// sampleTemplateData returns a group of two alerts of a namespace, firing or resolved.
+func sampleTemplateData(resolved bool) *templateData {
+	startsAt := time.Now().Add(-15 * time.Minute).UTC().Truncate(time.Second)
+	data := &templateData{
+		Receiver:          "ops",
+		Status:            "firing",
+		GroupLabels:       templateKV{"namespace": "default"},
+		CommonLabels:      templateKV{"alertname": "KubePodCrashLooping", "namespace": "default"},
+		CommonAnnotations: templateKV{},
+		ExternalURL:       "http://cluster.example.com/alertmanager",
+	}
+	for index, pod := range []string{"web-5d9f7c8b6-x2x4k", "worker-7c6b9d5f4-q8n2m"} {
+		severity := []string{"critical", "warning"}[index]
+		alert := templateAlert{
+			Status: "firing",
+			Labels: templateKV{"alertname": "KubePodCrashLooping", "namespace": "default", "pod": pod,
+				"severity": severity},
+			Annotations: templateKV{
+				"summary":     "Pod is crash looping.",
+				"description": "Pod default/" + pod + " is in waiting state (reason: \"CrashLoopBackOff\").",
+			},
+			StartsAt:     startsAt,
+			GeneratorURL: "http://cluster.example.com/prometheus/graph",
+			Fingerprint:  fmt.Sprintf("%016x", index+1),
+		}
+		if resolved {
+			alert.Status = "resolved"
+			alert.EndsAt = startsAt.Add(10 * time.Minute)
+		}
+		data.Alerts = append(data.Alerts, alert)
+	}
+	if resolved {
+		data.Status = "resolved"
+	}
+	return data
+}
+
+// alertmanagerFuncs are the functions of the templates of Alertmanager 0.25.
+var alertmanagerFuncs = template.FuncMap{
+	"toUpper":   strings.ToUpper,
+	"toLower":   strings.ToLower,
+	"title":     strings.Title,
+	"trimSpace": strings.TrimSpace,
+	"join": func(separator string, items []string) string {
+		return strings.Join(items, separator)
+	},
+	"match":        regexp.MatchString,
+	"safeHtml":     func(text string) htmltemplate.HTML { return htmltemplate.HTML(text) },
+	"reReplaceAll": reReplaceAll,
+	"stringSlice":  func(items ...string) []string { return items },
+}
+
+// dingTalkFuncs are the functions of the templates of the DingTalk webhook 2.1.0, which has
+// markdown but neither match nor trimSpace.
+var dingTalkFuncs = template.FuncMap{
+	"toUpper": strings.ToUpper,
+	"toLower": strings.ToLower,
+	"title":   strings.Title,
+	"join": func(separator string, items []string) string {
+		return strings.Join(items, separator)
+	},
+	"markdown":     markdownEscape,
+	"safeHtml":     func(text string) htmltemplate.HTML { return htmltemplate.HTML(text) },
+	"reReplaceAll": reReplaceAll,
+	"stringSlice":  func(items ...string) []string { return items },
+}
+
+func reReplaceAll(pattern string, replacement string, text string) string {
+	return regexp.MustCompile(pattern).ReplaceAllString(text, replacement)
+}
+
+// markdownEscape escapes the _, * and ` of text like the markdown function of the DingTalk
+// webhook.
+func markdownEscape(text string) string {
+	var escaped strings.Builder
+	for _, char := range text {
+		if strings.ContainsRune("_*`", char) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(char)
+	}
+	return escaped.String()
+}
+
+// parseAlertTemplates parses the templates of Alertmanager, or of the DingTalk webhook, with
+// their functions and the helpers they may call.
+func parseAlertTemplates(funcs template.FuncMap, timezone string, contents ...string) (*template.Template, error) {
+	parsed, err := template.New("").Funcs(funcs).Parse(defaultTemplateHelpers + timeTemplate(timezone))
+	if err != nil {
+		return nil, err
+	}
+	for _, content := range contents {
+		parsed, err = parsed.Parse(content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// renderAlertTemplate renders the template name of parsed with data.
+func renderAlertTemplate(parsed *template.Template, name string, data *templateData) (string, error) {
+	if parsed.Lookup(name) == nil {
+		return "", errors.New("Template " + name + " isn't defined.")
+	}
+	var buffer bytes.Buffer
+	err := parsed.ExecuteTemplate(&buffer, name, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// previewTemplates are the templates of the preview, the DingTalk ones being rendered by the
+// webhook.
+var previewTemplates = []struct {
+	title    string
+	name     string
+	dingTalk bool
+}{
+	{"DingTalk title", dingTalkTitleTemplate, true},
+	{"DingTalk markdown", dingTalkContentTemplate, true},
+	{"Email subject", emailSubjectTemplate, false},
+	{"Email HTML", emailHtmlTemplate, false},
+	{"WeCom message", wechatMessageTemplate, false},
+}
+
+// PreviewAlertTemplates renders the notifications of a sample group of firing alerts, or of
+// resolved ones, with templates, dir being the one containing packages/.
+func PreviewAlertTemplates(dir string, templates AlertTemplates, timezone string, resolved bool) (string, error) {
+	if !templates.enabled() {
+		return "The receivers notify with the default templates of Alertmanager and of the DingTalk webhook.", nil
+	}
+	alertmanager, err := templates.parseAlertmanager(dir, timezone)
+	if err != nil {
+		return "", err
+	}
+	// The DingTalk previews show why the webhook can't parse the templates
+	dingTalk, dingTalkErr := templates.parseDingTalk(dir, timezone)
+
+	data := sampleTemplateData(resolved)
+	var preview strings.Builder
+	for _, previewTemplate := range previewTemplates {
+		text, err := "", dingTalkErr
+		if !previewTemplate.dingTalk {
+			text, err = renderAlertTemplate(alertmanager, previewTemplate.name, data)
+		} else if dingTalkErr == nil {
+			text, err = renderAlertTemplate(dingTalk, previewTemplate.name, data)
+		}
+		if err != nil {
+			text = "(" + err.Error() + ")"
+		}
+		preview.WriteString("===== " + previewTemplate.title + " =====\n" + strings.TrimSpace(text) + "\n\n")
+	}
+	return preview.String(), nil
+}
+
+// parseAlertmanager returns the parsed templates of Alertmanager.
+func (templates *AlertTemplates) parseAlertmanager(dir string, timezone string) (*template.Template, error) {
+	files, _, err := templates.load(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Alertmanager loads its files in the order of their names
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var contents []string
+	for _, name := range names {
+		contents = append(contents, files[name])
+	}
+	parsed, err := parseAlertTemplates(alertmanagerFuncs, timezone, contents...)
+	if err != nil {
+		return nil, errors.New("Alertmanager templates: " + err.Error())
+	}
+	return parsed, nil
+}
+
+// parseDingTalk returns the parsed templates of the DingTalk webhook, which only matter to the
+// DingTalk receivers.
+func (templates *AlertTemplates) parseDingTalk(dir string, timezone string) (*template.Template, error) {
+	_, dingTalk, err := templates.load(dir)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseAlertTemplates(dingTalkFuncs, timezone, dingTalk)
+	if err != nil {
+		return nil, errors.New("DingTalk templates: " + err.Error())
+	}
+	return parsed, nil
+}
+
+// checkAlertTemplates renders the templates the receivers notify with for sample firing and
+// resolved alerts.
+func (config *PrometheusConfig) checkAlertTemplates(dir string, timezone string) error {
+	if !config.Templates.enabled() {
+		return nil
+	}
+	alertmanager, err := config.Templates.parseAlertmanager(dir, timezone)
+	if err != nil {
+		return err
+	}
+	var dingTalk *template.Template
+	for _, receiver := range config.Receivers {
+		parsed := alertmanager
+		var names []string
+		switch receiver.Type {
+		case AlertReceiverTypes.DingTalk:
+			if dingTalk == nil {
+				dingTalk, err = config.Templates.parseDingTalk(dir, timezone)
+				if err != nil {
+					return err
+				}
+			}
+			parsed = dingTalk
+			names = []string{dingTalkTitleTemplate, dingTalkContentTemplate}
+		case AlertReceiverTypes.Email:
+			names = []string{emailSubjectTemplate, emailHtmlTemplate}
+		case AlertReceiverTypes.WeCom:
+			names = []string{wechatMessageTemplate}
+		}
+		for _, name := range names {
+			for _, resolved := range []bool{false, true} {
+				_, err = renderAlertTemplate(parsed, name, sampleTemplateData(resolved))
+				if err != nil {
+					return errors.New("Receiver " + receiver.Name + ": " + err.Error())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// alertTemplatesTask returns the task writing the values files of the templates.
+func (config *PrometheusConfig) alertTemplatesTask(timezone string) Task {
+	templates := config.Templates
+	return Task{Name: "Generate Alert Templates",
+		Func: func(ctx context.Context, dir string, output io.Writer) error {
+			return WriteAlertTemplates(dir, templates, timezone, output)
+		}}
+}
+
+// templateReference returns the Alertmanager config calling the template name.
+func templateReference(name string) string {
+	return `{{ template "` + name + `" . }}`
+}
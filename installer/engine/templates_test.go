@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// repoDir is the directory containing packages/.
+const repoDir = "../.."
+
+func TestBundledAlertTemplates(t *testing.T) {
+	for _, set := range []string{AlertTemplateSets.Concise, AlertTemplateSets.ConciseChinese} {
+		templates := AlertTemplates{Set: set}
+		alertmanager, err := templates.parseAlertmanager(repoDir, "Asia/Shanghai")
+		if err != nil {
+			t.Fatalf("%s: %v", set, err)
+		}
+		dingTalk, err := templates.parseDingTalk(repoDir, "Asia/Shanghai")
+		if err != nil {
+			t.Fatalf("%s: %v", set, err)
+		}
+		for _, previewTemplate := range previewTemplates {
+			parsed := alertmanager
+			if previewTemplate.dingTalk {
+				parsed = dingTalk
+			}
+			for _, resolved := range []bool{false, true} {
+				text, err := renderAlertTemplate(parsed, previewTemplate.name, sampleTemplateData(resolved))
+				if err != nil {
+					t.Errorf("%s, %s, resolved %v: %v", set, previewTemplate.name, resolved, err)
+				} else if strings.TrimSpace(text) == "" {
+					t.Errorf("%s, %s, resolved %v: empty", set, previewTemplate.name, resolved)
+				}
+			}
+		}
+
+		text, err := renderAlertTemplate(dingTalk, dingTalkContentTemplate, sampleTemplateData(false))
+		if err == nil && !strings.Contains(text, "KubePodCrashLooping") {
+			t.Errorf("%s: DingTalk markdown doesn't name the alert: %s", set, text)
+		}
+	}
+}
+
+func TestCustomAlertTemplates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "custom.tmpl")
+	content := `{{ define "om_kits.email.subject" }}{{ .CommonLabels.alertname | trimSpace | title }}{{ end }}
+{{ define "om_kits.email.html" }}{{ if match "^web-" (index .Alerts 0).Labels.pod }}web{{ end }}{{ end }}
+{{ define "om_kits.wechat.message" }}{{ stringSlice "a" "b" | join ", " }}{{ end }}`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	templates := AlertTemplates{Set: AlertTemplateSets.Custom, Files: file}
+
+	config := &PrometheusConfig{Templates: templates,
+		Receivers: []AlertReceiver{{Name: "ops", Type: AlertReceiverTypes.Email}}}
+	if err := config.checkAlertTemplates(repoDir, "UTC"); err != nil {
+		t.Errorf("checkAlertTemplates() = %v", err)
+	}
+
+	// The DingTalk webhook has neither trimSpace nor match
+	config.Receivers = append(config.Receivers, AlertReceiver{Name: "team", Type: AlertReceiverTypes.DingTalk})
+	if err := config.checkAlertTemplates(repoDir, "UTC"); err == nil || !strings.Contains(err.Error(), "DingTalk") {
+		t.Errorf("checkAlertTemplates() = %v, want a DingTalk error", err)
+	}
+
+	preview, err := PreviewAlertTemplates(repoDir, templates, "UTC", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"===== Email subject =====\nKubePodCrashLooping\n", "===== Email HTML =====\nweb\n",
+		"===== WeCom message =====\na, b\n", `function "trimSpace" not defined`} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview doesn't contain %q:\n%s", want, preview)
+		}
+	}
+}
+
+func TestMarkdownEscape(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"KubePodCrashLooping", "KubePodCrashLooping"},
+		{"kube_pod_info *", `kube\_pod\_info \*`},
+		{"`web` 容器", "\\`web\\` 容器"},
+	}
+	for _, test := range tests {
+		if escaped := markdownEscape(test.text); escaped != test.want {
+			t.Errorf("markdownEscape(%q) = %q, want %q", test.text, escaped, test.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	// LoadLocation works on hosts without a tz database as well
+	_ "time/tzdata"
+)
+
+var zoneinfoDirs = []string{"/usr/share/zoneinfo", "/usr/share/lib/zoneinfo", "/usr/lib/locale/TZ"}
+
+//go:generate go run gen_timezones.go
+
+// ListTimezones returns the zones of the IANA tz database installed on the host, sorted, or the
+// ones embedded in the installer when the host has no tz database.
+func ListTimezones() []string {
+	dirs := zoneinfoDirs
+	if zoneinfo := os.Getenv("ZONEINFO"); zoneinfo != "" {
+		dirs = append([]string{zoneinfo}, dirs...)
+	}
+
+	for _, dir := range dirs {
+		var zones []string
+		filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			name := entry.Name()
+			if entry.IsDir() {
+				// posix and right are copies of the zones with other leap second handling
+				if path != dir && (name == "posix" || name == "right") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// Zone names start with an upper case letter, unlike zone.tab, tzdata.zi or posixrules
+			if name[0] < 'A' || name[0] > 'Z' || strings.Contains(name, ".") || !isTzif(path) {
+				return nil
+			}
+			zone, _ := filepath.Rel(dir, path)
+			zones = append(zones, filepath.ToSlash(zone))
+			return nil
+		})
+		if len(zones) > 0 {
+			sort.Strings(zones)
+			return zones
+		}
+	}
+	return append([]string(nil), embeddedTimezones...)
+}
+
+func isTzif(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	_, err = file.Read(magic)
+	return err == nil && bytes.Equal(magic, []byte("TZif"))
+}
+
+// ValidateTimezone checks that timezone is a zone of the tz database.
+func ValidateTimezone(timezone string) error {
+	if timezone == "" {
+		return errors.New("Timezone is empty.")
+	}
+	_, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return errors.New("Timezone " + timezone + " isn't in the tz database.")
+	}
+	return nil
+}
+
+// timeTemplate returns the om_kits.time template of Alertmanager and the DingTalk webhook, which
+// formats a time in timezone. Their templates can't load a location, so the template switches
+// between the UTC offsets of timezone at its transitions from a year ago to ten years ahead.
+func timeTemplate(timezone string) string {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	now := time.Now()
+	start := now.AddDate(-1, 0, 0)
+	transitions := timezoneTransitions(location, start, now.AddDate(10, 0, 0))
+
+	format := func(offset int) string {
+		return `{{ (.Add ` + strconv.FormatInt(int64(offset)*int64(time.Second), 10) +
+			`).Format "2006-01-02 15:04:05" }}`
+	}
+	_, offset := start.In(location).Zone()
+	var template strings.Builder
+	template.WriteString(`{{ define "om_kits.time" }}`)
+	for index, transition := range transitions {
+		if index == 0 {
+			template.WriteString(`{{ if `)
+		} else {
+			template.WriteString(`{{ else if `)
+		}
+		template.WriteString(`lt .Unix ` + strconv.FormatInt(transition.Unix(), 10) + ` }}` + format(offset))
+		_, offset = transition.In(location).Zone()
+	}
+	if len(transitions) > 0 {
+		template.WriteString(`{{ else }}` + format(offset) + `{{ end }}`)
+	} else {
+		template.WriteString(format(offset))
+	}
+	template.WriteString(` ` + timezone + `{{ end }}`)
+	return template.String()
+}
+
+// timezoneTransitions returns the times between start and end when the UTC offset of location
+// changes, to the second.
+func timezoneTransitions(location *time.Location, start time.Time, end time.Time) []time.Time {
+	var transitions []time.Time
+	offsetAt := func(moment time.Time) int {
+		_, offset := moment.In(location).Zone()
+		return offset
+	}
+	previous := start.Truncate(time.Hour)
+	for moment := previous.Add(time.Hour); moment.Before(end); moment = moment.Add(time.Hour) {
+		if offsetAt(moment) != offsetAt(previous) {
+			// The offset changes within the hour before moment
+			low, high := previous, moment
+			for high.Sub(low) > time.Second {
+				middle := low.Add(high.Sub(low) / 2).Truncate(time.Second)
+				if offsetAt(middle) == offsetAt(previous) {
+					low = middle
+				} else {
+					high = middle
+				}
+			}
+			transitions = append(transitions, high)
+		}
+		previous = moment
+	}
+	return transitions
+}
@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestListTimezonesWithoutTzDatabase(t *testing.T) {
+	defer func(dirs []string) { zoneinfoDirs = dirs }(zoneinfoDirs)
+	zoneinfoDirs = []string{t.TempDir()}
+	t.Setenv("ZONEINFO", "")
+
+	zones := ListTimezones()
+	for _, zone := range []string{"Asia/Shanghai", "America/New_York", "UTC"} {
+		if !slices.Contains(zones, zone) {
+			t.Errorf("%s isn't listed", zone)
+		}
+	}
+	if !slices.IsSorted(zones) {
+		t.Error("zones aren't sorted")
+	}
+}
+
+func TestTimezoneTransitions(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	transitions := timezoneTransitions(location, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2028, 1, 1, 0, 0, 0, 0, time.UTC))
+	want := []time.Time{
+		time.Date(2027, 3, 14, 7, 0, 0, 0, time.UTC),
+		time.Date(2027, 11, 7, 6, 0, 0, 0, time.UTC),
+	}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for index := range want {
+		if !transitions[index].Equal(want[index]) {
+			t.Errorf("transition %d = %v, want %v", index, transitions[index], want[index])
+		}
+	}
+}
+
+func TestTimeTemplate(t *testing.T) {
+	year := time.Now().Year() + 1
+	tests := []struct {
+		timezone string
+		moment   time.Time
+		want     string
+	}{
+		{"Asia/Shanghai", time.Date(year, 7, 1, 12, 0, 0, 0, time.UTC),
+			"20:00:00 Asia/Shanghai"},
+		{"America/New_York", time.Date(year, 1, 15, 12, 0, 0, 0, time.UTC),
+			"07:00:00 America/New_York"},
+		{"America/New_York", time.Date(year, 7, 15, 12, 0, 0, 0, time.UTC),
+			"08:00:00 America/New_York"},
+		{"Europe/Berlin", time.Date(year, 7, 15, 12, 0, 0, 0, time.UTC),
+			"14:00:00 Europe/Berlin"},
+	}
+	for _, test := range tests {
+		t.Run(test.timezone+" "+test.moment.Format("Jan"), func(t *testing.T) {
+			parsed, err := template.New("").Parse(timeTemplate(test.timezone))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			if err = parsed.ExecuteTemplate(&buffer, "om_kits.time", test.moment); err != nil {
+				t.Fatal(err)
+			}
+			location, _ := time.LoadLocation(test.timezone)
+			want := test.moment.In(location).Format("2006-01-02 ") + test.want
+			if buffer.String() != want {
+				t.Errorf("om_kits.time = %q, want %q", buffer.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// CaBundleConfigMap is the ConfigMap with the CA bundle in the namespaces of the components
+	// making outbound TLS calls.
+	CaBundleConfigMap = "om-kits-ca-bundle"
+	// CaBundleFile is the bundle generated in the working directory of the executor: the CAs
+	// trusted by the system and the ones of the CA bundle setting.
+	CaBundleFile = "trust/ca-bundle.crt"
+	// caBundleMountPath is where the components find the bundle, the first CA file Go and most
+	// distributions look for.
+	caBundleMountPath = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// systemCaFiles are the CA bundles of the common distributions, as Go looks for them.
+var systemCaFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// trustedRoots are the CAs the installer verifies servers with, nil for the ones of the system.
+var trustedRoots *x509.CertPool
+
+// ValidateCaBundle checks that file holds PEM certificates.
+func ValidateCaBundle(file string) error {
+	_, err := readCertificates(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.New("CA bundle " + file + " doesn't exist.")
+	}
+	if err != nil {
+		return errors.New("Can't read CA bundle " + file + ": " + err.Error())
+	}
+	return nil
+}
+
+// TrustCaBundle makes the connectivity checks, registry calls and notifications of the
+// installer trust the CAs of file besides the ones of the system. An empty file trusts the
+// system only.
+func TrustCaBundle(file string) error {
+	var roots *x509.CertPool
+	if file != "" {
+		certs, err := readCertificates(file)
+		if err != nil {
+			return err
+		}
+		roots, err = x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		for _, cert := range certs {
+			roots.AddCert(cert)
+		}
+	}
+	trustedRoots = roots
+	updateTransports()
+	return nil
+}
+
+// updateTransports makes the HTTP clients of the installer trust trustedRoots and use the
+// proxy.
+func updateTransports() {
+	for _, client := range []*http.Client{acmeClient, notifyClient, registryHttpClient, registryTransferClient} {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: trustedRoots}
+		transport.Proxy = proxyFor
+		client.Transport = transport
+	}
+}
+
+// WriteCaBundle writes the CAs trusted by the system followed by the ones of bundle to file.
+func WriteCaBundle(file string, bundle string, output io.Writer) error {
+	var content []byte
+	for _, systemFile := range systemCaFiles {
+		systemContent, err := os.ReadFile(systemFile)
+		if err == nil {
+			content = append(systemContent, '\n')
+			fmt.Fprintln(output, "System CAs read from "+systemFile)
+			break
+		}
+	}
+	if content == nil {
+		fmt.Fprintln(output, "No system CA bundle found, only the CAs of "+bundle+" are trusted.")
+	}
+
+	certs, err := readCertificates(bundle)
+	if err != nil {
+		return err
+	}
+	for _, cert := range certs {
+		content = append(content, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(file, content, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "%d CAs of %s added to %s\n", len(certs), bundle, file)
+	return nil
+}
+
+// caBundleNamespaces returns the namespaces of the components making outbound TLS calls:
+// cert-manager to the ACME server, oauth2-proxy to the OIDC provider, Alertmanager, Grafana and
+// the DingTalk webhook to their receivers, fluent-bit-to-alertmanager to Alertmanager.
+func (config *Config) caBundleNamespaces() []string {
+	basicInfo := &config.BasicInfo
+	var namespaces []string
+	if basicInfo.HttpsEnabled && basicInfo.TlsCert.CertMethod == CertMethods.CertManager {
+		namespaces = append(namespaces, "cert-manager")
+	}
+	if basicInfo.AccessControl.Method == AccessControlMethods.OAuth2Proxy && len(config.IngressNamespaces()) > 0 {
+		namespaces = append(namespaces, OAuth2ProxyNamespace)
+	}
+	if config.InstallPrometheus {
+		namespaces = append(namespaces, "monitoring")
+	}
+	if config.InstallLogging && config.Logging.ErrorLogAlert {
+		namespaces = append(namespaces, "logging")
+	}
+	return namespaces
+}
+
+// caBundleTasks returns the tasks creating CaBundleConfigMap in the namespaces of the components.
+func (config *Config) caBundleTasks() []Task {
+	bundle := config.BasicInfo.CaBundleFile
+	return []Task{
+		{Name: "Generate CA Bundle",
+			Func: func(ctx context.Context, dir string, output io.Writer) error {
+				return WriteCaBundle(filepath.Join(dir, CaBundleFile), bundle, output)
+			}},
+		{Name: "Create CA Bundle ConfigMaps",
+			Command: "chmod +x packages/trust/install.sh; packages/trust/install.sh"},
+	}
+}
+
+// caBundleEnvs returns the environment variables of packages/trust and the volumes of the
+// values files mounting the bundle over the CA file of the components. Helm and the other
+// commands of the tasks trust the bundle with SSL_CERT_FILE.
+func (config *Config) caBundleEnvs() []string {
+	if config.BasicInfo.CaBundleFile == "" {
+		return []string{
+			"IDO_CA_BUNDLE_VOLUMES=[]",
+			"IDO_CA_BUNDLE_VOLUME_MOUNTS=[]",
+			"IDO_CA_BUNDLE_CONFIGMAP_MOUNTS=[]",
+		}
+	}
+	key := filepath.Base(CaBundleFile)
+	return []string{
+		"SSL_CERT_FILE=" + CaBundleFile,
+		"IDO_CA_BUNDLE_FILE=" + CaBundleFile,
+		"IDO_CA_BUNDLE_CONFIGMAP=" + CaBundleConfigMap,
+		"IDO_CA_BUNDLE_NAMESPACES=" + strings.Join(config.caBundleNamespaces(), " "),
+		"IDO_CA_BUNDLE_VOLUMES=[{name: " + CaBundleConfigMap + ", configMap: {name: " + CaBundleConfigMap + "}}]",
+		"IDO_CA_BUNDLE_VOLUME_MOUNTS=[{name: " + CaBundleConfigMap + ", mountPath: " + caBundleMountPath +
+			", subPath: " + key + ", readOnly: true}]",
+		// The Grafana chart mounts ConfigMaps in its own format
+		"IDO_CA_BUNDLE_CONFIGMAP_MOUNTS=[{name: " + CaBundleConfigMap + ", configMap: " + CaBundleConfigMap +
+			", mountPath: " + caBundleMountPath + ", subPath: " + key + ", readOnly: true}]",
+	}
+}
+
+// sendMail is smtp.SendMail verifying the server with trustedRoots, which gives up after
+// smtpTimeout or once ctx is done.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, message []byte) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: smtpTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(smtpTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host, RootCAs: trustedRoots})
+		if err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("SMTP server " + addr + " doesn't support authentication.")
+		}
+		err = client.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(from)
+	if err != nil {
+		return err
+	}
+	for _, recipient := range to {
+		err = client.Rcpt(recipient)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
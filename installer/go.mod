@@ -7,6 +7,7 @@ require (
 	github.com/rivo/tview v0.0.0-20231007183732-6c844bdc5f7a
 	github.com/thlib/go-timezone-local v0.0.0-20210907160436-ef149e42d28e
 	golang.org/x/exp v0.0.0-20231006140011-7918f672742d
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
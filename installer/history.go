@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"github.com/rivo/tview"
+	"om-kits-installer/engine"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func historyDir() string {
+	return filepath.Join(appPath, "history")
+}
+
+func initFlexHistory() {
+	flexHistory.Clear()
+	flexTop := tview.NewFlex()
+	flexTop.SetTitle("History").SetBorder(true)
+
+	records, err := engine.LoadRunRecords(historyDir())
+	if err != nil {
+		showErrorModal("Can't load the history: " + err.Error())
+	}
+
+	detail := tview.NewTextView()
+	detail.SetWrap(true).
+		SetWordWrap(true)
+
+	listRuns := tview.NewList()
+	for index, record := range records {
+		result := "succeeded"
+		if !record.Success {
+			result = "failed"
+		}
+		listRuns.AddItem(record.StartTime.Local().Format("2006-01-02 15:04:05")+"  "+result,
+			record.Config.BasicInfo.Host+", "+record.Duration().Round(time.Second).String(), rune(97+index%26), nil)
+	}
+	listRuns.SetChangedFunc(func(index int, mainText string, secondaryText string, shortcut rune) {
+		detail.SetText(runRecordText(&records[index])).ScrollToBeginning()
+	})
+	if len(records) > 0 {
+		detail.SetText(runRecordText(&records[0]))
+	} else {
+		detail.SetText("No install has been run yet.")
+	}
+
+	flexTop.
+		AddItem(listRuns, 0, 1, true).
+		AddItem(detail, 0, 2, false)
+
+	formDown := tview.NewForm()
+
+	formDown.AddButton("Open Log", func() {
+		if len(records) == 0 {
+			return
+		}
+		record := &records[listRuns.GetCurrentItem()]
+		content, err := os.ReadFile(record.LogPath)
+		if err != nil {
+			showErrorModal("Can't read " + record.LogPath + ": " + err.Error())
+			return
+		}
+		detail.SetText(string(content)).ScrollToEnd()
+	})
+
+	formDown.AddButton("Diff Config", func() {
+		if len(records) == 0 {
+			return
+		}
+		record := &records[listRuns.GetCurrentItem()]
+		current := config.Redacted()
+		diff, err := engine.DiffConfigs(&record.Config, &current)
+		if err != nil {
+			showErrorModal(err.Error())
+			return
+		}
+		if len(diff) == 0 {
+			detail.SetText("The config of this run is the same as the current one.")
+		} else {
+			detail.SetText("Run config -> current config\n\n" + strings.Join(diff, "\n")).ScrollToBeginning()
+		}
+	})
+
+	formDown.AddButton("Back", func() {
+		pages.SwitchToPage("Basic Info")
+	})
+
+	formDown.AddButton("Quit", func() {
+		showQuitModal()
+	})
+
+	flexHistory.SetDirection(tview.FlexRow).
+		AddItem(flexTop, 0, 1, true).
+		AddItem(formDown, 3, 1, false)
+
+}
+
+func runRecordText(record *engine.RunRecord) string {
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "Started:  %s\n", record.StartTime.Local().Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(&text, "Duration: %s\n", record.Duration().Round(time.Second))
+	if record.Success {
+		fmt.Fprintf(&text, "Result:   succeeded\n")
+	} else {
+		fmt.Fprintf(&text, "Result:   failed (%s)\n", record.Error)
+	}
+	fmt.Fprintf(&text, "Host:     %s\n", record.Config.BasicInfo.Host)
+	fmt.Fprintf(&text, "Log:      %s\n\n", record.LogPath)
+
+	for _, task := range record.Tasks {
+		result := "done"
+		if !task.Success {
+			result = fmt.Sprintf("failed, exit code %d", task.ExitCode)
+		}
+		fmt.Fprintf(&text, "%-32s %8s  %s\n", task.Name,
+			(time.Duration(task.DurationMs) * time.Millisecond).Round(time.Second), result)
+	}
+	return text.String()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"om-kits-installer/engine"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// images implements the images command, which builds the offline bundle: "images save" saves the
+// images of the packages into the images directory, "images push" pushes them to the registry of
+// the offline site and adds a mirror preset pulling from it.
+func images(args []string) int {
+	usage := "Usage: om-kits-installer images save [-packages <names>] [-platform <os/arch>]\n" +
+		"       om-kits-installer images push -registry <host[/path]> [-username <name>] [-password <password>]\n" +
+		"                                      [-ca-bundle <file>]"
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+	dir := filepath.Join(appPath, engine.ImagesDir)
+
+	switch args[0] {
+	case "save":
+		return saveImages(dir, args[1:])
+	case "push":
+		return pushImages(dir, args[1:])
+	}
+	fmt.Fprintln(os.Stderr, usage)
+	return 2
+}
+
+func saveImages(dir string, args []string) int {
+	flags := flag.NewFlagSet("images save", flag.ExitOnError)
+	packageNames := flags.String("packages", strings.Join(engine.Packages, ","),
+		"the packages whose images are saved, separated by commas")
+	platform := flags.String("platform", engine.DefaultPlatform(), "the platform of the images")
+	_ = flags.Parse(args)
+
+	var packages []string
+	for _, name := range strings.Split(*packageNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			packages = append(packages, name)
+		}
+	}
+	list, err := engine.PackageImages(packages)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	checksums := map[string]string{}
+	for _, image := range list {
+		fmt.Println("Saving " + image + "...")
+		name, checksum, err := engine.SaveImage(context.Background(), image, *platform, dir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		checksums[name] = checksum
+	}
+	err = engine.WriteImageChecksums(dir, checksums)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Saved %d images to %s.\n", len(list), dir)
+	return 0
+}
+
+func pushImages(dir string, args []string) int {
+	flags := flag.NewFlagSet("images push", flag.ExitOnError)
+	registry := flags.String("registry", "", "the registry the images are pushed to, like harbor.example.com/offline")
+	username := flags.String("username", "", "the user of the registry, the login of docker login by default")
+	password := flags.String("password", os.Getenv("IDO_REGISTRY_PASSWORD"),
+		"the password or token of the registry, $IDO_REGISTRY_PASSWORD by default")
+	caBundle := flags.String("ca-bundle", "", "a PEM file with the CAs of the registry, besides the ones of the system")
+	_ = flags.Parse(args)
+
+	err := engine.TrustCaBundle(*caBundle)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't read the CA bundle: "+err.Error())
+		return 1
+	}
+
+	target := strings.TrimSuffix(strings.TrimSpace(*registry), "/")
+	if target == "" {
+		fmt.Fprintln(os.Stderr, "Please give the registry to push to with -registry.")
+		return 2
+	}
+	checksums, err := engine.ReadImageChecksums(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't read the images, save them first: "+err.Error())
+		return 1
+	}
+	var names []string
+	for name := range checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := map[string]bool{}
+	for _, name := range names {
+		fmt.Println("Pushing " + name + "...")
+		pushed, err := engine.PushImage(context.Background(), filepath.Join(dir, name), checksums[name], target,
+			*username, *password)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("Pushed " + pushed)
+		sources[engine.ImageRegistry(strings.TrimPrefix(pushed, target+"/"))] = true
+	}
+
+	var sourceList []string
+	for source := range sources {
+		sourceList = append(sourceList, source)
+	}
+	presetsFile := filepath.Join(appPath, engine.MirrorPresetsFile)
+	err = engine.SaveOfflinePreset(presetsFile, target, sourceList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("Pushed %d images to %s. Pick the preset \"%s\" on the Mirror page to install from it.\n",
+		len(names), target, engine.OfflinePresetName(target))
+	return 0
+}
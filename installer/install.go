@@ -1,53 +1,100 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
-	"io"
-	"net"
+	"om-kits-installer/engine"
 	"os"
-	"os/exec"
-	"strconv"
-	"strings"
-	"syscall"
+	"path/filepath"
 	"time"
 )
 
-type task struct {
-	name    string
-	command string
+// installSession is one run of the install page. It owns the widgets bound to the run and
+// the goroutines of the executor and the timer, which all return once its context is cancelled.
+type installSession struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	plan       *engine.Plan
+	executor   *engine.Executor
+	startTime  time.Time
+	logPath    string
+	logFile    *os.File
+	failedTask string
+	record     engine.RunRecord
+	// Closed once the executor has returned, after OnRunFinish
+	finished chan struct{}
+
+	// Snapshots of the config, the UI may change it while the session runs
+	host         string
+	notification engine.NotificationConfig
+
+	flexTop     *tview.Flex
+	listTask    *tview.List
+	logContent  *tview.TextView
+	abortButton *tview.Button
+	backButton  *tview.Button
+	quitButton  *tview.Button
 }
 
-var flexTop = tview.NewFlex()
-var listTask *tview.List
-var process *os.Process
-var processState *os.ProcessState
-var abortButton *tview.Button
-var backButton *tview.Button
-var quitButton *tview.Button
-var logContent *tview.TextView
-var stopTimer = make(chan bool)
+// currentSession is only accessed from the UI goroutine.
+var currentSession *installSession
 
 func initFlexInstall() {
-	flexInstall.Clear()
-	flexTop.Clear()
-	flexTop.SetTitle("Install").SetBorder(true)
+	stopInstallSession(func() {})
+
+	session := newInstallSession(engine.NewPlan(config))
+	currentSession = session
+	session.start()
+}
 
-	tasks, envs := buildTasks()
+// stopInstallSession cancels the current session, which terminates its running task, and calls
+// stopped on the UI goroutine once its executor has returned. The UI must keep running until
+// then, the executor updates it.
+func stopInstallSession(stopped func()) {
+	session := currentSession
+	if session == nil {
+		stopped()
+		return
+	}
+	session.cancel()
+	currentSession = nil
+	go func() {
+		<-session.finished
+		app.QueueUpdate(stopped)
+	}()
+}
+
+func newInstallSession(plan *engine.Plan) *installSession {
+	session := &installSession{plan: plan, host: config.BasicInfo.Host, notification: config.Notification,
+		finished: make(chan struct{})}
+	session.record.Config = config.Redacted()
+	session.ctx, session.cancel = context.WithCancel(context.Background())
+
+	logDir := filepath.Join(appPath, "logs")
+	check(os.MkdirAll(logDir, 0755))
+	session.logPath = engine.NewLogFilePath(logDir, "install-")
+	var err error
+	session.logFile, err = os.Create(session.logPath)
+	check(err)
+	session.record.LogPath = session.logPath
+
+	flexInstall.Clear()
+	session.flexTop = tview.NewFlex()
+	session.flexTop.SetTitle("Install").SetBorder(true)
 
-	listTask = tview.NewList()
-	for index, task := range tasks {
-		listTask.AddItem(task.name, "pending", rune(97+index), nil)
+	session.listTask = tview.NewList()
+	for index, task := range plan.Tasks {
+		session.listTask.AddItem(task.Name, "pending", rune(97+index), nil)
 	}
 	// Disable mouse
-	listTask.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+	session.listTask.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
 		return action, nil
 	})
 
-	process = nil
-	processState = nil
-
-	logContent = tview.NewTextView()
+	logContent := tview.NewTextView()
 	logContent.SetBackgroundColor(tcell.ColorDarkBlue)
 	logContent.SetMaxLines(0).
 		SetWrap(true).
@@ -56,14 +103,15 @@ func initFlexInstall() {
 			logContent.ScrollToEnd()
 			app.Draw()
 		})
+	session.logContent = logContent
 
-	flexTop.
-		AddItem(listTask, 0, 1, false).
-		AddItem(logContent, 0, 3, false)
+	session.flexTop.
+		AddItem(session.listTask, 0, 1, false).
+		AddItem(session.logContent, 0, 3, false)
 
 	formDown := tview.NewForm()
 	formDown.AddButton("Abort", func() {
-		if process != nil && processState == nil {
+		if !session.isFinished() {
 			confirmAbort := tview.NewModal().
 				SetText("Do you want to abort the execution?").
 				AddButtons([]string{"Abort", "Cancel"}).
@@ -72,13 +120,11 @@ func initFlexInstall() {
 						pages.SwitchToPage("Install")
 					}
 					if buttonLabel == "Abort" {
-						pgid, err := syscall.Getpgid(process.Pid)
-						check(err)
-						syscall.Kill(-pgid, 15)
+						session.cancel()
 
-						abortButton.SetDisabled(true)
-						backButton.SetDisabled(false)
-						quitButton.SetDisabled(false)
+						session.abortButton.SetDisabled(true)
+						session.backButton.SetDisabled(false)
+						session.quitButton.SetDisabled(false)
 
 						pages.SwitchToPage("Install")
 					}
@@ -86,237 +132,153 @@ func initFlexInstall() {
 			pages.AddPage("Confirm Abort", confirmAbort, true, true)
 		}
 	})
-	abortButton = formDown.GetButton(formDown.GetButtonIndex("Abort"))
-	abortButton.SetDisabled(true)
+	session.abortButton = formDown.GetButton(formDown.GetButtonIndex("Abort"))
+	session.abortButton.SetDisabled(true)
 
 	formDown.AddButton("Back", func() {
-		pages.SwitchToPage("Mirror")
+		pages.SwitchToPage("Notification")
 	})
-	backButton = formDown.GetButton(formDown.GetButtonIndex("Back"))
-	backButton.SetDisabled(false)
+	session.backButton = formDown.GetButton(formDown.GetButtonIndex("Back"))
+	session.backButton.SetDisabled(false)
 
 	formDown.AddButton("Quit", func() {
 		showQuitModal()
 	})
-	quitButton = formDown.GetButton(formDown.GetButtonIndex("Quit"))
-	quitButton.SetDisabled(false)
+	session.quitButton = formDown.GetButton(formDown.GetButtonIndex("Quit"))
+	session.quitButton.SetDisabled(false)
 
 	flexInstall.SetDirection(tview.FlexRow).
-		AddItem(flexTop, 0, 1, true).
+		AddItem(session.flexTop, 0, 1, true).
 		AddItem(formDown, 3, 1, false)
 
-	go startTimer(stopTimer)
-	go execTasks(tasks, envs, logContent)
+	session.executor = session.newExecutor()
+	return session
 }
 
-func buildTasks() (tasks []task, envs []string) {
-	envs = append(envs, "IDO_TIMEZONE="+basicInfo.timezone)
-	envs = append(envs, "IDO_CLUSTER_HOSTNAME="+basicInfo.host)
-
-	if net.ParseIP(basicInfo.host) == nil {
-		envs = append(envs, "IDO_INGRESS_HOSTNAME="+basicInfo.host)
-	} else {
-		envs = append(envs, "IDO_INGRESS_HOSTNAME=")
-	}
-
-	var clusterUrl string
-	if basicInfo.httpsEnabled {
-		clusterUrl = "https://" + basicInfo.host
-		envs = append(envs, "IDO_CLUSTER_URL="+clusterUrl)
-		envs = append(envs, "IDO_TLS_KEY=tls")
-		envs = append(envs, "IDO_TLS_HOST="+basicInfo.host)
-
-		switch basicInfo.tlsCert.certMethod {
-		case certMethod.defaultTlsSecret:
-			envs = append(envs, "IDO_TLS_ACME=false")
-			envs = append(envs, "IDO_TLS_SECRET=")
-		case certMethod.certManager:
-			envs = append(envs, "IDO_TLS_ACME=true")
-			envs = append(envs, "IDO_TLS_SECRET="+basicInfo.host)
-		}
-	} else {
-		clusterUrl = "http://" + basicInfo.host
-		envs = append(envs, "IDO_CLUSTER_URL="+clusterUrl)
-		envs = append(envs, "IDO_TLS_KEY=tls-disabled")
-		envs = append(envs, "IDO_TLS_HOST=")
-		envs = append(envs, "IDO_TLS_ACME=false")
-		envs = append(envs, "IDO_TLS_SECRET=")
-	}
-	envs = append(envs, "IDO_FORCE_SSL_REDIRECT="+strconv.FormatBool(basicInfo.tlsCert.forceSslRedirect))
-
-	var finalMirrors map[string]string
-	if enableMirror {
-		finalMirrors = map[string]string{
-			"IDO_DOCKER_CONTAINER_MIRROR": mirrors["DOCKER_CONTAINER_MIRROR"],
-			"IDO_QUAY_CONTAINER_MIRROR":   mirrors["QUAY_CONTAINER_MIRROR"],
-			"IDO_K8S_CONTAINER_MIRROR":    mirrors["K8S_CONTAINER_MIRROR"],
-			"IDO_GCR_CONTAINER_MIRROR":    mirrors["GCR_CONTAINER_MIRROR"],
-		}
-	} else {
-		finalMirrors = map[string]string{
-			"IDO_DOCKER_CONTAINER_MIRROR": "docker.io",
-			"IDO_QUAY_CONTAINER_MIRROR":   "quay.io",
-			"IDO_K8S_CONTAINER_MIRROR":    "registry.k8s.io",
-			"IDO_GCR_CONTAINER_MIRROR":    "k8s-gcr.io",
-		}
-	}
-	for k, v := range finalMirrors {
-		envs = append(envs, k+"="+v)
-	}
+func (session *installSession) start() {
+	session.startTime = time.Now()
+	session.record.StartTime = session.startTime
 
-	if basicInfo.httpsEnabled && basicInfo.tlsCert.certMethod == certMethod.certManager {
-		tasks = append(tasks, task{name: "Install Cert-manager",
-			command: "chmod +x packages/cert-manager/install.sh; packages/cert-manager/install.sh"})
-		envs = append(envs, "IDO_ACME_EMAIL="+basicInfo.tlsCert.acmeEmail)
-	}
+	go func() {
+		defer close(session.finished)
+		_ = session.executor.Run(session.ctx, session.plan)
+	}()
+	go session.runTimer()
+}
 
-	if installLocalPathProvisioner {
-		tasks = append(tasks, task{name: "Install Local-Path Provisioner",
-			command: "chmod +x packages/storage/local-path/install.sh; packages/storage/local-path/install.sh"})
+// isFinished reports whether the executor has returned, every task is then over.
+func (session *installSession) isFinished() bool {
+	select {
+	case <-session.finished:
+		return true
+	default:
+		return false
 	}
+}
 
-	if installNfsProvisioner {
-		options := strings.Split(nfsProvisionerConfig.mountOptions, ",")
-		optionsString := ""
-		if len(options) > 0 {
-			for oneOption := range options {
-				optionsString = optionsString + "    - " + options[oneOption] + "\n"
-			}
+// update runs f on the UI goroutine, unless the session has been replaced meanwhile.
+func (session *installSession) update(f func()) {
+	app.QueueUpdateDraw(func() {
+		if currentSession == session {
+			f()
 		}
-		tasks = append(tasks, task{name: "Install NFS Provisioner",
-			command: "chmod +x packages/storage/nfs/install.sh; packages/storage/nfs/install.sh"})
-		envs = append(envs, "IDO_NFS_SERVER="+nfsProvisionerConfig.server)
-		envs = append(envs, "IDO_NFS_PATH="+nfsProvisionerConfig.path)
-		envs = append(envs, "IDO_NFS_MOUNTOPTIONS="+optionsString)
-	}
-
-	if installPrometheus {
-		tasks = append(tasks, task{name: "Install Prometheus",
-			command: "chmod +x packages/prometheus/install.sh; packages/prometheus/install.sh"})
-		envs = append(envs, "IDO_ALTERMANAGER_STORAGE_SIZE="+strconv.Itoa(prometheusConfig.alertmanagerStorageSizeGi)+"Gi")
-		envs = append(envs, "IDO_GRAFANA_STORAGE_SIZE="+strconv.Itoa(prometheusConfig.grafanaStorageSizeGi)+"Gi")
-		envs = append(envs, "IDO_PROMETHEUS_STORAGE_SIZE="+strconv.Itoa(prometheusConfig.prometheusStorageSizeGi)+"Gi")
-		envs = append(envs, "IDO_PROMETHEUS_STORAGE_CLASS="+prometheusConfig.storageClass)
-	}
-
-	if installLogging {
-		tasks = append(tasks, task{name: "Install Logging",
-			command: "chmod +x packages/logging/install.sh; packages/logging/install.sh"})
+	})
+}
 
-		var logPath string
-		if loggingConfig.collectNamespaces != "" {
-			var logPathSlice []string
-			logNamespaces := strings.Split(loggingConfig.collectNamespaces, ",")
-			for namespace := range logNamespaces {
-				logPathSlice = append(logPathSlice, "/var/log/containers/*_"+logNamespaces[namespace]+"_*.log")
+// newExecutor returns an executor which reflects the progress on the widgets of the session
+// and in the event stream.
+func (session *installSession) newExecutor() *engine.Executor {
+	return &engine.Executor{
+		Dir: appPath,
+		OnTaskStart: func(index int, task engine.Task) {
+			events.Emit(engine.TaskStartedEvent(index, task))
+			session.update(func() {
+				session.listTask.SetCurrentItem(index)
+				session.listTask.SetItemText(index, task.Name, "in-progress...")
+
+				session.abortButton.SetDisabled(false)
+				session.backButton.SetDisabled(true)
+				session.quitButton.SetDisabled(true)
+			})
+		},
+		OnOutput: func(index int, line string) {
+			events.Emit(engine.OutputLineEvent(index, session.plan.Tasks[index], line))
+			fmt.Fprintln(session.logFile, line)
+			fmt.Fprintln(session.logContent, line)
+		},
+		OnTaskFinish: func(index int, result engine.TaskResult) {
+			events.Emit(engine.TaskFinishedEvent(index, result))
+			session.record.Tasks = append(session.record.Tasks, engine.NewTaskRecord(result))
+			if result.Err != nil {
+				session.failedTask = result.Task.Name
+				fmt.Fprint(session.logFile, "\n"+result.Stderr)
+				fmt.Fprint(session.logContent, "\n"+result.Stderr)
 			}
-			logPath = strings.Join(logPathSlice, ",")
-		} else {
-			logPath = "/var/log/containers/*.log"
-		}
-
-		nodeAffinityPreset := ""
-		if loggingConfig.nodeAffinity {
-			nodeAffinityPreset = "hard"
-		}
+			session.update(func() {
+				if result.Err != nil {
+					session.listTask.SetItemText(index, result.Task.Name, "failed!")
+				} else {
+					session.listTask.SetItemText(index, result.Task.Name, "done")
+				}
+			})
+		},
+		OnRunFinish: func(err error) {
+			duration := time.Since(session.startTime)
+			events.Emit(engine.RunFinishedEvent(err, duration))
+
+			var urls []string
+			if err == nil {
+				urls = engine.GetServiceUrls(&session.record.Config)
+				for _, url := range urls {
+					fmt.Fprintln(session.logFile, url)
+					fmt.Fprintln(session.logContent, url)
+				}
+			}
+			session.logFile.Close()
 
-		alertLogLevel := "none"
-		if loggingConfig.errorLogAlert {
-			alertLogLevel = "ERROR"
-		}
+			session.record.Finish(err, duration)
+			if saveErr := engine.SaveRunRecord(historyDir(), &session.record); saveErr != nil {
+				fmt.Fprintln(session.logContent, "Failed to save the run record: "+saveErr.Error())
+			}
+			logBgColor := tcell.ColorDarkGreen
+			if err != nil {
+				logBgColor = tcell.ColorDarkRed
+			}
+			session.update(func() {
+				session.logContent.SetBackgroundColor(logBgColor)
+				session.abortButton.SetDisabled(true)
+				session.backButton.SetDisabled(false)
+				session.quitButton.SetDisabled(false)
+			})
 
-		envs = append(envs, "IDO_FLUENT_LOG_PATH="+logPath)
-		envs = append(envs, "IDO_ES_STORAGE_SIZE="+strconv.Itoa(loggingConfig.esStorageSizeGi)+"Gi")
-		envs = append(envs, "IDO_ES_STORAGE_CLASS="+loggingConfig.storageClass)
-		envs = append(envs, "IDO_ES_NODE_AFFINITY="+nodeAffinityPreset)
-		envs = append(envs, "IDO_ES_INDEX_AGE="+strconv.Itoa(loggingConfig.esIndexAgeDay)+"d")
-		envs = append(envs, "IDO_FLUENT_ALERT_LOG_LEVEL="+alertLogLevel)
+			summary := engine.NewRunSummary(session.host, err, duration, session.failedTask, session.logPath)
+			summary.Urls = urls
+			// Quit waits for the notifications, which an unreachable target mustn't hold, even
+			// those of an aborted run
+			notifyCtx, cancelNotify := context.WithTimeout(context.Background(), engine.NotifyTimeout)
+			defer cancelNotify()
+			for _, notifyErr := range engine.Notify(notifyCtx, &session.notification, summary) {
+				fmt.Fprintln(session.logContent, "Notification failed: "+notifyErr.Error())
+			}
+		},
 	}
-
-	tasks = append(tasks, task{name: "Final Check",
-		command: "chmod +x packages/final-check.sh; packages/final-check.sh"})
-
-	return
 }
 
-func execTasks(tasks []task, envs []string, view *tview.TextView) {
-	var logBgColor tcell.Color
-
-	for index, task := range tasks {
-		listTask.SetCurrentItem(index)
-		mainText, _ := listTask.GetItemText(index)
-		listTask.SetItemText(index, mainText, "in-progress...")
-
-		processState = nil
-
-		//cmd := exec.Command("/bin/bash", "-c", "echo '"+command+"'")
-		//cmd := exec.Command("/bin/bash", "-c", "export")
-		cmd := exec.Command("/bin/bash", "-c", task.command)
-
-		cmd.Dir = appPath
-
-		cmd.Env = os.Environ()
-		for _, env := range envs {
-			cmd.Env = append(cmd.Env, env)
-		}
-
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-
-		stdout, err := cmd.StdoutPipe()
-		check(err)
-		stderr, err := cmd.StderrPipe()
-		check(err)
+func (session *installSession) runTimer() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
-		err = cmd.Start()
-		check(err)
-		process = cmd.Process
-
-		abortButton.SetDisabled(false)
-		backButton.SetDisabled(true)
-		quitButton.SetDisabled(true)
-
-		_, err = io.Copy(view, stdout)
-		check(err)
-
-		errBytes, err := io.ReadAll(stderr)
-		check(err)
-
-		err = cmd.Wait()
-		processState = cmd.ProcessState
-		process = nil
-
-		if err != nil {
-			view.SetText(view.GetText(false) + "\n" + string(errBytes))
-			listTask.SetItemText(index, mainText, "failed!")
-			logBgColor = tcell.ColorDarkRed
-			break
-		} else {
-			listTask.SetItemText(index, mainText, "done")
-			logBgColor = tcell.ColorDarkGreen
-		}
-	}
-
-	stopTimer <- true
-
-	app.QueueUpdateDraw(func() {
-		logContent.SetBackgroundColor(logBgColor)
-		abortButton.SetDisabled(true)
-		backButton.SetDisabled(false)
-		quitButton.SetDisabled(false)
-	})
-}
-
-func startTimer(stop chan bool) {
-	startTime := time.Now()
 	for {
+		session.update(func() {
+			session.flexTop.SetTitle("Install - Time Elapsed: " + time.Since(session.startTime).Round(time.Second).String())
+		})
+
 		select {
-		case <-stop:
+		case <-session.ctx.Done():
 			return
-		default:
-			app.QueueUpdateDraw(func() {
-				flexTop.SetTitle("Install - Time Elapsed: " + time.Since(startTime).Round(time.Second).String())
-			})
-			time.Sleep(time.Second)
+		case <-session.finished:
+			return
+		case <-ticker.C:
 		}
 	}
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"flag"
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+	"om-kits-installer/engine"
 	"os"
 	"path/filepath"
 )
 
 var appPath string
+var config = engine.NewConfig()
+var events *engine.EventStream
 var app = tview.NewApplication()
 var pages = tview.NewPages()
 var modalQuit = tview.NewModal()
@@ -15,24 +19,44 @@ var flexBasicInfo = tview.NewFlex()
 var flexStorage = tview.NewFlex()
 var flexPackages = tview.NewFlex()
 var flexMirror = tview.NewFlex()
+var flexNotification = tview.NewFlex()
 var flexInstall = tview.NewFlex()
+var flexHistory = tview.NewFlex()
 
 func main() {
+	eventsTarget := flag.String("events", "",
+		"write progress events as JSON lines to a file, or to a Unix socket with \"unix:<path>\"")
+	flag.Parse()
+
 	ex, err := os.Executable()
 	check(err)
 	appPath = filepath.Dir(ex)
 
-	_, err = execCommand("which kubectl", 0)
-	if err != nil {
-		panic("kubectl is not found!")
+	if flag.Arg(0) == "renew-cert" {
+		os.Exit(renewCert(flag.Args()[1:]))
 	}
-	_, err = execCommand("which helm", 0)
-	if err != nil {
-		panic("helm is not found!")
+	if flag.Arg(0) == "images" {
+		os.Exit(images(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "test-alert" {
+		os.Exit(testAlert(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "rewrite-images" {
+		os.Exit(rewriteImages())
 	}
-	_, err = execCommand("kubectl cluster-info", 0)
+
+	if *eventsTarget != "" {
+		events, err = engine.OpenEventStream(*eventsTarget)
+		if err != nil {
+			panic("Can't open event stream: " + err.Error())
+		}
+		defer events.Close()
+	}
+
+	err = engine.Preflight()
+	events.Emit(engine.PreflightResultEvent(err))
 	if err != nil {
-		panic("Can't connect to k8s cluster!")
+		panic(err.Error())
 	}
 
 	initFlexBasicInfo()
@@ -42,7 +66,9 @@ func main() {
 	pages.AddPage("Basic Info", flexBasicInfo, true, true)
 	pages.AddPage("Packages", flexPackages, true, false)
 	pages.AddPage("Mirror", flexMirror, true, false)
+	pages.AddPage("Notification", flexNotification, true, false)
 	pages.AddPage("Install", flexInstall, true, false)
+	pages.AddPage("History", flexHistory, true, false)
 
 	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 		if event.Key() == tcell.KeyCtrlC {
@@ -1,61 +1,187 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"github.com/rivo/tview"
-	"golang.org/x/exp/slices"
+	"om-kits-installer/engine"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
-var enableMirror = false
-var mirrors map[string]string
+var mirrorPresetsFile string
+var mirrorPresets []engine.MirrorPreset
+var newMirrorSource string
+var mirrorLoginIndex int
+var pickedMirrorPreset engine.MirrorPreset
+var textMirrorTest *tview.TextView
 
 func initFlexMirror() {
+	if mirrorPresetsFile == "" {
+		mirrorPresetsFile = filepath.Join(appPath, engine.MirrorPresetsFile)
+		// The presets file is optional
+		mirrorPresets, _ = engine.LoadMirrorPresets(mirrorPresetsFile)
+	}
+
 	flexMirror.Clear()
 	formMirror := tview.NewForm()
 	formMirror.SetTitle("Public Download Mirror").SetBorder(true)
 
-	formMirror.AddCheckbox("Enable public download mirror: ", enableMirror, func(checked bool) {
-		enableMirror = checked
+	formMirror.AddCheckbox("Enable public download mirror: ", config.EnableMirror, func(checked bool) {
+		config.EnableMirror = checked
 		flexMirror.Clear()
 		initFlexMirror()
 	})
 
-	if enableMirror {
-		if mirrors == nil {
-			mirrors = map[string]string{
-				"DOCKER_CONTAINER_MIRROR": "docker.m.daocloud.io",
-				"QUAY_CONTAINER_MIRROR":   "quay.m.daocloud.io",
-				"K8S_CONTAINER_MIRROR":    "k8s.m.daocloud.io",
-				"GCR_CONTAINER_MIRROR":    "k8s-gcr.m.daocloud.io",
-			}
+	if config.EnableMirror {
+		if config.RegistryMirrors == nil {
+			config.RegistryMirrors = engine.DefaultMirrors()
 		}
 
-		var keyOrdered []string
-		for k, _ := range mirrors {
-			keyOrdered = append(keyOrdered, k)
+		presets := append(append([]engine.MirrorPreset{}, engine.BuiltinMirrorPresets...), mirrorPresets...)
+		var presetNames []string
+		for _, preset := range presets {
+			presetNames = append(presetNames, preset.Name)
 		}
-		slices.Sort(keyOrdered)
+		formMirror.AddDropDown("Load preset: ", presetNames, -1, func(option string, optionIndex int) {
+			if optionIndex < 0 {
+				return
+			}
+			config.RegistryMirrors = append([]engine.RegistryMirror{}, presets[optionIndex].Mirrors...)
+			pickedMirrorPreset = presets[optionIndex]
+			initFlexMirror()
+		})
+
+		formMirror.AddInputField("Presets file: ", mirrorPresetsFile, 0, nil, func(text string) {
+			mirrorPresetsFile = strings.TrimSpace(text)
+		})
 
-		for _, item := range keyOrdered {
-			key := item
-			formMirror.AddInputField(item+": ", mirrors[key], 0, nil, func(text string) {
-				mirrors[key] = text
+		for index := range config.RegistryMirrors {
+			mirror := &config.RegistryMirrors[index]
+			formMirror.AddInputField(mirror.Source+": ", mirror.Mirror, 0, nil, func(text string) {
+				mirror.Mirror = strings.TrimSpace(text)
+			})
+		}
+
+		// The login of one mirror at a time keeps the form short
+		if len(config.RegistryMirrors) > 0 {
+			if mirrorLoginIndex >= len(config.RegistryMirrors) {
+				mirrorLoginIndex = 0
+			}
+			var sources []string
+			for _, mirror := range config.RegistryMirrors {
+				sources = append(sources, mirror.Source)
+			}
+			formMirror.AddDropDown("Login of the mirror of: ", sources, mirrorLoginIndex,
+				func(option string, optionIndex int) {
+					if optionIndex >= 0 && optionIndex != mirrorLoginIndex {
+						mirrorLoginIndex = optionIndex
+						initFlexMirror()
+					}
+				})
+			login := &config.RegistryMirrors[mirrorLoginIndex]
+			formMirror.AddInputField("      Username: ", login.Username, 0, nil, func(text string) {
+				login.Username = strings.TrimSpace(text)
+			})
+			formMirror.AddPasswordField("      Password or token: ", login.Password, 0, '*', func(text string) {
+				login.Password = text
 			})
 		}
+
+		formMirror.AddInputField("Add source registry: ", newMirrorSource, 0, nil, func(text string) {
+			newMirrorSource = strings.TrimSpace(text)
+		})
+
+		formMirror.AddButton("Add Registry", func() {
+			for _, mirror := range config.RegistryMirrors {
+				if mirror.Source == newMirrorSource {
+					showErrorModal("Source registry " + newMirrorSource + " is added already.")
+					return
+				}
+			}
+			if newMirrorSource == "" {
+				showErrorModal("Source registry is empty.")
+				return
+			}
+			config.RegistryMirrors = append(config.RegistryMirrors, engine.RegistryMirror{Source: newMirrorSource})
+			newMirrorSource = ""
+			initFlexMirror()
+		})
+
+		formMirror.AddButton("Load Presets File", func() {
+			loaded, err := engine.LoadMirrorPresets(mirrorPresetsFile)
+			if errors.Is(err, os.ErrNotExist) {
+				showErrorModal(mirrorPresetsFile + " doesn't exist.")
+				return
+			}
+			if err != nil {
+				showErrorModal(err.Error())
+				return
+			}
+			mirrorPresets = loaded
+			initFlexMirror()
+		})
+	}
+
+	textMirrorTest = tview.NewTextView()
+	textMirrorTest.SetTitle("Test Result").SetBorder(true)
+	help := "Clear a mirror to remove its source registry.\n" +
+		"Test mirrors resolves the images of the selected packages through the registries they are pulled from."
+	// The hint of the preset stays until its empty mirrors are entered
+	if presetMirrorMissing() {
+		help = pickedMirrorPreset.Hint + "\n\n" + help
 	}
+	textMirrorTest.SetText(help)
 
 	formDown := tview.NewForm()
 
-	formDown.AddButton("Install", func() {
-		if enableMirror {
-			for k, v := range mirrors {
-				if v == "" {
-					showErrorModal(k + " is empty.")
+	formDown.AddButton("Test mirrors", func() {
+		// Rather than removing the source registry, ask for the mirror the preset needs
+		if presetMirrorMissing() {
+			showErrorModal(pickedMirrorPreset.Hint)
+			return
+		}
+		removeEmptyMirrors()
+		err := config.ValidateMirrors()
+		if err != nil {
+			showErrorModal(err.Error())
+			return
+		}
+		textMirrorTest.SetText("Testing mirrors...")
+		// Copy the config, the form may change it while the registries are called
+		testConfig := *config
+		testConfig.RegistryMirrors = append([]engine.RegistryMirror(nil), config.RegistryMirrors...)
+		go func() {
+			lines, err := engine.TestMirrors(context.Background(), &testConfig)
+			app.QueueUpdateDraw(func() {
+				text := strings.Join(lines, "\n")
+				if err != nil {
+					text = err.Error() + "\n\n" + text
+				} else {
+					text = "All images are available.\n\n" + text
 				}
-			}
+				// The page may have been rebuilt meanwhile
+				textMirrorTest.SetText(text).ScrollToBeginning()
+			})
+		}()
+	})
+
+	formDown.AddButton("Next", func() {
+		// Rather than removing the source registry, ask for the mirror the preset needs
+		if presetMirrorMissing() {
+			showErrorModal(pickedMirrorPreset.Hint)
+			return
+		}
+		removeEmptyMirrors()
+		err := config.ValidateMirrors()
+		if err != nil {
+			showErrorModal(err.Error())
+			return
 		}
 
-		initFlexInstall()
-		pages.SwitchToPage("Install")
+		initFlexNotification()
+		pages.SwitchToPage("Notification")
 	})
 
 	formDown.AddButton("Back", func() {
@@ -68,5 +194,40 @@ func initFlexMirror() {
 
 	flexMirror.SetDirection(tview.FlexRow).
 		AddItem(formMirror, 0, 1, true).
+		AddItem(textMirrorTest, 0, 1, false).
 		AddItem(formDown, 3, 1, false)
 }
+
+// presetMirrorMissing reports whether a mirror the picked preset leaves empty, to be entered as
+// its hint tells, is still empty.
+func presetMirrorMissing() bool {
+	if !config.EnableMirror {
+		return false
+	}
+	for _, presetMirror := range pickedMirrorPreset.Mirrors {
+		if presetMirror.Mirror != "" {
+			continue
+		}
+		for _, mirror := range config.RegistryMirrors {
+			if mirror.Source == presetMirror.Source && mirror.Mirror == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removeEmptyMirrors removes the source registries whose mirror has been cleared, and shows
+// the list without them.
+func removeEmptyMirrors() {
+	mirrors := []engine.RegistryMirror{}
+	for _, mirror := range config.RegistryMirrors {
+		if mirror.Mirror != "" {
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	if len(mirrors) != len(config.RegistryMirrors) {
+		config.RegistryMirrors = mirrors
+		initFlexMirror()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/rivo/tview"
+	"strconv"
+	"strings"
+)
+
+func initFlexNotification() {
+	notification := &config.Notification
+
+	flexNotification.Clear()
+	formNotification := tview.NewForm()
+	formNotification.SetTitle("Notification").SetBorder(true)
+
+	formNotification.AddCheckbox("Notify DingTalk robot: ", notification.DingTalkEnabled, func(checked bool) {
+		notification.DingTalkEnabled = checked
+		initFlexNotification()
+	})
+	if notification.DingTalkEnabled {
+		formNotification.AddInputField("  Robot URL: ", notification.DingTalkRobotUrl, 0, nil, func(text string) {
+			notification.DingTalkRobotUrl = strings.TrimSpace(text)
+		})
+		formNotification.AddPasswordField("  Signing secret (optional): ", notification.DingTalkSecret, 0, '*', func(text string) {
+			notification.DingTalkSecret = strings.TrimSpace(text)
+		})
+	}
+
+	formNotification.AddCheckbox("Notify HTTP webhook: ", notification.WebhookEnabled, func(checked bool) {
+		notification.WebhookEnabled = checked
+		initFlexNotification()
+	})
+	if notification.WebhookEnabled {
+		formNotification.AddInputField("  URL: ", notification.WebhookUrl, 0, nil, func(text string) {
+			notification.WebhookUrl = strings.TrimSpace(text)
+		})
+		formNotification.AddPasswordField("  Signing secret (optional): ", notification.WebhookSecret, 0, '*', func(text string) {
+			notification.WebhookSecret = strings.TrimSpace(text)
+		})
+	}
+
+	formNotification.AddCheckbox("Notify by email: ", notification.EmailEnabled, func(checked bool) {
+		notification.EmailEnabled = checked
+		initFlexNotification()
+	})
+	if notification.EmailEnabled {
+		formNotification.AddInputField("  SMTP host: ", notification.SmtpHost, 0, nil, func(text string) {
+			notification.SmtpHost = strings.TrimSpace(text)
+		})
+		formNotification.AddInputField("  SMTP port: ", strconv.Itoa(notification.SmtpPort), 0, nil, func(text string) {
+			notification.SmtpPort, _ = strconv.Atoi(text)
+		})
+		formNotification.AddInputField("  SMTP username (optional): ", notification.SmtpUsername, 0, nil, func(text string) {
+			notification.SmtpUsername = strings.TrimSpace(text)
+		})
+		formNotification.AddPasswordField("  SMTP password: ", notification.SmtpPassword, 0, '*', func(text string) {
+			notification.SmtpPassword = text
+		})
+		formNotification.AddInputField("  From: ", notification.EmailFrom, 0, nil, func(text string) {
+			notification.EmailFrom = strings.TrimSpace(text)
+		})
+		formNotification.AddInputField("  To (comma separated): ", notification.EmailTo, 0, nil, func(text string) {
+			notification.EmailTo = strings.TrimSpace(text)
+		})
+	}
+
+	formDown := tview.NewForm()
+
+	formDown.AddButton("Install", func() {
+		err := notification.Validate()
+		if err != nil {
+			showErrorModal(err.Error())
+			return
+		}
+
+		initFlexInstall()
+		pages.SwitchToPage("Install")
+	})
+
+	formDown.AddButton("Back", func() {
+		pages.SwitchToPage("Mirror")
+	})
+
+	formDown.AddButton("Quit", func() {
+		showQuitModal()
+	})
+
+	flexNotification.SetDirection(tview.FlexRow).
+		AddItem(formNotification, 0, 1, true).
+		AddItem(formDown, 3, 1, false)
+}
@@ -1,102 +1,21 @@
 package main
 
 import (
-	"errors"
 	"github.com/rivo/tview"
 	"golang.org/x/exp/slices"
+	"om-kits-installer/engine"
 	"strconv"
-	"strings"
 )
 
-type NfsProvisionerConfig struct {
-	server       string
-	path         string
-	mountOptions string
-}
-
-func (config *NfsProvisionerConfig) validate() error {
-	if config.server == "" {
-		return errors.New("NFS server is empty.")
-	}
-	if config.path == "" {
-		return errors.New("NFS path is empty.")
-	}
-	return nil
-}
-
-type PrometheusConfig struct {
-	alertmanagerStorageSizeGi int
-	grafanaStorageSizeGi      int
-	prometheusStorageSizeGi   int
-	storageClass              string
-}
-
-func (config *PrometheusConfig) validate() error {
-	if config.alertmanagerStorageSizeGi == 0 {
-		return errors.New("Alert manager storage size is 0.")
-	}
-	if config.grafanaStorageSizeGi == 0 {
-		return errors.New("Grafana storage size is 0.")
-	}
-	if config.prometheusStorageSizeGi == 0 {
-		return errors.New(" Prometheus storage size is 0.")
-	}
-	return nil
-}
-
-type LoggingConfig struct {
-	collectNamespaces string
-	storageClass      string
-	esStorageSizeGi   int
-	esIndexAgeDay     int
-	nodeAffinity      bool
-	errorLogAlert     bool
-}
-
-func (config *LoggingConfig) validate() error {
-	if config.esStorageSizeGi == 0 {
-		return errors.New("Elasticsearch storage size is 0.")
-	}
-	if config.esIndexAgeDay == 0 {
-		return errors.New("Index age is 0.")
-	}
-	return nil
-}
-
-var installLocalPathProvisioner = false
-var installNfsProvisioner = false
-var installPrometheus = false
-var installLogging = false
-
-var nfsProvisionerConfig = NfsProvisionerConfig{
-	server:       "",
-	path:         "/",
-	mountOptions: "vers=3,nolock,proto=tcp,rsize=1048576,wsize=1048576,hard,timeo=600,retrans=2,noresvport",
-}
-
-var prometheusConfig = PrometheusConfig{
-	alertmanagerStorageSizeGi: 10,
-	grafanaStorageSizeGi:      5,
-	prometheusStorageSizeGi:   10,
-	storageClass:              "",
-}
-
-var loggingConfig = LoggingConfig{
-	collectNamespaces: "",
-	storageClass:      "",
-	esStorageSizeGi:   20,
-	esIndexAgeDay:     7,
-	nodeAffinity:      true,
-	errorLogAlert:     false,
-}
-
 var storageClasses []string
-var packages = []string{"Local-Path Provisioner", "NFS Provisioner", "Prometheus", "Logging"}
+var packages = []string{"Ingress Controller", "Local-Path Provisioner", "NFS Provisioner", "Prometheus", "Logging"}
 var listPackages = tview.NewList()
 var formPackage = tview.NewForm()
 
 func initFlexPackages() {
-	storageClasses = getStorageClasses()
+	var err error
+	storageClasses, err = engine.GetStorageClasses()
+	check(err)
 	flexPackages.Clear()
 	flexList := tview.NewFlex()
 	flexList.SetTitle("Packages").SetBorder(true)
@@ -116,17 +35,27 @@ func initFlexPackages() {
 
 	formDown := tview.NewForm()
 	formDown.AddButton("Next", func() {
-		if installNfsProvisioner {
-			err := nfsProvisionerConfig.validate()
+		if config.InstallIngressNginx {
+			err := config.IngressNginx.Validate()
+			if err != nil {
+				showErrorModal(err.Error())
+				return
+			}
+		}
+
+		if config.InstallNfsProvisioner {
+			err := config.NfsProvisioner.Validate()
 			if err != nil {
 				showErrorModal(err.Error())
+				return
 			}
 		}
 
-		if installPrometheus {
-			err := prometheusConfig.validate()
+		if config.InstallPrometheus {
+			err := config.Prometheus.Validate()
 			if err != nil {
 				showErrorModal(err.Error())
+				return
 			}
 		}
 
@@ -155,107 +84,124 @@ func selectPackage(index int, mainText string) {
 	formPackage.Clear(true)
 	listPackages.SetItemText(index, mainText, "")
 	switch mainText {
+	case "Ingress Controller":
+		formPackage.AddCheckbox("Install Ingress-nginx: ", config.InstallIngressNginx, func(checked bool) {
+			config.InstallIngressNginx = checked
+			selectPackage(index, mainText)
+		})
+		if config.InstallIngressNginx {
+			listPackages.SetItemText(index, mainText, "Will install")
+
+			exposures := []string{engine.IngressNginxExposures.HostNetwork, engine.IngressNginxExposures.NodePort,
+				engine.IngressNginxExposures.LoadBalancer}
+			initialOption := slices.Index(exposures, config.IngressNginx.Exposure)
+			formPackage.AddDropDown("Exposure: ", exposures, initialOption, func(option string, optionIndex int) {
+				if config.IngressNginx.Exposure != option {
+					config.IngressNginx.Exposure = option
+					selectPackage(index, mainText)
+				}
+			})
+			if config.IngressNginx.Exposure == engine.IngressNginxExposures.NodePort {
+				formPackage.AddInputField("HTTP node port: ", strconv.Itoa(config.IngressNginx.HttpNodePort),
+					0, nil, func(text string) {
+						config.IngressNginx.HttpNodePort, _ = strconv.Atoi(text)
+					})
+				formPackage.AddInputField("HTTPS node port: ", strconv.Itoa(config.IngressNginx.HttpsNodePort),
+					0, nil, func(text string) {
+						config.IngressNginx.HttpsNodePort, _ = strconv.Atoi(text)
+					})
+			}
+			formPackage.AddCheckbox("Default IngressClass: ", config.IngressNginx.DefaultClass, func(checked bool) {
+				config.IngressNginx.DefaultClass = checked
+			})
+		} else if len(ingressClasses) == 0 {
+			formPackage.AddTextView("", "No ingress controller found in the cluster.", 0, 1, false, false)
+		}
 	case "Local-Path Provisioner":
-		formPackage.AddCheckbox("Install Local-Path Provisioner: ", installLocalPathProvisioner, func(checked bool) {
-			installLocalPathProvisioner = checked
+		formPackage.AddCheckbox("Install Local-Path Provisioner: ", config.InstallLocalPathProvisioner, func(checked bool) {
+			config.InstallLocalPathProvisioner = checked
 			selectPackage(index, mainText)
 		})
-		if installLocalPathProvisioner {
+		if config.InstallLocalPathProvisioner {
 			listPackages.SetItemText(index, mainText, "Will install")
 		}
 	case "NFS Provisioner":
-		formPackage.AddCheckbox("Install NFS Provisioner: ", installNfsProvisioner, func(checked bool) {
-			installNfsProvisioner = checked
+		formPackage.AddCheckbox("Install NFS Provisioner: ", config.InstallNfsProvisioner, func(checked bool) {
+			config.InstallNfsProvisioner = checked
 			selectPackage(index, mainText)
 		})
-		if installNfsProvisioner {
+		if config.InstallNfsProvisioner {
 			listPackages.SetItemText(index, mainText, "Will install")
-			formPackage.AddInputField("Server: ", nfsProvisionerConfig.server,
+			formPackage.AddInputField("Server: ", config.NfsProvisioner.Server,
 				0, nil, func(text string) {
-					nfsProvisionerConfig.server = text
+					config.NfsProvisioner.Server = text
 				})
-			formPackage.AddInputField("Path: ", nfsProvisionerConfig.path,
+			formPackage.AddInputField("Path: ", config.NfsProvisioner.Path,
 				0, nil, func(text string) {
-					nfsProvisionerConfig.path = text
+					config.NfsProvisioner.Path = text
 				})
-			formPackage.AddInputField("Mount options: ", nfsProvisionerConfig.mountOptions,
+			formPackage.AddInputField("Mount options: ", config.NfsProvisioner.MountOptions,
 				0, nil, func(text string) {
-					nfsProvisionerConfig.mountOptions = text
+					config.NfsProvisioner.MountOptions = text
 				})
 		}
 	case "Prometheus":
-		formPackage.AddCheckbox("Install Prometheus: ", installPrometheus, func(checked bool) {
-			installPrometheus = checked
+		formPackage.AddCheckbox("Install Prometheus: ", config.InstallPrometheus, func(checked bool) {
+			config.InstallPrometheus = checked
 			selectPackage(index, mainText)
 		})
-		if installPrometheus {
+		if config.InstallPrometheus {
 			listPackages.SetItemText(index, mainText, "Will install")
 
-			initialOption := slices.Index(storageClasses, prometheusConfig.storageClass)
+			initialOption := slices.Index(storageClasses, config.Prometheus.StorageClass)
 			formPackage.AddDropDown("Storage Class: ", storageClasses, initialOption, func(option string, optionIndex int) {
-				prometheusConfig.storageClass = option
+				config.Prometheus.StorageClass = option
 			})
-			formPackage.AddInputField("Alert manager storage size (Gi): ", strconv.Itoa(prometheusConfig.alertmanagerStorageSizeGi),
+			formPackage.AddInputField("Alert manager storage size (Gi): ", strconv.Itoa(config.Prometheus.AlertmanagerStorageSizeGi),
 				0, nil, func(text string) {
-					prometheusConfig.alertmanagerStorageSizeGi, _ = strconv.Atoi(text)
+					config.Prometheus.AlertmanagerStorageSizeGi, _ = strconv.Atoi(text)
 				})
-			formPackage.AddInputField("Grafana storage size (Gi): ", strconv.Itoa(prometheusConfig.grafanaStorageSizeGi),
+			formPackage.AddInputField("Grafana storage size (Gi): ", strconv.Itoa(config.Prometheus.GrafanaStorageSizeGi),
 				0, nil, func(text string) {
-					prometheusConfig.grafanaStorageSizeGi, _ = strconv.Atoi(text)
+					config.Prometheus.GrafanaStorageSizeGi, _ = strconv.Atoi(text)
 				})
-			formPackage.AddInputField("Prometheus storage size (Gi): ", strconv.Itoa(prometheusConfig.prometheusStorageSizeGi),
+			formPackage.AddInputField("Prometheus storage size (Gi): ", strconv.Itoa(config.Prometheus.PrometheusStorageSizeGi),
 				0, nil, func(text string) {
-					prometheusConfig.prometheusStorageSizeGi, _ = strconv.Atoi(text)
+					config.Prometheus.PrometheusStorageSizeGi, _ = strconv.Atoi(text)
 				})
+			addAlerting(index, mainText)
 		}
 	case "Logging":
-		formPackage.AddCheckbox("Install Logging: ", installLogging, func(checked bool) {
-			installLogging = checked
+		formPackage.AddCheckbox("Install Logging: ", config.InstallLogging, func(checked bool) {
+			config.InstallLogging = checked
 			selectPackage(index, mainText)
 		})
-		if installLogging {
+		if config.InstallLogging {
 			listPackages.SetItemText(index, mainText, "Will install")
 
-			formPackage.AddInputField("Collect logs from namespaces\n (comma separated, empty means all): ", loggingConfig.collectNamespaces,
+			formPackage.AddInputField("Collect logs from namespaces\n (comma separated, empty means all): ", config.Logging.CollectNamespaces,
 				0, nil, func(text string) {
-					loggingConfig.collectNamespaces = text
+					config.Logging.CollectNamespaces = text
 				})
 
-			initialOption := slices.Index(storageClasses, loggingConfig.storageClass)
+			initialOption := slices.Index(storageClasses, config.Logging.StorageClass)
 			formPackage.AddDropDown("Storage Class: ", storageClasses, initialOption, func(option string, optionIndex int) {
-				loggingConfig.storageClass = option
+				config.Logging.StorageClass = option
 			})
-			formPackage.AddInputField("Elasticsearch storage size (Gi): ", strconv.Itoa(loggingConfig.esStorageSizeGi),
+			formPackage.AddInputField("Elasticsearch storage size (Gi): ", strconv.Itoa(config.Logging.EsStorageSizeGi),
 				0, nil, func(text string) {
-					loggingConfig.esStorageSizeGi, _ = strconv.Atoi(text)
+					config.Logging.EsStorageSizeGi, _ = strconv.Atoi(text)
 				})
-			formPackage.AddInputField("Index age (day): ", strconv.Itoa(loggingConfig.esIndexAgeDay),
+			formPackage.AddInputField("Index age (day): ", strconv.Itoa(config.Logging.EsIndexAgeDay),
 				0, nil, func(text string) {
-					loggingConfig.esIndexAgeDay, _ = strconv.Atoi(text)
+					config.Logging.EsIndexAgeDay, _ = strconv.Atoi(text)
 				})
-			formPackage.AddCheckbox("Node affinity: ", loggingConfig.nodeAffinity, func(checked bool) {
-				loggingConfig.nodeAffinity = checked
+			formPackage.AddCheckbox("Node affinity: ", config.Logging.NodeAffinity, func(checked bool) {
+				config.Logging.NodeAffinity = checked
 			})
-			formPackage.AddCheckbox("Send alert when ERROR level log detected: ", loggingConfig.errorLogAlert, func(checked bool) {
-				loggingConfig.errorLogAlert = checked
+			formPackage.AddCheckbox("Send alert when ERROR level log detected: ", config.Logging.ErrorLogAlert, func(checked bool) {
+				config.Logging.ErrorLogAlert = checked
 			})
 		}
 	}
 }
-
-func getStorageClasses() []string {
-	var storageClasses []string
-
-	result, err := execCommand("kubectl get sc --no-headers -o custom-columns=\":metadata.name\"", 0)
-	check(err)
-	storageClasses = strings.Split(strings.TrimSpace(string(result)), "\n")
-
-	if !slices.Contains(storageClasses, "local-path") {
-		storageClasses = append(storageClasses, "local-path")
-	}
-	if !slices.Contains(storageClasses, "nfs-client") {
-		storageClasses = append(storageClasses, "nfs-client")
-	}
-
-	return storageClasses
-}
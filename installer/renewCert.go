@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"om-kits-installer/engine"
+	"os"
+)
+
+// renewCert implements the renew-cert command, which reissues the server certificate of the
+// internal CA before it expires. It is meant to be run periodically, e.g. from cron.
+func renewCert(args []string) int {
+	flags := flag.NewFlagSet("renew-cert", flag.ExitOnError)
+	renewBeforeDays := flags.Int("before-days", 30, "renew when the certificate expires within this number of days")
+	_ = flags.Parse(args)
+
+	err := engine.Preflight()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	namespaces, err := engine.GetSecretNamespaces(engine.TlsSecretName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't find the TLS secrets: "+err.Error())
+		return 1
+	}
+
+	executor := &engine.Executor{
+		Dir: appPath,
+		OnOutput: func(index int, line string) {
+			fmt.Println(line)
+		},
+		OnTaskFinish: func(index int, result engine.TaskResult) {
+			if result.Err != nil {
+				fmt.Fprint(os.Stderr, result.Stderr)
+			}
+		},
+	}
+	err = executor.Run(context.Background(), engine.NewRenewCertPlan(*renewBeforeDays, namespaces))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
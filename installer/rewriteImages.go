@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"om-kits-installer/engine"
+	"os"
+	"strings"
+)
+
+// rewriteImages implements the rewrite-images command, the helm post-renderer which pulls every
+// image of the rendered manifests from the mirror of its registry. The mirrors are read from
+// IDO_REGISTRY_REWRITES, the images no mirror covers are appended to IDO_IMAGE_REWRITE_REPORT.
+func rewriteImages() int {
+	rewrites := engine.ParseRewrites(os.Getenv("IDO_REGISTRY_REWRITES"))
+	skipped, err := engine.RewriteImages(os.Stdin, os.Stdout, rewrites)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	report := os.Getenv("IDO_IMAGE_REWRITE_REPORT")
+	if report != "" && len(skipped) > 0 {
+		file, err := os.OpenFile(report, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		_, err = file.WriteString(strings.Join(skipped, "\n") + "\n")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"om-kits-installer/engine"
+	"os"
+	"strings"
+	"time"
+)
+
+// testAlert implements the test-alert command, which sends a test alert to the receivers of the
+// last install and reports whether Alertmanager delivered it, failing when it didn't.
+// -alertmanager points it at another Alertmanager, e.g. a local one whose receivers are an HTTP
+// stand-in.
+func testAlert(args []string) int {
+	flags := flag.NewFlagSet("test-alert", flag.ExitOnError)
+	alertmanagerUrl := flags.String("alertmanager", "",
+		"the Alertmanager URL with its route prefix, a port-forward to the installed one by default")
+	receiverNames := flags.String("receivers", "", "the receivers to test, separated by commas, all by default")
+	timeout := flags.Duration("timeout", 2*time.Minute, "how long the notification of a receiver is waited for")
+	_ = flags.Parse(args)
+
+	records, err := engine.LoadRunRecords(historyDir())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Can't load the history: "+err.Error())
+		return 1
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(os.Stderr, "No install found in "+historyDir()+".")
+		return 1
+	}
+	// Only the names, types and routes of the receivers are needed, which the history keeps
+	configured := records[0].Config.Prometheus.Receivers
+	receivers := configured
+	if *receiverNames != "" {
+		var selected []engine.AlertReceiver
+		for _, name := range strings.Split(*receiverNames, ",") {
+			name = strings.TrimSpace(name)
+			found := false
+			for _, receiver := range configured {
+				if receiver.Name == name {
+					selected = append(selected, receiver)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintln(os.Stderr, "Receiver "+name+" isn't in the last install.")
+				return 2
+			}
+		}
+		receivers = selected
+	}
+	if len(receivers) == 0 {
+		fmt.Fprintln(os.Stderr, "The last install has no receiver.")
+		return 1
+	}
+
+	tester := engine.NewAlertTester(*alertmanagerUrl)
+	tester.Timeout = *timeout
+	tester.Receivers = configured
+	results, err := engine.VerifyReceivers(context.Background(), tester, receivers, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for _, result := range results {
+		if !result.Delivered {
+			return 1
+		}
+	}
+	return 0
+}
@@ -1,11 +1,7 @@
 package main
 
 import (
-	"context"
 	"github.com/rivo/tview"
-	"os"
-	"os/exec"
-	"time"
 )
 
 func check(e error) {
@@ -15,28 +11,6 @@ func check(e error) {
 	}
 }
 
-func execCommand(cmdString string, timeout int, envs ...string) ([]byte, error) {
-	var cmd *exec.Cmd
-
-	if timeout > 0 {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-		defer cancel()
-
-		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", cmdString)
-	} else {
-		cmd = exec.Command("/bin/sh", "-c", cmdString)
-	}
-
-	cmd.Env = os.Environ()
-	for _, env := range envs {
-		cmd.Env = append(cmd.Env, env)
-	}
-
-	output, err := cmd.CombinedOutput()
-
-	return output, err
-}
-
 func showErrorModal(text string) {
 	modalError := tview.NewModal()
 	currentPage, _ := pages.GetFrontPage()
@@ -57,16 +31,11 @@ func showQuitModal() {
 				pages.SwitchToPage(currentPage)
 			}
 			if buttonLabel == "Quit" {
-				app.Stop()
+				// The running task is terminated before the installer exits, not orphaned
+				modalQuit.ClearButtons()
+				modalQuit.SetText("Stopping the running task...")
+				stopInstallSession(app.Stop)
 			}
 		})
 	pages.SwitchToPage("Quit")
 }
-
-//func initLog(prefix string) {
-//	now := time.Now()
-//	suffix := fmt.Sprintf("%d%02d%02dT%02d%02d%02d",
-//		now.Year(), now.Month(), now.Day(),
-//		now.Hour(), now.Minute(), now.Second())
-//	logFilePath = filepath.Join(projectPath, prefix+suffix+".log")
-//}
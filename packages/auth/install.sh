@@ -0,0 +1,39 @@
+#! /bin/bash
+set -euao pipefail
+
+base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
+
+echo "##########################################################################"
+echo "### Configure Access Control ###"
+
+# ingress-nginx and HAProxy Ingress read the key auth, Traefik the key users, HAProxy one key per user
+if [ "${IDO_ACCESS_CONTROL}" == "basic-auth" ]; then
+  password_hash=$(cut -d: -f2- "${IDO_BASIC_AUTH_FILE}")
+  for namespace in ${IDO_INGRESS_NAMESPACES}; do
+    kubectl create namespace "${namespace}" --dry-run=client -o yaml | kubectl apply -f -
+    kubectl create secret generic "${IDO_BASIC_AUTH_SECRET}" --namespace "${namespace}" \
+      --from-file=auth="${IDO_BASIC_AUTH_FILE}" --from-file=users="${IDO_BASIC_AUTH_FILE}" \
+      --from-literal="${IDO_BASIC_AUTH_USERNAME}"="${password_hash}" --dry-run=client -o yaml | kubectl apply -f -
+  done
+fi
+
+if [ "${IDO_ACCESS_CONTROL}" == "oauth2-proxy" ]; then
+  kubectl create namespace "${IDO_OAUTH2_PROXY_NAMESPACE}" --dry-run=client -o yaml | kubectl apply -f -
+
+  # Keep the cookie secret of a previous install, users stay signed in
+  cookie_secret=$(kubectl get secret oauth2-proxy --namespace "${IDO_OAUTH2_PROXY_NAMESPACE}" \
+    -o jsonpath='{.data.cookie-secret}' 2>/dev/null | base64 -d || true)
+  if [ "${cookie_secret}" == "" ]; then
+    cookie_secret=$(head -c 16 /dev/urandom | od -An -tx1 | tr -d ' \n')
+  fi
+  kubectl create secret generic oauth2-proxy --namespace "${IDO_OAUTH2_PROXY_NAMESPACE}" \
+    --from-literal=client-id="${IDO_OIDC_CLIENT_ID}" --from-literal=client-secret="${IDO_OIDC_CLIENT_SECRET}" \
+    --from-literal=cookie-secret="${cookie_secret}" --dry-run=client -o yaml | kubectl apply -f -
+
+  envsubst < "${base}/values-oauth2-proxy-override.yaml" > "${base}/values-oauth2-proxy.yaml"
+  "${base}/../check-undefined-env.sh" "${base}/values-oauth2-proxy.yaml"
+  helm upgrade oauth2-proxy --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace "${IDO_OAUTH2_PROXY_NAMESPACE}" --wait --timeout 30m \
+    --repo https://oauth2-proxy.github.io/manifests --version 6.19.1 \
+    -f "${base}"/values-oauth2-proxy.yaml oauth2-proxy
+fi
@@ -0,0 +1,53 @@
+#! /bin/bash
+set -euao pipefail
+
+base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
+
+echo "##########################################################################"
+echo "### Install Cert-manager ###"
+
+envsubst < "${base}/values-override.yaml" > "${base}/values.yaml"
+"${base}/../check-undefined-env.sh" "${base}/values.yaml"
+
+# Install cert-manager, ingresses annotated with kubernetes.io/tls-acme use the cluster issuer
+helm upgrade cert-manager --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace cert-manager --wait --timeout 30m \
+  --repo https://charts.jetstack.io --version v1.13.2 \
+  -f "${base}"/values.yaml \
+  --set installCRDs=true \
+  --set image.repository="${IDO_QUAY_CONTAINER_MIRROR}"/jetstack/cert-manager-controller \
+  --set webhook.image.repository="${IDO_QUAY_CONTAINER_MIRROR}"/jetstack/cert-manager-webhook \
+  --set cainjector.image.repository="${IDO_QUAY_CONTAINER_MIRROR}"/jetstack/cert-manager-cainjector \
+  --set acmesolver.image.repository="${IDO_QUAY_CONTAINER_MIRROR}"/jetstack/cert-manager-acmesolver \
+  --set startupapicheck.image.repository="${IDO_QUAY_CONTAINER_MIRROR}"/jetstack/cert-manager-ctl \
+  --set ingressShim.defaultIssuerName="${IDO_ACME_ISSUER}" \
+  --set ingressShim.defaultIssuerKind=ClusterIssuer \
+  cert-manager
+
+# Credentials of the DNS-01 provider
+if [ "${IDO_ACME_DNS_SECRET_KEY}" != "" ]; then
+  kubectl create secret generic "${IDO_ACME_ISSUER}"-dns --namespace cert-manager \
+    --from-literal="${IDO_ACME_DNS_SECRET_KEY}"="${IDO_ACME_DNS_SECRET_VALUE}" --dry-run=client -o yaml | kubectl apply -f -
+fi
+
+# Install cluster issuer
+envsubst < "${base}/cluster-issuer-template.yaml" > "${base}/cluster-issuer.yaml"
+"${base}/../check-undefined-env.sh" "${base}/cluster-issuer.yaml"
+kubectl apply -f "${base}"/cluster-issuer.yaml
+
+# The issuer is ready once the ACME server registered its account
+if ! kubectl wait --for=condition=Ready clusterissuer/"${IDO_ACME_ISSUER}" --timeout=3m; then
+  kubectl describe clusterissuer "${IDO_ACME_ISSUER}"
+  echo "ClusterIssuer ${IDO_ACME_ISSUER} isn't ready, check the ACME server and email." >&2
+  exit 1
+fi
+
+# Request the wildcard certificate in every namespace with ingresses
+if [ "${IDO_ACME_WILDCARD}" == "true" ]; then
+  for IDO_CERT_NAMESPACE in ${IDO_TLS_NAMESPACES}; do
+    kubectl create namespace "${IDO_CERT_NAMESPACE}" --dry-run=client -o yaml | kubectl apply -f -
+    envsubst < "${base}/certificate-template.yaml" > "${base}/certificate.yaml"
+    "${base}/../check-undefined-env.sh" "${base}/certificate.yaml"
+    kubectl apply -f "${base}"/certificate.yaml
+  done
+fi
@@ -33,4 +33,12 @@ while [ "$result" != "" ]; do
   result=${new_result}
 done
 
+# Images of registries without a mirror, reported by the post-renderer
+if [ -s "${IDO_IMAGE_REWRITE_REPORT}" ]; then
+  echo "##########################################################################"
+  echo "These images are pulled from registries without a mirror:"
+  sort -u "${IDO_IMAGE_REWRITE_REPORT}"
+fi
+rm -f "${IDO_IMAGE_REWRITE_REPORT}"
+
 echo "Done!"
\ No newline at end of file
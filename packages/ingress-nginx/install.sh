@@ -0,0 +1,15 @@
+#! /bin/bash
+set -euao pipefail
+
+base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
+
+echo "##########################################################################"
+echo "### Install Ingress-nginx ###"
+
+# Install ingress-nginx, the other kits create their ingresses with its class
+envsubst < "${base}/values-override.yaml" > "${base}/values.yaml"
+"${base}/../check-undefined-env.sh" "${base}/values.yaml"
+helm upgrade ingress-nginx --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace ingress-nginx --wait --timeout 30m \
+  --repo https://kubernetes.github.io/ingress-nginx --version 4.8.3 \
+  -f "${base}"/values.yaml ingress-nginx
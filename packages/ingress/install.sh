@@ -0,0 +1,34 @@
+#! /bin/bash
+set -euao pipefail
+
+base=$(dirname "$0")
+
+echo "##########################################################################"
+echo "### Prepare Ingress ###"
+
+# Traefik v2.10+ serves the middlewares under traefik.io, older versions under traefik.containo.us
+if kubectl get crd middlewares.traefik.io > /dev/null 2>&1; then
+  IDO_TRAEFIK_API_VERSION=traefik.io/v1alpha1
+else
+  IDO_TRAEFIK_API_VERSION=traefik.containo.us/v1alpha1
+fi
+
+# Create the middlewares referenced by the router.middlewares annotation of the ingresses
+for IDO_INGRESS_NAMESPACE in ${IDO_INGRESS_NAMESPACES}; do
+  kubectl create namespace "${IDO_INGRESS_NAMESPACE}" --dry-run=client -o yaml | kubectl apply -f -
+  envsubst < "${base}/body-size-template.yaml" > "${base}/body-size.yaml"
+  "${base}/../check-undefined-env.sh" "${base}/body-size.yaml"
+  kubectl apply -f "${base}"/body-size.yaml
+
+  if [ "${IDO_TLS_KEY}" == "tls" ] && [ "${IDO_FORCE_SSL_REDIRECT}" == "true" ]; then
+    envsubst < "${base}/redirect-https-template.yaml" > "${base}/redirect-https.yaml"
+    "${base}/../check-undefined-env.sh" "${base}/redirect-https.yaml"
+    kubectl apply -f "${base}"/redirect-https.yaml
+  fi
+
+  if [ "${IDO_ACCESS_CONTROL}" == "basic-auth" ]; then
+    envsubst < "${base}/basic-auth-template.yaml" > "${base}/basic-auth.yaml"
+    "${base}/../check-undefined-env.sh" "${base}/basic-auth.yaml"
+    kubectl apply -f "${base}"/basic-auth.yaml
+  fi
+done
@@ -2,6 +2,7 @@
 set -euao pipefail
 
 base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
 
 echo "##########################################################################"
 echo "### Install Logging ###"
@@ -15,16 +16,16 @@ fi
 
 envsubst < "${base}/values-elasticsearch-override.yaml" > "${base}/values-elasticsearch.yaml"
 "${base}/../check-undefined-env.sh" "${base}/values-elasticsearch.yaml"
-helm upgrade elasticsearch --install --create-namespace --namespace logging --wait --timeout 30m -f "${base}"/values-elasticsearch.yaml "${base}"/elasticsearch
+helm upgrade elasticsearch --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace logging --wait --timeout 30m -f "${base}"/values-elasticsearch.yaml "${base}"/elasticsearch
 
 # Install fluent-bit
-envsubst '${IDO_FLUENT_LOG_PATH}, ${IDO_FLUENT_ALERT_LOG_LEVEL}' < "${base}/values-fluent-bit-override.yaml" > "${base}/values-fluent-bit.yaml"
+envsubst '${IDO_FLUENT_LOG_PATH}, ${IDO_FLUENT_ALERT_LOG_LEVEL}, ${IDO_TIMEZONE}, ${IDO_IMAGE_PULL_SECRETS}' < "${base}/values-fluent-bit-override.yaml" > "${base}/values-fluent-bit.yaml"
 "${base}/../check-undefined-env.sh" "${base}/values-fluent-bit.yaml"
-helm upgrade fluent-bit --install --create-namespace --namespace logging --timeout 30m -f "${base}"/values-fluent-bit.yaml "${base}"/fluent-bit
+helm upgrade fluent-bit --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace logging --timeout 30m -f "${base}"/values-fluent-bit.yaml "${base}"/fluent-bit
 
 # Install fluent-bit-to-alertmanager
 if [ "$IDO_FLUENT_ALERT_LOG_LEVEL" != "none" ]; then
   envsubst < "${base}/values-fluent-bit-to-alertmanager-override.yaml" > "${base}/values-fluent-bit-to-alertmanager.yaml"
   "${base}/../check-undefined-env.sh" "${base}/values-fluent-bit-to-alertmanager.yaml"
-  helm upgrade fluent-bit-to-alertmanager --install --create-namespace --namespace logging --timeout 30m -f "${base}"/values-fluent-bit-to-alertmanager.yaml "${base}"/fluent-bit-to-alertmanager
+  helm upgrade fluent-bit-to-alertmanager --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace logging --timeout 30m -f "${base}"/values-fluent-bit-to-alertmanager.yaml "${base}"/fluent-bit-to-alertmanager
 fi
\ No newline at end of file
@@ -0,0 +1,5 @@
+#! /bin/bash
+set -euao pipefail
+
+# Helm post-renderer pulling every image of the rendered manifests from the mirror of its registry
+exec "${IDO_INSTALLER}" rewrite-images
@@ -2,6 +2,7 @@
 set -euao pipefail
 
 base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
 
 echo "##########################################################################"
 echo "### Install Prometheus Stack ###"
@@ -9,9 +10,13 @@ echo "### Install Prometheus Stack ###"
 # Install prometheus
 envsubst < "${base}/values-override.yaml" > "${base}/values.yaml"
 "${base}/../check-undefined-env.sh" "${base}/values.yaml"
-helm upgrade prometheus --install --create-namespace --namespace monitoring --timeout 30m -f "${base}"/values.yaml "${base}"/kube-prometheus-stack
+helm upgrade prometheus --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace monitoring --timeout 30m -f "${base}"/values.yaml -f "${base}"/values-templates.json "${base}"/kube-prometheus-stack
 
-# Install dingtalk webhook
-envsubst < "${base}/values-override-dingtalk.yaml" > "${base}/values-dingtalk.yaml"
-"${base}/../check-undefined-env.sh" "${base}/values-dingtalk.yaml"
-helm upgrade prometheus-webhook-dingtalk --install --create-namespace --namespace monitoring --timeout 30m -f "${base}"/values-dingtalk.yaml "${base}"/prometheus-webhook-dingtalk
\ No newline at end of file
+# Install dingtalk webhook, which sends the alerts of the DingTalk receivers
+if [ "${IDO_DINGTALK_ENABLED}" == "true" ]; then
+  envsubst < "${base}/values-override-dingtalk.yaml" > "${base}/values-dingtalk.yaml"
+  "${base}/../check-undefined-env.sh" "${base}/values-dingtalk.yaml"
+  helm upgrade prometheus-webhook-dingtalk --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace monitoring --timeout 30m -f "${base}"/values-dingtalk.yaml -f "${base}"/values-dingtalk-templates.json "${base}"/prometheus-webhook-dingtalk
+else
+  echo "No DingTalk receiver, skip the dingtalk webhook."
+fi
@@ -0,0 +1,15 @@
+#! /bin/bash
+set -euao pipefail
+
+echo "##########################################################################"
+echo "### Create Registry Pull Secrets ###"
+
+# Every package pulls its images with the logins of the mirrors
+for namespace in ${IDO_PULL_SECRET_NAMESPACES}; do
+  kubectl create namespace "${namespace}" --dry-run=client -o yaml | kubectl apply -f -
+  kubectl create secret generic "${IDO_REGISTRY_PULL_SECRET}" --namespace "${namespace}" \
+    --type=kubernetes.io/dockerconfigjson --from-file=.dockerconfigjson="${IDO_REGISTRY_AUTH_FILE}" \
+    --dry-run=client -o yaml | kubectl apply -f -
+done
+
+rm -f "${IDO_REGISTRY_AUTH_FILE}"
@@ -7,6 +7,7 @@ echo "##########################################################################
 echo "### Install Local-Path Provisioner ###"
 
 # Install
-envsubst '${IDO_DOCKER_CONTAINER_MIRROR}' < "${base}/local-path-storage-template.yaml" > "${base}/local-path-storage.yaml"
+envsubst '${IDO_DOCKER_CONTAINER_MIRROR}, ${IDO_IMAGE_PULL_SECRETS}' < "${base}/local-path-storage-template.yaml" > "${base}/local-path-storage.yaml"
 "${base}/../../check-undefined-env.sh" "${base}/local-path-storage.yaml"
-kubectl apply -f "${base}"/local-path-storage.yaml
+chmod +x "${IDO_POST_RENDERER}"
+"${IDO_POST_RENDERER}" < "${base}"/local-path-storage.yaml | kubectl apply -f -
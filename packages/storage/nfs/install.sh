@@ -2,6 +2,7 @@
 set -euaxo pipefail
 
 base=$(dirname "$0")
+chmod +x "${IDO_POST_RENDERER}"
 
 echo "##########################################################################"
 echo "### Install NFS Provisioner ###"
@@ -9,4 +10,4 @@ echo "### Install NFS Provisioner ###"
 # Install nfs provisioner
 envsubst < "${base}/values-override.yaml" > "${base}/values.yaml"
 "${base}/../../check-undefined-env.sh" "${base}/values.yaml"
-helm upgrade nfs-subdir-external-provisioner --install --create-namespace --namespace nfs-provisioner -f "${base}"/values.yaml "${base}"/nfs-subdir-external-provisioner-chart
+helm upgrade nfs-subdir-external-provisioner --install --post-renderer "${IDO_POST_RENDERER}" --create-namespace --namespace nfs-provisioner -f "${base}"/values.yaml "${base}"/nfs-subdir-external-provisioner-chart
@@ -0,0 +1,17 @@
+#! /bin/bash
+set -euao pipefail
+
+echo "##########################################################################"
+echo "### Create TLS Secrets ###"
+
+for namespace in ${IDO_TLS_NAMESPACES}; do
+  kubectl create namespace "${namespace}" --dry-run=client -o yaml | kubectl apply -f -
+  kubectl create secret tls "${IDO_TLS_SECRET_NAME}" --namespace "${namespace}" \
+    --cert="${IDO_TLS_CERT_FILE}" --key="${IDO_TLS_KEY_FILE}" --dry-run=client -o yaml | kubectl apply -f -
+done
+
+# Keep the internal CA in the cluster, the server certificate can be reissued from it
+if [ "${IDO_TLS_CA_SECRET_NAME}" != "" ]; then
+  kubectl create secret tls "${IDO_TLS_CA_SECRET_NAME}" --namespace default \
+    --cert="${IDO_TLS_CA_CERT_FILE}" --key="${IDO_TLS_CA_KEY_FILE}" --dry-run=client -o yaml | kubectl apply -f -
+fi
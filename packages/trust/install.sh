@@ -0,0 +1,14 @@
+#! /bin/bash
+set -euao pipefail
+
+echo "##########################################################################"
+echo "### Create CA Bundle ConfigMaps ###"
+
+# The components making outbound TLS calls mount the bundle over their CA file. The bundle is too
+# large for the last-applied annotation of a client-side apply.
+for namespace in ${IDO_CA_BUNDLE_NAMESPACES}; do
+  kubectl create namespace "${namespace}" --dry-run=client -o yaml | kubectl apply -f -
+  kubectl create configmap "${IDO_CA_BUNDLE_CONFIGMAP}" --namespace "${namespace}" \
+    --from-file=ca-bundle.crt="${IDO_CA_BUNDLE_FILE}" --dry-run=client -o yaml | \
+    kubectl apply --server-side --force-conflicts -f -
+done